@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/Azure/run-command-handler-linux/internal/cli"
 	commands "github.com/Azure/run-command-handler-linux/internal/cmds"
 	"github.com/Azure/run-command-handler-linux/internal/commandProcessor"
 	"github.com/Azure/run-command-handler-linux/internal/types"
@@ -23,6 +24,13 @@ func main() {
 	// After starting the program, vars from versionutil.go must be set in order to share those values across the program.
 	versionutil.Initialize(Version, GitCommit, BuildDate, GitState)
 
+	// The tools for the users of the VM take arguments, they are not commands of the agent
+	if len(os.Args) > 1 {
+		if tool, ok := cli.Lookup(os.Args[1]); ok {
+			os.Exit(tool.Run(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	// parse command line arguments
 	cmd := parseCmd(os.Args)
 	err := commandProcessor.ProcessHandlerCommand(cmd)
@@ -59,6 +67,7 @@ func parseCmd(args []string) types.Cmd {
 func printUsage(args []string) {
 	cmds := commands.Cmds
 	printCommandsUsage(cmds)
+	cli.PrintUsage(os.Stdout, os.Args[0])
 	fmt.Println(versionutil.DetailedVersionString())
 }
 
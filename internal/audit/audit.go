@@ -0,0 +1,183 @@
+// Package audit keeps a tamper-evident log of the executions of the handler. Every record contains the hash of the
+// previous one, and the head file the number of records and the hash of the last one, so editing, removing or
+// reordering records and truncating the log are detected by Verify.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FileName is the audit log under the data directory, one JSON record per line, readable by root only
+	FileName = "audit.log"
+
+	// HeadFileName records the number of records of the audit log and the hash of the last one
+	HeadFileName = "audit.head"
+)
+
+// Record describes an execution. It never contains the values of the parameters nor the SAS of the URIs.
+type Record struct {
+	Index          int                  `json:"index"`
+	Timestamp      string               `json:"timestamp"`
+	ExtensionName  string               `json:"extensionName"`
+	SeqNum         int                  `json:"seqNum"`
+	SourceType     string               `json:"sourceType"`         // script, scriptUri or steps
+	URIHosts       []string             `json:"uriHosts,omitempty"` // hosts of the script URIs
+	ScriptSHA256   []string             `json:"scriptSha256,omitempty"`
+	User           string               `json:"user,omitempty"`
+	ParameterNames []string             `json:"parameterNames,omitempty"`
+	State          types.ExecutionState `json:"state"`
+	ExitCode       int                  `json:"exitCode"`
+	PreviousHash   string               `json:"previousHash"`
+	Hash           string               `json:"hash,omitempty"`
+}
+
+// head is the content of the head file.
+type head struct {
+	Count    int    `json:"count"`
+	LastHash string `json:"lastHash"`
+}
+
+// hash returns the hex encoded SHA-256 of the record without its hash.
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal the audit record")
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Path returns the path of the audit log under the data directory.
+func Path(dataDir string) string {
+	return filepath.Join(dataDir, FileName)
+}
+
+// Append chains the record to the last one of the audit log and appends it. The index and the hashes of the
+// record are set by Append.
+func Append(dataDir string, record Record) error {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return errors.Wrap(err, "failed to create the data directory")
+	}
+	f, err := os.OpenFile(Path(dataDir), os.O_CREATE|os.O_RDWR|os.O_APPEND|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open the audit log")
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return errors.Wrap(err, "failed to lock the audit log")
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	h, err := readHead(dataDir)
+	if err != nil {
+		return err
+	}
+	record.Index, record.PreviousHash = h.Count, h.LastHash
+	if record.Hash, err = record.hash(); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the audit record")
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "failed to append to the audit log")
+	}
+	return writeHead(dataDir, head{Count: h.Count + 1, LastHash: record.Hash})
+}
+
+// Verify checks the chain of the records of the audit log and returns the number of records. The error describes
+// the first record which was edited, removed or reordered, or the truncation of the log.
+func Verify(dataDir string) (int, error) {
+	h, err := readHead(dataDir)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(Path(dataDir))
+	if os.IsNotExist(err) {
+		if h.Count != 0 {
+			return 0, fmt.Errorf("the audit log is missing, %d records expected", h.Count)
+		}
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "failed to open the audit log")
+	}
+	defer f.Close()
+
+	count, previousHash := 0, ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for ; scanner.Scan(); count++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return count, fmt.Errorf("line %d is not an audit record", count+1)
+		}
+		if record.Index != count {
+			return count, fmt.Errorf("record %d has index %d, records were removed or reordered", count, record.Index)
+		}
+		if record.PreviousHash != previousHash {
+			return count, fmt.Errorf("record %d is not chained to the previous record, records were removed or reordered", count)
+		}
+		if sum, err := record.hash(); err != nil {
+			return count, err
+		} else if sum != record.Hash {
+			return count, fmt.Errorf("record %d was modified, its hash does not match its content", count)
+		}
+		previousHash = record.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return count, errors.Wrap(err, "failed to read the audit log")
+	}
+
+	if count != h.Count || previousHash != h.LastHash {
+		return count, fmt.Errorf("the audit log was truncated or extended, %d records found but %d expected", count, h.Count)
+	}
+	return count, nil
+}
+
+func readHead(dataDir string) (h head, _ error) {
+	b, err := os.ReadFile(filepath.Join(dataDir, HeadFileName))
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return h, errors.Wrap(err, "failed to read the head of the audit log")
+	}
+	if err := json.Unmarshal(b, &h); err != nil {
+		return h, errors.Wrap(err, "failed to parse the head of the audit log")
+	}
+	return h, nil
+}
+
+// writeHead replaces the head file, atomically.
+func writeHead(dataDir string, h head) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the head of the audit log")
+	}
+	tmp, err := os.CreateTemp(dataDir, HeadFileName)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the head of the audit log")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write the head of the audit log")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write the head of the audit log")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), filepath.Join(dataDir, HeadFileName)), "failed to replace the head of the audit log")
+}
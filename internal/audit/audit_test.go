@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/stretchr/testify/require"
+)
+
+func appendRecords(t *testing.T, dataDir string, n int) {
+	for i := 0; i < n; i++ {
+		require.Nil(t, Append(dataDir, Record{ExtensionName: "name", SeqNum: i, SourceType: "script", State: types.Succeeded}))
+	}
+}
+
+func readLines(t *testing.T, dataDir string) [][]byte {
+	b, err := os.ReadFile(Path(dataDir))
+	require.Nil(t, err)
+	return bytes.SplitAfter(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+}
+
+func writeLines(t *testing.T, dataDir string, lines [][]byte) {
+	require.Nil(t, os.WriteFile(Path(dataDir), bytes.Join(lines, nil), 0600))
+}
+
+func Test_Verify_empty(t *testing.T) {
+	count, err := Verify(t.TempDir())
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+}
+
+func Test_Append(t *testing.T) {
+	dataDir := t.TempDir()
+	appendRecords(t, dataDir, 3)
+
+	count, err := Verify(dataDir)
+	require.Nil(t, err)
+	require.Equal(t, 3, count)
+
+	fi, err := os.Stat(Path(dataDir))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600).String(), fi.Mode().String(), "readable by root only")
+}
+
+func Test_Verify_edited(t *testing.T) {
+	dataDir := t.TempDir()
+	appendRecords(t, dataDir, 3)
+	lines := readLines(t, dataDir)
+	lines[1] = bytes.Replace(lines[1], []byte(`"Succeeded"`), []byte(`"Failed"`), 1)
+	writeLines(t, dataDir, lines)
+
+	count, err := Verify(dataDir)
+	require.EqualError(t, err, "record 1 was modified, its hash does not match its content")
+	require.Equal(t, 1, count)
+}
+
+func Test_Verify_removed(t *testing.T) {
+	dataDir := t.TempDir()
+	appendRecords(t, dataDir, 3)
+	lines := readLines(t, dataDir)
+	writeLines(t, dataDir, [][]byte{lines[0], lines[2]})
+
+	_, err := Verify(dataDir)
+	require.EqualError(t, err, "record 1 has index 2, records were removed or reordered")
+}
+
+func Test_Verify_truncated(t *testing.T) {
+	dataDir := t.TempDir()
+	appendRecords(t, dataDir, 3)
+	lines := readLines(t, dataDir)
+	writeLines(t, dataDir, lines[:2])
+
+	count, err := Verify(dataDir)
+	require.EqualError(t, err, "the audit log was truncated or extended, 2 records found but 3 expected")
+	require.Equal(t, 2, count)
+
+	require.Nil(t, os.Remove(Path(dataDir)))
+	_, err = Verify(dataDir)
+	require.EqualError(t, err, "the audit log is missing, 3 records expected")
+}
+
+func Test_Verify_headRemoved(t *testing.T) {
+	dataDir := t.TempDir()
+	appendRecords(t, dataDir, 2)
+	require.Nil(t, os.Remove(filepath.Join(dataDir, HeadFileName)))
+
+	_, err := Verify(dataDir)
+	require.EqualError(t, err, "the audit log was truncated or extended, 2 records found but 0 expected")
+}
@@ -5,19 +5,34 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-extension-platform/pkg/utils"
 	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/history"
 	"github.com/Azure/run-command-handler-linux/internal/types"
 	"github.com/Azure/run-command-handler-linux/pkg/linuxutils"
 	"github.com/go-kit/kit/log"
 )
 
-func ImmediateRunCommandCleanup(ctx *log.Context, metadata types.RCMetadata, h types.HandlerEnvironment, runAsUser string) {
+// ImmediateRunCommandCleanup deletes the scripts and the settings of the run command, or applies the retention
+// when the settings specify one.
+func ImmediateRunCommandCleanup(ctx *log.Context, metadata types.RCMetadata, h types.HandlerEnvironment, runAsUser string, retention *types.CleanupRetention) {
+	if retention != nil {
+		applyRetention(ctx, metadata, h, runAsUser, *retention)
+		return
+	}
 	deleteAllScriptsAndSettings(ctx, metadata, h, runAsUser)
 }
 
-func RunCommandCleanup(ctx *log.Context, metadata types.RCMetadata, h types.HandlerEnvironment, runAsUser string) {
+// RunCommandCleanup deletes the scripts and the settings of the run command except the most recent ones, or
+// applies the retention when the settings specify one.
+func RunCommandCleanup(ctx *log.Context, metadata types.RCMetadata, h types.HandlerEnvironment, runAsUser string, retention *types.CleanupRetention) {
+	if retention != nil {
+		applyRetention(ctx, metadata, h, runAsUser, *retention)
+		return
+	}
 	deleteScriptsAndSettingsExceptMostRecent(ctx, metadata, h, runAsUser)
 }
 
@@ -66,3 +81,37 @@ func deleteScriptsAndSettingsExceptMostRecent(ctx *log.Context, metadata types.R
 		}
 	}
 }
+
+// applyRetention empties the settings of the previous sequence numbers and removes the download directories of
+// the run command exceeding the retention, in the data directory and in the copy of the RunAs user. The size limit
+// applies to the directories of the run command.
+func applyRetention(ctx *log.Context, metadata types.RCMetadata, h types.HandlerEnvironment, runAsUser string, retention types.CleanupRetention) {
+	runtimeSettingsRegexFormat := metadata.ExtName + ".\\d+.settings"
+	runtimeSettingsLastSeqNumFormat := metadata.ExtName + ".%d.settings"
+	err := utils.TryClearRegexMatchingFilesExcept(h.HandlerEnvironment.ConfigFolder, runtimeSettingsRegexFormat,
+		fmt.Sprintf(runtimeSettingsLastSeqNumFormat, metadata.SeqNum), false)
+	if err != nil {
+		ctx.Log("event", "could not clear settings files", "error", err)
+	}
+
+	dirs := []RunCommandDir{{Path: metadata.DownloadPath, Name: metadata.ExtName}}
+	if runAsUser != "" {
+		dirs = append(dirs, RunCommandDir{Path: filepath.Join(fmt.Sprintf(constants.RunAsDir, runAsUser), metadata.DownloadDir), Name: metadata.ExtName})
+	}
+
+	// The data directory is the parent of the download folder, e.g. /var/lib/waagent/run-command-handler
+	records, err := history.Read(filepath.Clean(strings.TrimSuffix(metadata.DownloadPath, metadata.DownloadDir)))
+	if err != nil {
+		ctx.Log("message", "failed to read the history, failures are not kept", "error", err)
+	}
+	removals, err := Sweep{Retention: retention, History: records}.Collect(dirs, time.Now())
+	if err != nil {
+		ctx.Log("event", "could not apply the retention", "error", err)
+		return
+	}
+	reclaimed, err := Remove(removals)
+	if err != nil {
+		ctx.Log("event", "could not remove the download directories", "error", err)
+	}
+	ctx.Log("message", fmt.Sprintf("removed %d download directories, %d bytes reclaimed", len(removals), reclaimed))
+}
@@ -25,7 +25,7 @@ func TestRunCommandCleanupSuccess_DeletesAllFilesExceptMostRecent(t *testing.T)
 	downloadFolder, fakeEnv, scriptFilePathsForSeqs, runtimeSettingsForSeqs := createTempScriptsAndSettingsAndGetVariables(t, dataDir, extName, seqNum)
 
 	metadata := types.NewRCMetadata(extName, seqNum, filepath.Base(downloadFolder), dataDir)
-	cleanup.RunCommandCleanup(ctx, metadata, fakeEnv, "")
+	cleanup.RunCommandCleanup(ctx, metadata, fakeEnv, "", nil)
 	checkOnlyNotMostRecentSeqFilesWereAffectedForGivenExt(t, scriptFilePathsForSeqs, runtimeSettingsForSeqs)
 }
 
@@ -41,7 +41,7 @@ func TestRunCommandCleanupSuccessMultipleExtensions_DeletesAllFilesExceptMostRec
 	_, _, scriptFilePathsNotRelatedExt, runtimeSettingsNotRelatedExt := createTempScriptsAndSettingsAndGetVariables(t, dataDir, "notRelatedExtension", seqNum)
 
 	metadata := types.NewRCMetadata(extName, seqNum, filepath.Base(downloadFolder), dataDir)
-	cleanup.RunCommandCleanup(ctx, metadata, fakeEnv, "")
+	cleanup.RunCommandCleanup(ctx, metadata, fakeEnv, "", nil)
 	checkOnlyNotMostRecentSeqFilesWereAffectedForGivenExt(t, scriptFilePathsForSeqs, runtimeSettingsForSeqs)
 	checkNotRelatedExtFilesWereNotAffected(t, scriptFilePathsNotRelatedExt, runtimeSettingsNotRelatedExt)
 }
@@ -57,7 +57,7 @@ func TestRunCommandCleanupSuccess_DeletesAllFiles(t *testing.T) {
 	downloadFolder, fakeEnv, scriptFilePathsForSeqs, runtimeSettingsForSeqs := createTempScriptsAndSettingsAndGetVariables(t, dataDir, extName, seqNum)
 
 	metadata := types.NewRCMetadata(extName, seqNum, filepath.Base(downloadFolder), dataDir)
-	cleanup.ImmediateRunCommandCleanup(ctx, metadata, fakeEnv, "")
+	cleanup.ImmediateRunCommandCleanup(ctx, metadata, fakeEnv, "", nil)
 
 	// check that all script folders and files where deleted
 	for i := 0; i < len(scriptFilePathsForSeqs); i++ {
@@ -83,7 +83,7 @@ func TestRunCommandCleanupSuccessMultipleExtensions_DeletesAllFilesForSelectedEx
 	_, _, scriptFilePathsNotRelatedExt, runtimeSettingsNotRelatedExt := createTempScriptsAndSettingsAndGetVariables(t, dataDir, "notRelatedExtension", seqNum)
 
 	metadata := types.NewRCMetadata(extName, seqNum, filepath.Base(downloadFolder), dataDir)
-	cleanup.ImmediateRunCommandCleanup(ctx, metadata, fakeEnv, "")
+	cleanup.ImmediateRunCommandCleanup(ctx, metadata, fakeEnv, "", nil)
 
 	// check that all script folders and files where deleted
 	for i := 0; i < len(scriptFilePathsForSeqs); i++ {
@@ -0,0 +1,215 @@
+package cleanup
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/history"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/pkg/errors"
+)
+
+// Reasons of the removals
+const (
+	ReasonSequences = "older than the most recent sequences"
+	ReasonOrphaned  = "run command no longer exists"
+	ReasonSize      = "size limit exceeded"
+)
+
+// Removal is a directory removed by the cleanup.
+type Removal struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"` // in bytes
+	Reason string `json:"reason"`
+}
+
+// RunCommandDir is the directory of a run command in a download folder, with a directory per sequence number.
+type RunCommandDir struct {
+	Path string
+	Name string
+}
+
+// Sweep applies the retention to the directories of run commands.
+type Sweep struct {
+	Retention types.CleanupRetention
+
+	// Executions of the history, to keep the recent failures
+	History []history.Record
+}
+
+// sequenceDir is the directory of an execution.
+type sequenceDir struct {
+	path    string
+	seqNum  int
+	size    int64
+	modTime time.Time
+	latest  bool // most recent sequence of the run command, never removed
+}
+
+// RunCommandDirs returns the directories of the run commands in the download folders. The directories of the run
+// commands missing from existing are returned as orphaned removals.
+func RunCommandDirs(folders []string, existing []string) (dirs []RunCommandDir, orphaned []Removal, _ error) {
+	exists := map[string]bool{}
+	for _, name := range existing {
+		exists[name] = true
+	}
+	for _, folder := range folders {
+		entries, err := os.ReadDir(folder)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to list the run commands of %s", folder)
+		}
+		unnamed := false
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			// Without extension name, the sequence directories are in the download folder itself
+			if _, err := strconv.Atoi(entry.Name()); err == nil {
+				unnamed = true
+				continue
+			}
+			path := filepath.Join(folder, entry.Name())
+			if exists[entry.Name()] {
+				dirs = append(dirs, RunCommandDir{Path: path, Name: entry.Name()})
+				continue
+			}
+			size, err := dirSize(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			orphaned = append(orphaned, Removal{Path: path, Size: size, Reason: ReasonOrphaned})
+		}
+		if unnamed {
+			dirs = append(dirs, RunCommandDir{Path: folder})
+		}
+	}
+	return dirs, orphaned, nil
+}
+
+// Collect returns the sequence directories of the run commands exceeding the retention. The size limit applies to
+// the directories of all the run commands, the oldest directories are removed first.
+func (s Sweep) Collect(dirs []RunCommandDir, now time.Time) ([]Removal, error) {
+	failures := map[string]time.Time{}
+	for _, r := range s.History {
+		if r.State != types.Failed && r.State != types.TimedOut {
+			continue
+		}
+		if end, err := time.Parse(time.RFC3339, r.EndTime); err == nil {
+			failures[executionKey(r.Name, r.SeqNum)] = end
+		}
+	}
+
+	var removals []Removal
+	var kept []sequenceDir
+	for _, dir := range dirs {
+		seqDirs, err := sequenceDirs(dir.Path)
+		if err != nil {
+			return nil, err
+		}
+		for i, d := range seqDirs {
+			end, failed := failures[executionKey(dir.Name, d.seqNum)]
+			switch {
+			case i < s.Retention.KeepLastSequences || i == 0:
+				d.latest = i == 0
+				kept = append(kept, d)
+			case failed && now.Sub(end) <= s.Retention.KeepFailuresFor:
+				kept = append(kept, d)
+			default:
+				removals = append(removals, Removal{Path: d.path, Size: d.size, Reason: ReasonSequences})
+			}
+		}
+	}
+
+	if s.Retention.MaxSize > 0 {
+		total := int64(0)
+		for _, d := range kept {
+			total += d.size
+		}
+		sort.SliceStable(kept, func(i, j int) bool { return kept[i].modTime.Before(kept[j].modTime) })
+		for _, d := range kept {
+			if total <= s.Retention.MaxSize {
+				break
+			}
+			if d.latest {
+				continue
+			}
+			removals = append(removals, Removal{Path: d.path, Size: d.size, Reason: ReasonSize})
+			total -= d.size
+		}
+	}
+	return removals, nil
+}
+
+// Remove removes the directories and returns the space reclaimed, in bytes. Every directory is removed even if
+// one of them cannot be, the first error is returned.
+func Remove(removals []Removal) (int64, error) {
+	var reclaimed int64
+	var firstErr error
+	for _, r := range removals {
+		if err := os.RemoveAll(r.Path); err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "failed to remove %s", r.Path)
+			}
+			continue
+		}
+		reclaimed += r.Size
+	}
+	return reclaimed, firstErr
+}
+
+// sequenceDirs returns the directories of the sequence numbers of the run command, most recent first. Other
+// entries are ignored.
+func sequenceDirs(path string) ([]sequenceDir, error) {
+	entries, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the sequences of %s", path)
+	}
+
+	var dirs []sequenceDir
+	for _, entry := range entries {
+		seqNum, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		d := sequenceDir{path: filepath.Join(path, entry.Name()), seqNum: seqNum}
+		if fi, err := entry.Info(); err == nil {
+			d.modTime = fi.ModTime()
+		}
+		if d.size, err = dirSize(d.path); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].seqNum > dirs[j].seqNum })
+	return dirs, nil
+}
+
+// dirSize returns the size of the regular files of the directory, in bytes.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, errors.Wrapf(err, "failed to compute the size of %s", path)
+}
+
+func executionKey(name string, seqNum int) string {
+	return name + "/" + strconv.Itoa(seqNum)
+}
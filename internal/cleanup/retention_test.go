@@ -0,0 +1,131 @@
+package cleanup_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/cleanup"
+	"github.com/Azure/run-command-handler-linux/internal/history"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// createSequenceDirs creates the directories of the sequence numbers of the run command, with a file of the
+// specified size in each of them. The directory of a lower sequence number is older.
+func createSequenceDirs(t *testing.T, dir string, size int, seqNums ...int) {
+	for _, seqNum := range seqNums {
+		seqDir := filepath.Join(dir, strconv.Itoa(seqNum))
+		require.Nil(t, os.MkdirAll(seqDir, 0700))
+		require.Nil(t, os.WriteFile(filepath.Join(seqDir, "stdout"), make([]byte, size), 0600))
+		modTime := time.Now().Add(time.Duration(seqNum-100) * time.Hour)
+		require.Nil(t, os.Chtimes(seqDir, modTime, modTime))
+	}
+}
+
+func removedPaths(removals []cleanup.Removal) (paths []string) {
+	for _, r := range removals {
+		paths = append(paths, r.Path)
+	}
+	return paths
+}
+
+func Test_Sweep_keepLastSequences(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "name")
+	createSequenceDirs(t, dir, 10, 1, 2, 3, 10)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "other"), nil, 0600))
+
+	removals, err := cleanup.Sweep{Retention: types.NewCleanupRetention(2, 0, 0)}.Collect([]cleanup.RunCommandDir{{Path: dir, Name: "name"}}, time.Now())
+	require.Nil(t, err)
+	require.Equal(t, []cleanup.Removal{
+		{Path: filepath.Join(dir, "2"), Size: 10, Reason: cleanup.ReasonSequences},
+		{Path: filepath.Join(dir, "1"), Size: 10, Reason: cleanup.ReasonSequences},
+	}, removals, "sequence numbers are compared as numbers")
+
+	reclaimed, err := cleanup.Remove(removals)
+	require.Nil(t, err)
+	require.Equal(t, int64(20), reclaimed)
+	require.NoDirExists(t, filepath.Join(dir, "1"))
+	require.DirExists(t, filepath.Join(dir, "3"))
+	require.FileExists(t, filepath.Join(dir, "other"))
+}
+
+func Test_Sweep_keepFailures(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "name")
+	createSequenceDirs(t, dir, 10, 1, 2, 3)
+	now := time.Now()
+	records := []history.Record{
+		{Name: "name", SeqNum: 1, State: types.Failed, EndTime: now.Add(-72 * time.Hour).Format(time.RFC3339)},
+		{Name: "name", SeqNum: 2, State: types.TimedOut, EndTime: now.Add(-time.Hour).Format(time.RFC3339)},
+		{Name: "other", SeqNum: 1, State: types.Failed, EndTime: now.Format(time.RFC3339)},
+	}
+
+	removals, err := cleanup.Sweep{Retention: types.NewCleanupRetention(1, 2, 0), History: records}.Collect([]cleanup.RunCommandDir{{Path: dir, Name: "name"}}, now)
+	require.Nil(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "1")}, removedPaths(removals), "the recent failure is kept")
+}
+
+func Test_Sweep_maxSize(t *testing.T) {
+	root := t.TempDir()
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "b")
+	createSequenceDirs(t, a, 100, 1, 4)
+	createSequenceDirs(t, b, 100, 2, 3)
+
+	retention := types.CleanupRetention{KeepLastSequences: 2, MaxSize: 250}
+	removals, err := cleanup.Sweep{Retention: retention}.Collect([]cleanup.RunCommandDir{{Path: a, Name: "a"}, {Path: b, Name: "b"}}, time.Now())
+	require.Nil(t, err)
+	require.Equal(t, []cleanup.Removal{
+		{Path: filepath.Join(a, "1"), Size: 100, Reason: cleanup.ReasonSize},
+		{Path: filepath.Join(b, "2"), Size: 100, Reason: cleanup.ReasonSize},
+	}, removals, "the oldest directories are removed first")
+
+	// The most recent sequence of every run command is kept even if the limit is exceeded
+	retention.MaxSize = 1
+	removals, err = cleanup.Sweep{Retention: retention}.Collect([]cleanup.RunCommandDir{{Path: a, Name: "a"}, {Path: b, Name: "b"}}, time.Now())
+	require.Nil(t, err)
+	require.Equal(t, []string{filepath.Join(a, "1"), filepath.Join(b, "2")}, removedPaths(removals))
+}
+
+func Test_RunCommandDirs(t *testing.T) {
+	root := t.TempDir()
+	download, runAs := filepath.Join(root, "download"), filepath.Join(root, "runas", "download")
+	createSequenceDirs(t, filepath.Join(download, "a"), 10, 1)
+	createSequenceDirs(t, filepath.Join(download, "gone"), 10, 1, 2)
+	createSequenceDirs(t, filepath.Join(runAs, "a"), 10, 1)
+	createSequenceDirs(t, runAs, 10, 3)
+
+	dirs, orphaned, err := cleanup.RunCommandDirs([]string{download, runAs, filepath.Join(root, "missing")}, []string{"a", "b"})
+	require.Nil(t, err)
+	require.Equal(t, []cleanup.RunCommandDir{
+		{Path: filepath.Join(download, "a"), Name: "a"},
+		{Path: filepath.Join(runAs, "a"), Name: "a"},
+		{Path: runAs}, // sequence directories of the run command without extension name
+	}, dirs)
+	require.Equal(t, []cleanup.Removal{{Path: filepath.Join(download, "gone"), Size: 20, Reason: cleanup.ReasonOrphaned}}, orphaned)
+}
+
+func TestRunCommandCleanup_AppliesRetention(t *testing.T) {
+	ctx := log.NewContext(log.NewSyncLogger(log.NewLogfmtLogger(os.Stdout))).With("time", log.DefaultTimestamp)
+	dataDir := t.TempDir()
+	extName, seqNum := "testExtension", 5
+	downloadFolder, fakeEnv, scriptFilePathsForSeqs, runtimeSettingsForSeqs := createTempScriptsAndSettingsAndGetVariables(t, dataDir, extName, seqNum)
+	metadata := types.NewRCMetadata(extName, seqNum, filepath.Base(downloadFolder), dataDir)
+
+	require.Nil(t, history.Append(dataDir, history.Record{Name: extName, SeqNum: 1, State: types.Failed, EndTime: time.Now().Format(time.RFC3339)}, history.NewRetention(0, 0, 0)))
+	retention := types.NewCleanupRetention(2, 1, 0)
+	cleanup.RunCommandCleanup(ctx, metadata, fakeEnv, "", &retention)
+
+	for i, kept := range []bool{true, false, false, true, true} {
+		if kept {
+			require.FileExists(t, scriptFilePathsForSeqs[i])
+		} else {
+			require.NoDirExists(t, filepath.Dir(scriptFilePathsForSeqs[i]))
+		}
+	}
+	content, err := os.ReadFile(runtimeSettingsForSeqs[seqNum-2])
+	require.Nil(t, err)
+	require.Empty(t, string(content), "the settings of the previous sequence numbers are emptied")
+}
@@ -0,0 +1,62 @@
+// Package cli implements the tools of the handler run by hand on the VM, e.g. to check settings before
+// deploying them. Unlike the commands run by the agent, the tools take arguments and never report a status.
+package cli
+
+import (
+	"fmt"
+	"io"
+)
+
+// Exit codes of the tools
+const (
+	ExitOkay    = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+// Tool is a subcommand of the handler for the users of the VM.
+type Tool struct {
+	Name        string
+	Usage       string // arguments of the tool
+	Description string
+
+	// Run runs the tool with the arguments following its name and returns the exit code of the handler
+	Run func(args []string, stdout, stderr io.Writer) int
+}
+
+// tools of the handler, in the order of the usage
+var tools = []Tool{
+	validateTool,
+	runLocalTool,
+	statusTool,
+	historyTool,
+	verifyAuditTool,
+	gcTool,
+	collectLogsTool,
+	doctorTool,
+}
+
+// Lookup returns the tool with the specified name.
+func Lookup(name string) (Tool, bool) {
+	for _, tool := range tools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
+
+// PrintUsage prints the usage of every tool, for the program with the specified name.
+func PrintUsage(w io.Writer, program string) {
+	for _, tool := range tools {
+		fmt.Fprintf(w, "       %s %s %s\n", program, tool.Name, tool.Usage)
+		fmt.Fprintf(w, "           %s\n", tool.Description)
+	}
+}
+
+// usageError prints the usage of the tool and returns ExitUsage.
+func usageError(stderr io.Writer, name, usage, message string) int {
+	fmt.Fprintln(stderr, message)
+	fmt.Fprintf(stderr, "Usage: %s %s\n", name, usage)
+	return ExitUsage
+}
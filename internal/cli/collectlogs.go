@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/diagnostics"
+	"github.com/Azure/run-command-handler-linux/internal/service"
+	"github.com/Azure/run-command-handler-linux/pkg/systemd"
+	"github.com/go-kit/kit/log"
+)
+
+const collectLogsUsage = "[-output <file>] [-log-dir <dir>] [-extension-dir <dir>] [-data-dir <dir>]"
+
+var collectLogsTool = Tool{
+	Name:        "collect-logs",
+	Usage:       collectLogsUsage,
+	Description: "Writes a tar.gz bundle for the support with the handler logs, the status, .mrseq and .pidstart files, the systemd unit of the immediate run command service and its state, the handler environment, the settings without their protected settings and the listing of the data directory. Secrets and SAS signatures are redacted.",
+	Run:         runCollectLogs,
+}
+
+// runCollectLogs writes the diagnostics bundle and prints the sources which could not be collected.
+func runCollectLogs(args []string, stdout, stderr io.Writer) int {
+	var l layout
+	flags := flag.NewFlagSet("collect-logs", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	l.bind(flags)
+	now := time.Now().UTC()
+	output := flags.String("output", fmt.Sprintf("run-command-handler-logs-%s.tar.gz", now.Format("20060102T150405Z")), "")
+	logDir := flags.String("log-dir", filepath.Dir(constants.ImmediateRCOutputDirectory), "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return usageError(stderr, "collect-logs", collectLogsUsage, "Incorrect usage.")
+	}
+	if err := l.resolve(); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	sources := diagnostics.Sources{
+		LogDir:       *logDir,
+		ExtensionDir: l.extensionDir,
+		ConfigFolder: l.configFolder,
+		StatusFolder: l.statusFolder,
+		DataDir:      l.dataDir,
+	}
+	if systemd.IsSystemDPresent() {
+		sources.UnitName = service.UnitName
+		if unitFile, err := systemd.GetUnitConfigurationFilePath(service.UnitName, log.NewContext(log.NewNopLogger())); err == nil {
+			sources.UnitFile = unitFile
+		}
+	}
+
+	// The bundle is readable by root only, an existing file is not replaced
+	f, err := os.OpenFile(*output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	problems, err := diagnostics.Collect(f, sources, now)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*output)
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintf(stderr, "warning: not collected: %s\n", problem)
+	}
+	fmt.Fprintf(stdout, "Diagnostics bundle written to %s\n", *output)
+	return ExitOkay
+}
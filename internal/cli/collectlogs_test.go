@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_collectLogs(t *testing.T) {
+	l := newTestLayout(t)
+	writeMostRecentSequence(t, l, "name", 1)
+	output := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	args := append(l.flags(), "-log-dir", filepath.Join(t.TempDir(), "missing"), "-output", output)
+
+	exitCode, stdout, stderr := runTool(t, "collect-logs", args...)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Equal(t, "Diagnostics bundle written to "+output+"\n", stdout)
+	require.Contains(t, stderr, "warning: not collected: ")
+	fi, err := os.Stat(output)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600).String(), fi.Mode().String(), "readable by root only")
+
+	exitCode, _, stderr = runTool(t, "collect-logs", args...)
+	require.Equal(t, ExitFailure, exitCode, "an existing file is not replaced")
+	require.Contains(t, stderr, "file exists")
+}
+
+func Test_collectLogs_usage(t *testing.T) {
+	exitCode, _, stderr := runTool(t, "collect-logs", "extra")
+	require.Equal(t, ExitUsage, exitCode)
+	require.Contains(t, stderr, "Usage: collect-logs")
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/doctor"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/hostgacommunicator"
+	"github.com/pkg/errors"
+)
+
+const doctorUsage = "[-json] [-wire-server <host:port>] [-extension-dir <dir>] [-data-dir <dir>]"
+
+var doctorTool = Tool{
+	Name:        "doctor",
+	Usage:       doctorUsage,
+	Description: "Checks the environment the handler depends on: openssl, the certificates of the protected settings, noexec mounts and free space of the data directory, systemd, the wire server and the RunAs users. Prints a remediation for every check which does not pass, and fails if a check fails.",
+	Run:         runDoctor,
+}
+
+// runDoctor runs every check of the catalog against the run commands of the VM.
+func runDoctor(args []string, stdout, stderr io.Writer) int {
+	var l layout
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	l.bind(flags)
+	asJSON := flags.Bool("json", false, "")
+	wireServer := flags.String("wire-server", "", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return usageError(stderr, "doctor", doctorUsage, "Incorrect usage.")
+	}
+	if err := l.resolve(); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	if *wireServer == "" {
+		u, err := url.Parse(hostgacommunicator.WireServerFallbackAddress)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return ExitFailure
+		}
+		*wireServer = u.Host
+	}
+
+	env, err := l.doctorEnvironment()
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	env.WireServer = *wireServer
+	findings := doctor.Run(env, doctor.Checks)
+
+	if *asJSON {
+		if exitCode := printJSON(stdout, stderr, findings); exitCode != ExitOkay {
+			return exitCode
+		}
+	} else {
+		printFindings(stdout, findings)
+	}
+	for _, f := range findings {
+		if f.Result == doctor.Fail {
+			return ExitFailure
+		}
+	}
+	return ExitOkay
+}
+
+func printFindings(w io.Writer, findings []doctor.Finding) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tMESSAGE")
+	for _, f := range findings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Check, f.Result, f.Message)
+		if f.Remediation != "" {
+			fmt.Fprintf(tw, "\t\t-> %s\n", f.Remediation)
+		}
+	}
+	tw.Flush()
+}
+
+// doctorEnvironment returns the environment of the run commands of the VM: the thumbprints of their protected
+// settings and their RunAs users, read from the settings files.
+func (l layout) doctorEnvironment() (doctor.Environment, error) {
+	env := doctor.Environment{DataDir: l.dataDir, ConfigFolder: l.configFolder}
+	paths, err := filepath.Glob(filepath.Join(l.configFolder, "*"+constants.ConfigFileExtension))
+	if err != nil {
+		return env, errors.Wrap(err, "failed to list the settings")
+	}
+
+	thumbprints, users := map[string]bool{}, map[string]bool{}
+	for _, path := range paths {
+		// The settings which cannot be parsed are reported by the validate tool
+		hs, err := handlersettings.ParseHandlerSettingsFile(path)
+		if err != nil {
+			continue
+		}
+		if hs.ProtectedSettingsBase64 != "" && hs.SettingsCertThumbprint != "" {
+			thumbprints[hs.SettingsCertThumbprint] = true
+		}
+		if user, ok := hs.PublicSettings["runAsUser"].(string); ok && user != "" {
+			users[user] = true
+		}
+	}
+	env.Thumbprints = sortedKeys(thumbprints)
+	env.RunAsUsers = sortedKeys(users)
+	return env, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/doctor"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_doctor(t *testing.T) {
+	l := newTestLayout(t)
+	settings := `{"runtimeSettings":[{"handlerSettings":{"publicSettings":{"runAsUser":"root","source":{"script":"date"}},"protectedSettings":"c2VjcmV0","protectedSettingsCertThumbprint":"THUMBPRINT"}}]}`
+	require.Nil(t, os.WriteFile(filepath.Join(l.configFolder, "name.0.settings"), []byte(settings), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(l.configFolder, "name.1.settings"), nil, 0600)) // emptied by the cleanup
+	wireServer, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer wireServer.Close()
+
+	exitCode, stdout, _ := runTool(t, "doctor", append(l.flags(), "-json", "-wire-server", wireServer.Addr().String())...)
+	require.Equal(t, ExitFailure, exitCode, "the certificates are missing")
+	var findings []doctor.Finding
+	require.Nil(t, json.Unmarshal([]byte(stdout), &findings))
+	require.Len(t, findings, len(doctor.Checks))
+
+	results := map[string]doctor.Finding{}
+	for _, f := range findings {
+		results[f.Check] = f
+	}
+	require.Equal(t, doctor.Fail, results["certificates"].Result)
+	require.Contains(t, results["certificates"].Message, "THUMBPRINT.crt")
+	require.NotEmpty(t, results["certificates"].Remediation)
+	require.Equal(t, doctor.Pass, results["runas-users"].Result)
+	require.Equal(t, "the RunAs users root exist", results["runas-users"].Message)
+	require.Equal(t, doctor.Pass, results["wire-server"].Result)
+}
+
+func Test_doctor_table(t *testing.T) {
+	l := newTestLayout(t)
+	wireServer, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer wireServer.Close()
+
+	_, stdout, _ := runTool(t, "doctor", append(l.flags(), "-wire-server", wireServer.Addr().String())...)
+	require.Regexp(t, `^CHECK\s+RESULT\s+MESSAGE\n`, stdout)
+	require.Regexp(t, `\ncertificates\s+pass\s+no protected settings to decrypt\n`, stdout)
+	require.Regexp(t, `\nwire-server\s+pass\s+the wire server 127\.0\.0\.1:\d+ is reachable\n`, stdout)
+}
+
+func Test_doctor_usage(t *testing.T) {
+	exitCode, _, stderr := runTool(t, "doctor", "extra")
+	require.Equal(t, ExitUsage, exitCode)
+	require.Contains(t, stderr, "Usage: doctor")
+}
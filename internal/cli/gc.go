@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/cleanup"
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/history"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/pkg/errors"
+)
+
+const gcUsage = "[-json] [-dry-run] [-keep-last <n>] [-keep-failures <days>] [-max-size <MB>] [-extension-dir <dir>] [-data-dir <dir>]"
+
+var gcTool = Tool{
+	Name:        "gc",
+	Usage:       gcUsage,
+	Description: "Removes the download directories exceeding the retention, in the data directory and in the copies of the RunAs users, and the directories of the run commands which no longer exist. Reports the reclaimed space, or the space which would be reclaimed with -dry-run.",
+	Run:         runGC,
+}
+
+// runAsDirFormat is the directory of the copies of a RunAs user, overridden by the tests
+var runAsDirFormat = constants.RunAsDir
+
+// gcReport is the outcome of the gc tool.
+type gcReport struct {
+	DryRun    bool              `json:"dryRun"`
+	Removals  []cleanup.Removal `json:"removals"`
+	Reclaimed int64             `json:"reclaimed"` // in bytes
+}
+
+// runGC applies the retention to the download folders of every run command.
+func runGC(args []string, stdout, stderr io.Writer) int {
+	var l layout
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	l.bind(flags)
+	asJSON := flags.Bool("json", false, "")
+	dryRun := flags.Bool("dry-run", false, "")
+	keepLast := flags.Int("keep-last", types.DefaultKeepLastSequences, "")
+	keepFailures := flags.Int("keep-failures", 0, "")
+	maxSize := flags.Int("max-size", 0, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 || *keepLast < 1 || *keepFailures < 0 || *maxSize < 0 {
+		return usageError(stderr, "gc", gcUsage, "Incorrect usage.")
+	}
+	if err := l.resolve(); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	report, err := l.collectGarbage(types.NewCleanupRetention(*keepLast, *keepFailures, *maxSize), *dryRun)
+	if err != nil && report.Removals == nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	// The report is printed even if some directories could not be removed
+	if *asJSON {
+		if exitCode := printJSON(stdout, stderr, report); exitCode != ExitOkay {
+			return exitCode
+		}
+	} else {
+		printRemovals(stdout, report)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	return ExitOkay
+}
+
+func printRemovals(w io.Writer, report gcReport) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tREASON\tPATH")
+	for _, r := range report.Removals {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", formatSize(r.Size), r.Reason, r.Path)
+	}
+	tw.Flush()
+	if report.DryRun {
+		fmt.Fprintf(w, "%s would be reclaimed from %d directories.\n", formatSize(report.Reclaimed), len(report.Removals))
+	} else {
+		fmt.Fprintf(w, "%s reclaimed from %d directories.\n", formatSize(report.Reclaimed), len(report.Removals))
+	}
+}
+
+// collectGarbage removes the orphaned directories and the directories exceeding the retention, in the download
+// folders of the data directory and of the RunAs copies.
+func (l layout) collectGarbage(retention types.CleanupRetention, dryRun bool) (gcReport, error) {
+	report := gcReport{DryRun: dryRun}
+	runAsDirs, err := filepath.Glob(fmt.Sprintf(runAsDirFormat, "*"))
+	if err != nil {
+		return report, errors.Wrap(err, "failed to list the copies of the RunAs users")
+	}
+	var folders []string
+	for _, root := range append([]string{l.dataDir}, runAsDirs...) {
+		folders = append(folders, filepath.Join(root, constants.DownloadFolder), filepath.Join(root, constants.ImmediateDownloadFolder))
+	}
+
+	names, err := l.names()
+	if err != nil {
+		return report, err
+	}
+	dirs, orphaned, err := cleanup.RunCommandDirs(folders, names)
+	if err != nil {
+		return report, err
+	}
+	records, err := history.Read(l.dataDir)
+	if err != nil {
+		return report, err
+	}
+	removals, err := cleanup.Sweep{Retention: retention, History: records}.Collect(dirs, time.Now())
+	if err != nil {
+		return report, err
+	}
+
+	report.Removals = append(append([]cleanup.Removal{}, orphaned...), removals...)
+	if dryRun {
+		for _, r := range report.Removals {
+			report.Reclaimed += r.Size
+		}
+		return report, nil
+	}
+	report.Reclaimed, err = cleanup.Remove(report.Removals)
+	return report, err
+}
+
+// formatSize returns the size in bytes with a binary unit, e.g. 1.5 MB.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGT"[exp])
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/cleanup"
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/history"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/stretchr/testify/require"
+)
+
+func writeOutput(t *testing.T, dir string, seqNum int, size int) string {
+	seqDir := filepath.Join(dir, strconv.Itoa(seqNum))
+	require.Nil(t, os.MkdirAll(seqDir, 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(seqDir, "stdout"), make([]byte, size), 0600))
+	return seqDir
+}
+
+func Test_gc(t *testing.T) {
+	l := newTestLayout(t)
+	runAsRoot := filepath.Join(t.TempDir(), "user")
+	defer func(format string) { runAsDirFormat = format }(runAsDirFormat)
+	runAsDirFormat = filepath.Join(filepath.Dir(runAsRoot), "%s")
+
+	writeMostRecentSequence(t, l, "name", 3)
+	download := filepath.Join(l.dataDir, constants.DownloadFolder, "name")
+	runAsDownload := filepath.Join(runAsRoot, constants.DownloadFolder, "name")
+	old, failed, last := writeOutput(t, download, 1, 10), writeOutput(t, download, 2, 10), writeOutput(t, download, 3, 10)
+	runAsOld, runAsLast := writeOutput(t, runAsDownload, 1, 10), writeOutput(t, runAsDownload, 3, 10)
+	orphaned := filepath.Join(l.dataDir, constants.ImmediateDownloadFolder, "gone")
+	writeOutput(t, orphaned, 0, 2048)
+	require.Nil(t, history.Append(l.dataDir, history.Record{Name: "name", SeqNum: 2, State: types.Failed, EndTime: time.Now().Format(time.RFC3339)}, history.NewRetention(0, 0, 0)))
+
+	exitCode, stdout, _ := runTool(t, "gc", append(l.flags(), "-json", "-dry-run", "-keep-failures", "1")...)
+	require.Equal(t, ExitOkay, exitCode)
+	var report gcReport
+	require.Nil(t, json.Unmarshal([]byte(stdout), &report))
+	require.Equal(t, gcReport{DryRun: true, Reclaimed: 2068, Removals: []cleanup.Removal{
+		{Path: orphaned, Size: 2048, Reason: cleanup.ReasonOrphaned},
+		{Path: old, Size: 10, Reason: cleanup.ReasonSequences},
+		{Path: runAsOld, Size: 10, Reason: cleanup.ReasonSequences},
+	}}, report)
+	require.DirExists(t, old, "nothing is removed by a dry run")
+
+	exitCode, stdout, _ = runTool(t, "gc", l.flags()...)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Contains(t, stdout, "10 B    "+cleanup.ReasonSequences+"  "+failed+"\n")
+	require.Contains(t, stdout, "2.0 KB reclaimed from 4 directories.\n")
+	for _, dir := range []string{orphaned, old, failed, runAsOld} {
+		require.NoDirExists(t, dir)
+	}
+	require.DirExists(t, last)
+	require.DirExists(t, runAsLast)
+}
+
+func Test_gc_usage(t *testing.T) {
+	exitCode, _, stderr := runTool(t, "gc", "-keep-last", "0")
+	require.Equal(t, ExitUsage, exitCode)
+	require.Contains(t, stderr, "Usage: gc")
+}
+
+func Test_formatSize(t *testing.T) {
+	require.Equal(t, "0 B", formatSize(0))
+	require.Equal(t, "1023 B", formatSize(1023))
+	require.Equal(t, "1.5 KB", formatSize(1536))
+	require.Equal(t, "2.0 MB", formatSize(2*1024*1024))
+}
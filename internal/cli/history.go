@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/history"
+	"github.com/pkg/errors"
+)
+
+const historyUsage = "[-json] [-state <state>] [-since <duration>] [-limit <n>] [-extension-dir <dir>] [-data-dir <dir>] [<run command name> [<seq>]]"
+
+var historyTool = Tool{
+	Name:        "history",
+	Usage:       historyUsage,
+	Description: "Lists the executions recorded in the history of the VM, most recent last, e.g. -state Failed -since 24h. With a sequence number, shows the execution with its instance view and the paths of its output files.",
+	Run:         runHistory,
+}
+
+// executionDetails is one execution with the files it left in the data directory.
+type executionDetails struct {
+	execution
+	Record *history.Record `json:"record,omitempty"`
+	Files  []string        `json:"files"`
+}
+
+// historyFilter selects the records of the history.
+type historyFilter struct {
+	name  string
+	state string
+	since time.Duration
+}
+
+func (f historyFilter) matches(record history.Record, now time.Time) bool {
+	if f.name != "" && record.Name != f.name {
+		return false
+	}
+	if f.state != "" && string(record.State) != f.state {
+		return false
+	}
+	if f.since > 0 {
+		end, err := time.Parse(time.RFC3339, record.EndTime)
+		if err != nil || now.Sub(end) > f.since {
+			return false
+		}
+	}
+	return true
+}
+
+// runHistory prints the executions of the history, or the details of one of them.
+func runHistory(args []string, stdout, stderr io.Writer) int {
+	var l layout
+	var filter historyFilter
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	l.bind(flags)
+	asJSON := flags.Bool("json", false, "")
+	flags.StringVar(&filter.state, "state", "", "")
+	flags.DurationVar(&filter.since, "since", 0, "")
+	limit := flags.Int("limit", 0, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 2 || *limit < 0 {
+		return usageError(stderr, "history", historyUsage, "Incorrect usage.")
+	}
+	filter.name = flags.Arg(0)
+	seqNum := -1
+	if flags.NArg() == 2 {
+		var err error
+		if seqNum, err = strconv.Atoi(flags.Arg(1)); err != nil {
+			return usageError(stderr, "history", historyUsage, "The sequence number has to be an integer.")
+		}
+	}
+	if err := l.resolve(); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	records, err := history.Read(l.dataDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	if seqNum < 0 {
+		selected := []history.Record{}
+		now := time.Now()
+		for _, record := range records {
+			if filter.matches(record, now) {
+				selected = append(selected, record)
+			}
+		}
+		if *limit > 0 && len(selected) > *limit {
+			selected = selected[len(selected)-*limit:]
+		}
+		if *asJSON {
+			return printJSON(stdout, stderr, selected)
+		}
+		printRecords(stdout, selected)
+		return ExitOkay
+	}
+
+	// The status file is replaced by the next execution, the record remains in the history
+	details := executionDetails{execution: execution{Name: filter.name, SeqNum: seqNum}, Files: l.outputFiles(filter.name, seqNum)}
+	for i := range records {
+		if records[i].Name == filter.name && records[i].SeqNum == seqNum {
+			details.Record = &records[i]
+		}
+	}
+	if e, err := l.readExecution(filter.name, seqNum); err == nil {
+		details.execution = e
+	} else if details.Record == nil {
+		fmt.Fprintf(stderr, "error: %v\n", errors.Wrap(err, "the execution is not in the history"))
+		return ExitFailure
+	}
+	if details.Files == nil {
+		details.Files = []string{}
+	}
+	if *asJSON {
+		return printJSON(stdout, stderr, details)
+	}
+	return printDetails(stdout, stderr, details)
+}
+
+func printRecords(w io.Writer, records []history.Record) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSEQ\tSTATE\tEXIT CODE\tUSER\tSTART\tEND")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\t%s\t%s\n", r.Name, r.SeqNum, r.State, r.ExitCode, dash(r.User), dash(r.StartTime), dash(r.EndTime))
+	}
+	tw.Flush()
+}
+
+func printDetails(stdout, stderr io.Writer, details executionDetails) int {
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", details.Name)
+	fmt.Fprintf(w, "Sequence number:\t%d\n", details.SeqNum)
+	if details.Status != "" {
+		fmt.Fprintf(w, "Status:\t%s\n", details.Status)
+		fmt.Fprintf(w, "Timestamp:\t%s\n", details.Timestamp)
+	}
+	if details.Message != "" {
+		fmt.Fprintf(w, "Message:\t%s\n", details.Message)
+	}
+	if r := details.Record; r != nil {
+		fmt.Fprintf(w, "State:\t%s\n", r.State)
+		fmt.Fprintf(w, "Exit code:\t%d\n", r.ExitCode)
+		fmt.Fprintf(w, "User:\t%s\n", dash(r.User))
+		for _, sum := range r.ScriptSHA256 {
+			fmt.Fprintf(w, "Script SHA-256:\t%s\n", dash(sum))
+		}
+		fmt.Fprintf(w, "Stdout SHA-256:\t%s\n", dash(r.OutputSHA256))
+		fmt.Fprintf(w, "Stderr SHA-256:\t%s\n", dash(r.ErrorSHA256))
+	}
+	w.Flush()
+
+	if details.InstanceView != nil {
+		fmt.Fprintln(stdout, "Instance view:")
+		if exitCode := printJSON(stdout, stderr, details.InstanceView); exitCode != ExitOkay {
+			return exitCode
+		}
+	}
+	fmt.Fprintln(stdout, "Files:")
+	for _, file := range details.Files {
+		fmt.Fprintf(stdout, "  %s\n", file)
+	}
+	return ExitOkay
+}
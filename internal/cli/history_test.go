@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/history"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_history(t *testing.T) {
+	l := newTestLayout(t)
+	now := time.Now().UTC()
+	for _, record := range []history.Record{
+		{Name: "name", SeqNum: 2, State: types.Succeeded, EndTime: now.Add(-48 * time.Hour).Format(time.RFC3339)},
+		{Name: "other", SeqNum: 0, State: types.Failed, EndTime: now.Add(-time.Hour).Format(time.RFC3339)},
+		{Name: "name", SeqNum: 10, State: types.Failed, ExitCode: 1, User: "root", EndTime: now.Format(time.RFC3339)},
+	} {
+		require.Nil(t, history.Append(l.dataDir, record, history.NewRetention(0, 0, 0)))
+	}
+
+	exitCode, stdout, _ := runTool(t, "history", append(l.flags(), "-json", "name")...)
+	require.Equal(t, ExitOkay, exitCode)
+	var records []history.Record
+	require.Nil(t, json.Unmarshal([]byte(stdout), &records))
+	require.Equal(t, 2, len(records))
+	require.Equal(t, 2, records[0].SeqNum)
+	require.Equal(t, 10, records[1].SeqNum, "most recent last")
+
+	exitCode, stdout, _ = runTool(t, "history", append(l.flags(), "-state", "Failed", "-since", "24h")...)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Equal(t, ""+
+		"NAME   SEQ  STATE   EXIT CODE  USER  START  END\n"+
+		"other  0    Failed  0          -     -      "+now.Add(-time.Hour).Format(time.RFC3339)+"\n"+
+		"name   10   Failed  1          root  -      "+now.Format(time.RFC3339)+"\n", stdout)
+
+	exitCode, stdout, _ = runTool(t, "history", append(l.flags(), "-json", "-limit", "1")...)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Nil(t, json.Unmarshal([]byte(stdout), &records))
+	require.Equal(t, 1, len(records))
+	require.Equal(t, 10, records[0].SeqNum)
+}
+
+func Test_history_execution(t *testing.T) {
+	l := newTestLayout(t)
+	writeStatus(t, l, "name", 1, types.StatusSuccess, types.RunCommandInstanceView{ExecutionState: types.Succeeded, Output: "hello\n"})
+	outputDir := filepath.Join(l.dataDir, constants.DownloadFolder, "name", "1")
+	require.Nil(t, os.MkdirAll(outputDir, 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(outputDir, "stdout"), []byte("hello\n"), 0600))
+
+	exitCode, stdout, _ := runTool(t, "history", append(l.flags(), "-json", "name", "1")...)
+	require.Equal(t, ExitOkay, exitCode)
+	var details executionDetails
+	require.Nil(t, json.Unmarshal([]byte(stdout), &details))
+	require.Equal(t, types.StatusSuccess, details.Status)
+	require.Equal(t, "hello\n", details.InstanceView.Output)
+	require.Equal(t, []string{filepath.Join(outputDir, "stdout")}, details.Files)
+
+	exitCode, stdout, _ = runTool(t, "history", append(l.flags(), "name", "1")...)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Contains(t, stdout, "Sequence number:  1\n")
+	require.Contains(t, stdout, `"output": "hello\n"`)
+	require.Contains(t, stdout, "Files:\n  "+filepath.Join(outputDir, "stdout")+"\n")
+
+	// Failures before the execution have a message instead of an instance view
+	b, err := json.Marshal(types.NewStatusReport(types.StatusError, "Enable", "failed to get configuration", "name"))
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(l.statusFolder, "name.2.status"), b, 0600))
+	exitCode, stdout, _ = runTool(t, "history", append(l.flags(), "name", "2")...)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Contains(t, stdout, "Message:          failed to get configuration\n")
+	require.NotContains(t, stdout, "Instance view:")
+
+	exitCode, _, stderr := runTool(t, "history", append(l.flags(), "name", "3")...)
+	require.Equal(t, ExitFailure, exitCode)
+	require.Contains(t, stderr, "the execution is not in the history")
+
+	// The record remains in the history when the status file is gone
+	record := history.Record{Name: "name", SeqNum: 3, State: types.Succeeded, User: "root", ScriptSHA256: []string{"abc"}, OutputSHA256: "def", ErrorSHA256: "ghi"}
+	require.Nil(t, history.Append(l.dataDir, record, history.NewRetention(0, 0, 0)))
+	exitCode, stdout, _ = runTool(t, "history", append(l.flags(), "-json", "name", "3")...)
+	require.Equal(t, ExitOkay, exitCode)
+	details = executionDetails{}
+	require.Nil(t, json.Unmarshal([]byte(stdout), &details))
+	require.Equal(t, &record, details.Record)
+	require.Nil(t, details.InstanceView)
+
+	exitCode, stdout, _ = runTool(t, "history", append(l.flags(), "name", "3")...)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Contains(t, stdout, "Script SHA-256:   abc\n")
+	require.Contains(t, stdout, "Stdout SHA-256:   def\nStderr SHA-256:   ghi\n", "both streams are hashed")
+}
+
+func Test_history_usage(t *testing.T) {
+	exitCode, _, stderr := runTool(t, "history", "name", "1", "2")
+	require.Equal(t, ExitUsage, exitCode)
+	require.Contains(t, stderr, "Usage: history")
+
+	exitCode, _, stderr = runTool(t, "history", "name", "last")
+	require.Equal(t, ExitUsage, exitCode)
+	require.Contains(t, stderr, "The sequence number has to be an integer.")
+}
@@ -0,0 +1,172 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/pkg/errors"
+)
+
+// layout locates the files of the handler on the VM.
+type layout struct {
+	extensionDir string // working directory of the handler, with the .mrseq files
+	configFolder string
+	statusFolder string
+	dataDir      string // downloaded files and output of the scripts
+}
+
+// bind registers the flags overriding the layout, e.g. for a data directory of run-local.
+func (l *layout) bind(flags *flag.FlagSet) {
+	flags.StringVar(&l.extensionDir, "extension-dir", "", "")
+	flags.StringVar(&l.dataDir, "data-dir", "", "")
+}
+
+// resolve fills the folders not set by the flags, from the handler environment of the agent.
+func (l *layout) resolve() error {
+	if l.dataDir == "" {
+		l.dataDir = constants.DataDir
+	}
+	if l.extensionDir == "" {
+		l.extensionDir = os.Getenv(constants.ExtensionPathEnvName)
+	}
+	if l.extensionDir != "" {
+		l.configFolder = filepath.Join(l.extensionDir, "config")
+		l.statusFolder = filepath.Join(l.extensionDir, constants.StatusFileDirectory)
+		return nil
+	}
+
+	hEnv, err := handlersettings.GetHandlerEnv()
+	if err != nil {
+		return errors.Wrap(err, "cannot find the extension directory, use -extension-dir")
+	}
+	l.configFolder = hEnv.HandlerEnvironment.ConfigFolder
+	l.statusFolder = hEnv.HandlerEnvironment.StatusFolder
+	l.extensionDir = filepath.Dir(l.configFolder)
+	return nil
+}
+
+// execution is the last status reported for a sequence number of a run command.
+type execution struct {
+	Name         string                        `json:"name"`
+	SeqNum       int                           `json:"seqNum"`
+	Status       types.StatusType              `json:"status"`
+	Timestamp    string                        `json:"timestamp"`
+	InstanceView *types.RunCommandInstanceView `json:"instanceView,omitempty"`
+
+	// Message of the status when it is not an instance view, e.g. for a failure before the execution
+	Message string `json:"message,omitempty"`
+}
+
+func (e execution) state() string {
+	if e.InstanceView != nil {
+		return string(e.InstanceView.ExecutionState)
+	}
+	return string(e.Status)
+}
+
+// readExecution reads the status file of the sequence number of the run command.
+func (l layout) readExecution(name string, seqNum int) (e execution, _ error) {
+	path := filepath.Join(l.statusFolder, fmt.Sprintf("%s.%d%s", name, seqNum, constants.StatusFileExtension))
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return e, errors.Wrap(err, "failed to read the status file")
+	}
+	var report types.StatusReport
+	if err := json.Unmarshal(b, &report); err != nil || len(report) != 1 {
+		return e, errors.Errorf("failed to parse the status file %s", path)
+	}
+
+	e = execution{Name: name, SeqNum: seqNum, Status: report[0].Status.Status, Timestamp: report[0].TimestampUTC}
+	var instanceView types.RunCommandInstanceView
+	if err := json.Unmarshal([]byte(report[0].Status.FormattedMessage.Message), &instanceView); err == nil && instanceView.ExecutionState != "" {
+		e.InstanceView = &instanceView
+	} else {
+		e.Message = report[0].Status.FormattedMessage.Message
+	}
+	return e, nil
+}
+
+// names returns the names of the run commands having settings or a most recent sequence number, sorted.
+func (l layout) names() ([]string, error) {
+	found := map[string]bool{}
+	for _, pattern := range []string{
+		filepath.Join(l.extensionDir, "*"+constants.MrSeqFileExtension),
+		filepath.Join(l.configFolder, "*"+constants.ConfigFileExtension),
+	} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list the run commands")
+		}
+		for _, path := range paths {
+			name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			if filepath.Ext(path) == constants.ConfigFileExtension {
+				name = strings.TrimSuffix(name, filepath.Ext(name)) // <name>.<seq>.settings
+			}
+			found[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// sequenceNumbers returns the sequence numbers of the run command having a status file, in increasing order.
+func (l layout) sequenceNumbers(name string) ([]int, error) {
+	paths, err := filepath.Glob(filepath.Join(l.statusFolder, name+".*"+constants.StatusFileExtension))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the status files")
+	}
+	var seqNums []int
+	for _, path := range paths {
+		seq := strings.TrimPrefix(strings.TrimSuffix(filepath.Base(path), constants.StatusFileExtension), name+".")
+		if seqNum, err := strconv.Atoi(seq); err == nil {
+			seqNums = append(seqNums, seqNum)
+		}
+	}
+	sort.Ints(seqNums)
+	return seqNums, nil
+}
+
+// lastSequenceNumber returns the most recent sequence number of the run command, or the highest one with a
+// status file when the handler has not saved it.
+func (l layout) lastSequenceNumber(name string) (int, bool) {
+	if b, err := os.ReadFile(filepath.Join(l.extensionDir, name+constants.MrSeqFileExtension)); err == nil {
+		if seqNum, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			return seqNum, true
+		}
+	}
+	seqNums, err := l.sequenceNumbers(name)
+	if err != nil || len(seqNums) == 0 {
+		return 0, false
+	}
+	return seqNums[len(seqNums)-1], true
+}
+
+// outputFiles returns the files written by the execution in the download folders, immediate or not.
+func (l layout) outputFiles(name string, seqNum int) []string {
+	var files []string
+	for _, folder := range []string{constants.DownloadFolder, constants.ImmediateDownloadFolder} {
+		dir := filepath.Join(l.dataDir, folder, name, strconv.Itoa(seqNum))
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files
+}
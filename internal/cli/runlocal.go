@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	commands "github.com/Azure/run-command-handler-linux/internal/cmds"
+	"github.com/Azure/run-command-handler-linux/internal/commandProcessor"
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/settings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/Azure/run-command-handler-linux/pkg/seqnumutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	runLocalUsage = "[-name <run command name>] <settings file> <data directory>"
+
+	// runLocalThumbprint names the certificate generated to encrypt the protected settings
+	runLocalThumbprint = "runlocal"
+)
+
+var runLocalTool = Tool{
+	Name:        "run-local",
+	Usage:       runLocalUsage,
+	Description: "Runs the settings like the agent would, without the agent. The files of the handler are stored in the data directory and the instance view is printed, the log of the handler goes to stderr.",
+	Run:         runLocal,
+}
+
+// localEnvironment is the layout created by run-local in the data directory, mirroring the one of the agent:
+// the certificates at the root, the folders of the extension in extension/ and the files of the handler in data/.
+type localEnvironment struct {
+	root         string
+	extensionDir string
+	dataDir      string
+	hEnv         types.HandlerEnvironment
+}
+
+// runLocal executes the enable command for the settings file and prints the resulting instance view.
+func runLocal(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("run-local", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	name := flags.String("name", "RunLocal", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return usageError(stderr, "run-local", runLocalUsage, "Incorrect usage.")
+	}
+
+	public, protected, err := readSettingsFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	if err := checkLocalSettings(public, protected, stderr); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	env, err := newLocalEnvironment(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	seqNum, err := env.writeSettings(*name, public, protected)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	// The handler keeps the most recent sequence number and the process id in its working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	if err := os.Chdir(env.extensionDir); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	defer os.Chdir(wd)
+
+	commands.DataDir = env.dataDir
+	commandProcessor.DataDir = env.dataDir
+	if err := commandProcessor.ProcessLocalHandlerCommand(commands.CmdEnable, env.hEnv, *name, seqNum, stderr); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+	}
+
+	l := layout{
+		extensionDir: env.extensionDir,
+		configFolder: env.hEnv.HandlerEnvironment.ConfigFolder,
+		statusFolder: env.hEnv.HandlerEnvironment.StatusFolder,
+		dataDir:      env.dataDir,
+	}
+	e, err := l.readExecution(*name, seqNum)
+	if err == nil && e.InstanceView == nil {
+		err = errors.New(e.Message)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	if exitCode := printJSON(stdout, stderr, e.InstanceView); exitCode != ExitOkay || e.InstanceView.ExecutionState == types.Failed {
+		return ExitFailure
+	}
+	return ExitOkay
+}
+
+// checkLocalSettings validates the settings and rejects the ones needing the VM to be set up by the agent.
+func checkLocalSettings(public, protected map[string]interface{}, stderr io.Writer) error {
+	cfg, warnings, err := handlersettings.ValidateSettings(public, protected)
+	for _, warning := range warnings {
+		fmt.Fprintf(stderr, "warning: %s\n", warning)
+	}
+	if err != nil {
+		return err
+	}
+	if cfg.PublicSettings.RunAsUser != "" {
+		return errors.New("'runAsUser' is not supported by run-local, the files of the handler are not in " + constants.DataDir)
+	}
+	if cfg.InstallAsService() {
+		return errors.New("'installAsService' is not supported by run-local")
+	}
+	return nil
+}
+
+// newLocalEnvironment creates the folders of the layout in the data directory.
+func newLocalEnvironment(root string) (env localEnvironment, _ error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return env, errors.Wrap(err, "failed to get the absolute path of the data directory")
+	}
+	env.root = root
+	env.extensionDir = filepath.Join(root, "extension")
+	env.dataDir = filepath.Join(root, "data")
+
+	env.hEnv.Name = "run-local"
+	env.hEnv.Version = 1.0
+	env.hEnv.HandlerEnvironment.ConfigFolder = filepath.Join(env.extensionDir, "config")
+	env.hEnv.HandlerEnvironment.StatusFolder = filepath.Join(env.extensionDir, "status")
+	env.hEnv.HandlerEnvironment.LogFolder = filepath.Join(env.extensionDir, "log")
+	env.hEnv.HandlerEnvironment.EventsFolder = filepath.Join(env.extensionDir, "events")
+	env.hEnv.HandlerEnvironment.HeartbeatFile = filepath.Join(env.extensionDir, "heartbeat.log")
+
+	for _, dir := range []string{
+		env.hEnv.HandlerEnvironment.ConfigFolder,
+		env.hEnv.HandlerEnvironment.StatusFolder,
+		env.hEnv.HandlerEnvironment.LogFolder,
+		env.hEnv.HandlerEnvironment.EventsFolder,
+		env.dataDir,
+	} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return env, errors.Wrapf(err, "failed to create %s", dir)
+		}
+	}
+	return env, nil
+}
+
+// writeSettings writes the handler settings file with the next sequence number of the run command, the
+// protected settings are encrypted like the agent does.
+func (env localEnvironment) writeSettings(name string, public, protected map[string]interface{}) (int, error) {
+	seqNum := 0
+	if last, err := seqnumutil.FindSequenceNumberFromConfig(env.hEnv.HandlerEnvironment.ConfigFolder, constants.ConfigFileExtension, name); err == nil {
+		seqNum = last + 1
+	}
+
+	hs := settings.SettingsCommon{PublicSettings: public}
+	if len(protected) > 0 {
+		encrypted, err := env.encrypt(protected)
+		if err != nil {
+			return 0, err
+		}
+		hs.ProtectedSettingsBase64 = base64.StdEncoding.EncodeToString(encrypted)
+		hs.SettingsCertThumbprint = runLocalThumbprint
+	}
+
+	b, err := json.Marshal(handlersettings.HandlerSettingsFile{RuntimeSettings: []handlersettings.RunTimeSettingsFile{{HandlerSettings: hs}}})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to marshal the handler settings")
+	}
+	path := handlersettings.GetConfigFilePath(env.hEnv.HandlerEnvironment.ConfigFolder, seqNum, name)
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return 0, errors.Wrap(err, "failed to write the handler settings")
+	}
+	return seqNum, nil
+}
+
+// encrypt encrypts the protected settings with a certificate of the data directory, generated on first use.
+// The handler finds it two levels above the config folder, like the certificates of the agent.
+func (env localEnvironment) encrypt(protected map[string]interface{}) ([]byte, error) {
+	crt := filepath.Join(env.root, runLocalThumbprint+".crt")
+	prv := filepath.Join(env.root, runLocalThumbprint+".prv")
+	if _, err := os.Stat(crt); os.IsNotExist(err) {
+		if _, err := openssl(nil, "req", "-x509", "-newkey", "rsa:2048", "-nodes", "-days", "365",
+			"-subj", "/CN=run-local", "-keyout", prv, "-out", crt); err != nil {
+			return nil, errors.Wrap(err, "failed to generate the certificate")
+		}
+	}
+
+	b, err := json.Marshal(protected)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the protected settings")
+	}
+	encrypted, err := openssl(b, "cms", "-encrypt", "-outform", "DER", "-aes256", crt)
+	return encrypted, errors.Wrap(err, "failed to encrypt the protected settings")
+}
+
+func openssl(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("openssl", args...)
+	var out, errOut bytes.Buffer
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "openssl %s: %s", args[0], errOut.String())
+	}
+	return out.Bytes(), nil
+}
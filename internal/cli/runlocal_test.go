@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	commands "github.com/Azure/run-command-handler-linux/internal/cmds"
+	"github.com/Azure/run-command-handler-linux/internal/commandProcessor"
+	"github.com/stretchr/testify/require"
+)
+
+// runLocalIn runs the run-local tool and restores the state it changes for the handler.
+func runLocalIn(t *testing.T, settings, dataDir string) (int, string, string) {
+	wd, err := os.Getwd()
+	require.Nil(t, err)
+	cmdsDataDir, processorDataDir := commands.DataDir, commandProcessor.DataDir
+	t.Cleanup(func() {
+		commands.DataDir, commandProcessor.DataDir = cmdsDataDir, processorDataDir
+	})
+
+	exitCode, stdout, stderr := runTool(t, "run-local", writeFile(t, settings), dataDir)
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+	require.Equal(t, wd, cwd, "the working directory is restored")
+	return exitCode, stdout, stderr
+}
+
+func Test_runLocal(t *testing.T) {
+	dataDir := t.TempDir()
+	exitCode, stdout, _ := runLocalIn(t, `{
+		"publicSettings": {"source": {"script": "echo hello $name $secret"}, "parameters": [{"name": "name", "value": "world"}]},
+		"protectedSettings": {"protectedParameters": [{"name": "secret", "value": "s3cr3t"}]}
+	}`, dataDir)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Contains(t, stdout, `"executionState": "Succeeded"`)
+	require.Contains(t, stdout, `"output": "hello world s3cr3t\n"`)
+
+	require.FileExists(t, filepath.Join(dataDir, "extension", "status", "RunLocal.0.status"))
+	require.FileExists(t, filepath.Join(dataDir, "extension", "RunLocal.mrseq"))
+	require.FileExists(t, filepath.Join(dataDir, "data", "download", "RunLocal", "0", "stdout"))
+
+	// Every run gets the next sequence number of the run command
+	exitCode, stdout, _ = runLocalIn(t, `{"publicSettings": {"source": {"script": "echo $0 >&2; exit 3"}}}`, dataDir)
+	require.Equal(t, ExitFailure, exitCode)
+	require.Contains(t, stdout, `"executionState": "Failed"`)
+	require.Contains(t, stdout, `"exitCode": 3`)
+	require.FileExists(t, filepath.Join(dataDir, "extension", "status", "RunLocal.1.status"))
+}
+
+func Test_runLocal_unsupportedSettings(t *testing.T) {
+	dataDir := t.TempDir()
+	exitCode, _, stderr := runLocalIn(t, `{"publicSettings": {"source": {"script": "date"}, "runAsUser": "bob"}}`, dataDir)
+	require.Equal(t, ExitFailure, exitCode)
+	require.Contains(t, stderr, "'runAsUser' is not supported by run-local")
+	require.NoDirExists(t, filepath.Join(dataDir, "extension"), "nothing is run")
+
+	exitCode, _, stderr = runLocalIn(t, `{"publicSettings": {"source": {}}}`, dataDir)
+	require.Equal(t, ExitFailure, exitCode)
+	require.Contains(t, stderr, "Either 'source.script' or 'source.scriptUri' has to be specified")
+}
+
+func Test_runLocal_usage(t *testing.T) {
+	exitCode, _, stderr := runTool(t, "run-local", "settings.json")
+	require.Equal(t, ExitUsage, exitCode)
+	require.Contains(t, stderr, "Usage: run-local [-name <run command name>] <settings file> <data directory>")
+}
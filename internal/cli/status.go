@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/status"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	statusUsage = "[-json] [-immediate] [-extension-dir <dir>] [-data-dir <dir>]"
+
+	// stateUnknown is the state of an execution without status file
+	stateUnknown = "Unknown"
+)
+
+var statusTool = Tool{
+	Name:        "status",
+	Usage:       statusUsage,
+	Description: "Lists every run command with the state of its last execution. With -immediate, lists the goal states of the immediate run command service instead.",
+	Run:         runStatus,
+}
+
+// runCommandSummary is the last execution of a run command.
+type runCommandSummary struct {
+	Name      string `json:"name"`
+	SeqNum    int    `json:"seqNum"`
+	State     string `json:"state"`
+	ExitCode  *int   `json:"exitCode,omitempty"`
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+}
+
+// immediateGoalStates are the goal states of the immediate run command service.
+type immediateGoalStates struct {
+	Terminal []status.ImmediateStatus `json:"terminal"`
+	InFlight []runCommandSummary      `json:"inFlight"`
+}
+
+// runStatus prints the state of the run commands on the VM.
+func runStatus(args []string, stdout, stderr io.Writer) int {
+	var l layout
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	l.bind(flags)
+	asJSON := flags.Bool("json", false, "")
+	immediate := flags.Bool("immediate", false, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return usageError(stderr, "status", statusUsage, "Incorrect usage.")
+	}
+	if err := l.resolve(); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+
+	if *immediate {
+		goalStates, err := l.immediateGoalStates()
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %v\n", err)
+			return ExitFailure
+		}
+		if *asJSON {
+			return printJSON(stdout, stderr, goalStates)
+		}
+		fmt.Fprintln(stdout, "Terminal goal states:")
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSEQ\tSTATUS\tTIMESTAMP")
+		for _, s := range goalStates.Terminal {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", s.Status.Name, s.SequenceNumber, s.Status.Status, s.TimestampUTC)
+		}
+		w.Flush()
+		fmt.Fprintln(stdout, "\nIn-flight goal states:")
+		printSummaries(stdout, goalStates.InFlight)
+		return ExitOkay
+	}
+
+	names, err := l.names()
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	summaries := []runCommandSummary{}
+	for _, name := range names {
+		seqNum, ok := l.lastSequenceNumber(name)
+		if !ok {
+			continue
+		}
+		summaries = append(summaries, l.summary(name, seqNum))
+	}
+	if *asJSON {
+		return printJSON(stdout, stderr, summaries)
+	}
+	printSummaries(stdout, summaries)
+	return ExitOkay
+}
+
+// summary returns the state of the execution, which is unknown without a status file.
+func (l layout) summary(name string, seqNum int) runCommandSummary {
+	s := runCommandSummary{Name: name, SeqNum: seqNum, State: stateUnknown}
+	e, err := l.readExecution(name, seqNum)
+	if err != nil {
+		return s
+	}
+	s.State = e.state()
+	if e.InstanceView != nil {
+		s.ExitCode = &e.InstanceView.ExitCode
+		s.StartTime, s.EndTime = e.InstanceView.StartTime, e.InstanceView.EndTime
+	}
+	return s
+}
+
+// immediateGoalStates reads the goal states saved by the service in terminal state, and finds the ones being
+// executed from their download folders.
+func (l layout) immediateGoalStates() (goalStates immediateGoalStates, _ error) {
+	goalStates.Terminal = []status.ImmediateStatus{}
+	goalStates.InFlight = []runCommandSummary{}
+
+	path := filepath.Join(l.extensionDir, constants.ImmediateStatusFileDirectory, constants.ImmediateGoalStatesInTerminalStatusFileName)
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &goalStates.Terminal); err != nil {
+			return goalStates, errors.Wrapf(err, "failed to parse %s", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return goalStates, errors.Wrap(err, "failed to read the terminal status file")
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(l.dataDir, constants.ImmediateDownloadFolder, "*", "*"))
+	if err != nil {
+		return goalStates, errors.Wrap(err, "failed to list the immediate download folders")
+	}
+	for _, dir := range dirs {
+		seqNum, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+		s := l.summary(filepath.Base(filepath.Dir(dir)), seqNum)
+		if s.State == stateUnknown || s.State == string(types.Running) {
+			goalStates.InFlight = append(goalStates.InFlight, s)
+		}
+	}
+	return goalStates, nil
+}
+
+func printSummaries(w io.Writer, summaries []runCommandSummary) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSEQ\tSTATE\tEXIT CODE\tSTART\tEND")
+	for _, s := range summaries {
+		exitCode := "-"
+		if s.ExitCode != nil {
+			exitCode = strconv.Itoa(*s.ExitCode)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", s.Name, s.SeqNum, s.State, exitCode, dash(s.StartTime), dash(s.EndTime))
+	}
+	tw.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// printJSON prints the value as indented JSON.
+func printJSON(stdout, stderr io.Writer, v interface{}) int {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return ExitFailure
+	}
+	fmt.Fprintln(stdout, string(b))
+	return ExitOkay
+}
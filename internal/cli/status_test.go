@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/status"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLayout creates the folders of a handler in a temporary directory.
+func newTestLayout(t *testing.T) layout {
+	root := t.TempDir()
+	l := layout{extensionDir: filepath.Join(root, "extension"), dataDir: filepath.Join(root, "data")}
+	l.configFolder = filepath.Join(l.extensionDir, "config")
+	l.statusFolder = filepath.Join(l.extensionDir, "status")
+	for _, dir := range []string{l.configFolder, l.statusFolder, l.dataDir} {
+		require.Nil(t, os.MkdirAll(dir, 0700))
+	}
+	return l
+}
+
+func (l layout) flags() []string {
+	return []string{"-extension-dir", l.extensionDir, "-data-dir", l.dataDir}
+}
+
+func writeStatus(t *testing.T, l layout, name string, seqNum int, statusType types.StatusType, instanceView types.RunCommandInstanceView) {
+	msg, err := instanceView.Marshal()
+	require.Nil(t, err)
+	b, err := json.Marshal(types.NewStatusReport(statusType, "Enable", string(msg), name))
+	require.Nil(t, err)
+	require.Nil(t, status.SaveStatusReport(l.statusFolder, name, seqNum, b))
+}
+
+func writeMostRecentSequence(t *testing.T, l layout, name string, seqNum int) {
+	require.Nil(t, os.WriteFile(filepath.Join(l.extensionDir, name+constants.MrSeqFileExtension), []byte(strconv.Itoa(seqNum)), 0600))
+}
+
+func Test_status(t *testing.T) {
+	l := newTestLayout(t)
+	writeStatus(t, l, "first", 0, types.StatusSuccess, types.RunCommandInstanceView{ExecutionState: types.Succeeded, StartTime: "2024-01-01T00:00:00Z", EndTime: "2024-01-01T00:00:05Z"})
+	writeStatus(t, l, "first", 1, types.StatusSuccess, types.RunCommandInstanceView{ExecutionState: types.Failed, ExitCode: 2, StartTime: "2024-01-02T00:00:00Z", EndTime: "2024-01-02T00:00:05Z"})
+	writeMostRecentSequence(t, l, "first", 1)
+	writeMostRecentSequence(t, l, "second", 4) // not reported yet
+	require.Nil(t, os.WriteFile(filepath.Join(l.configFolder, "third.0.settings"), []byte("{}"), 0600))
+
+	exitCode, stdout, _ := runTool(t, "status", l.flags()...)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Equal(t, ""+
+		"NAME    SEQ  STATE    EXIT CODE  START                 END\n"+
+		"first   1    Failed   2          2024-01-02T00:00:00Z  2024-01-02T00:00:05Z\n"+
+		"second  4    Unknown  -          -                     -\n", stdout, "a run command without mrseq nor status file is skipped")
+
+	exitCode, stdout, _ = runTool(t, "status", append(l.flags(), "-json")...)
+	require.Equal(t, ExitOkay, exitCode)
+	var summaries []runCommandSummary
+	require.Nil(t, json.Unmarshal([]byte(stdout), &summaries))
+	require.Equal(t, 2, len(summaries))
+	require.Equal(t, 2, *summaries[0].ExitCode)
+	require.Nil(t, summaries[1].ExitCode)
+}
+
+func Test_status_immediate(t *testing.T) {
+	l := newTestLayout(t)
+	terminal := []status.ImmediateStatus{{SequenceNumber: 3, TimestampUTC: "2024-01-01T00:00:00Z", Status: types.Status{Name: "done", Status: types.StatusSuccess}}}
+	b, err := json.Marshal(terminal)
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(l.statusFolder, constants.ImmediateGoalStatesInTerminalStatusFileName), b, 0600))
+
+	for _, dir := range []string{"running/7", "done/3", "starting/0"} {
+		require.Nil(t, os.MkdirAll(filepath.Join(l.dataDir, constants.ImmediateDownloadFolder, dir), 0700))
+	}
+	writeStatus(t, l, "running", 7, types.StatusTransitioning, types.RunCommandInstanceView{ExecutionState: types.Running, StartTime: "2024-01-01T00:00:00Z"})
+	writeStatus(t, l, "done", 3, types.StatusSuccess, types.RunCommandInstanceView{ExecutionState: types.Succeeded})
+
+	exitCode, stdout, _ := runTool(t, "status", append(l.flags(), "-immediate", "-json")...)
+	require.Equal(t, ExitOkay, exitCode)
+	var goalStates immediateGoalStates
+	require.Nil(t, json.Unmarshal([]byte(stdout), &goalStates))
+	require.Equal(t, terminal, goalStates.Terminal)
+	require.Equal(t, []runCommandSummary{
+		{Name: "running", SeqNum: 7, State: "Running", ExitCode: goalStates.InFlight[0].ExitCode, StartTime: "2024-01-01T00:00:00Z"},
+		{Name: "starting", SeqNum: 0, State: stateUnknown},
+	}, goalStates.InFlight)
+
+	exitCode, stdout, _ = runTool(t, "status", append(l.flags(), "-immediate")...)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Contains(t, stdout, "done  3    success  2024-01-01T00:00:00Z")
+	require.Contains(t, stdout, "In-flight goal states:")
+}
+
+func Test_status_usage(t *testing.T) {
+	exitCode, _, stderr := runTool(t, "status", "name")
+	require.Equal(t, ExitUsage, exitCode)
+	require.Contains(t, stderr, "Usage: status [-json] [-immediate]")
+}
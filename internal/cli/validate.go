@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/pkg/errors"
+)
+
+const validateUsage = "<settings file>"
+
+var validateTool = Tool{
+	Name:        "validate",
+	Usage:       validateUsage,
+	Description: "Validates settings without executing anything. The file is either a handler settings file, e.g. 0.settings, or a JSON object with publicSettings and protectedSettings.",
+	Run:         runValidate,
+}
+
+// runValidate prints the warnings and the errors of the settings file, with the path of the fields.
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		return usageError(stderr, "validate", validateUsage, "Incorrect usage.")
+	}
+	path := args[0]
+
+	public, protected, err := readSettingsFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		fmt.Fprintf(stdout, "%s: invalid\n", path)
+		return ExitFailure
+	}
+
+	_, warnings, err := handlersettings.ValidateSettings(public, protected)
+	for _, warning := range warnings {
+		fmt.Fprintf(stdout, "warning: %s\n", warning)
+	}
+	if err != nil {
+		var schemaErr *handlersettings.SchemaError
+		if errors.As(err, &schemaErr) {
+			for _, issue := range schemaErr.Issues {
+				fmt.Fprintf(stdout, "error: %s\n", issue)
+			}
+		} else {
+			fmt.Fprintf(stdout, "error: %v\n", err)
+		}
+		fmt.Fprintf(stdout, "%s: invalid\n", path)
+		return ExitFailure
+	}
+
+	fmt.Fprintf(stdout, "%s: valid\n", path)
+	return ExitOkay
+}
+
+// readSettingsFile returns the public and protected settings of the file. The protected settings of a handler
+// settings file are decrypted with the certificates of the agent, like the handler does.
+func readSettingsFile(path string) (public, protected map[string]interface{}, _ error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read the settings file")
+	}
+
+	var file struct {
+		RuntimeSettings   json.RawMessage        `json:"runtimeSettings"`
+		PublicSettings    map[string]interface{} `json:"publicSettings"`
+		ProtectedSettings map[string]interface{} `json:"protectedSettings"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse the settings file, publicSettings and protectedSettings have to be JSON objects")
+	}
+	if file.RuntimeSettings != nil {
+		return handlersettings.ReadSettings(path)
+	}
+	if file.PublicSettings == nil && file.ProtectedSettings == nil {
+		return nil, nil, errors.New("the settings file has neither runtimeSettings, publicSettings nor protectedSettings")
+	}
+	return file.PublicSettings, file.ProtectedSettings, nil
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func runTool(t *testing.T, name string, args ...string) (int, string, string) {
+	tool, ok := Lookup(name)
+	require.True(t, ok)
+	var stdout, stderr bytes.Buffer
+	exitCode := tool.Run(args, &stdout, &stderr)
+	return exitCode, stdout.String(), stderr.String()
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	require.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func Test_validate_valid(t *testing.T) {
+	path := writeFile(t, `{"publicSettings": {"source": {"script": "date"}, "timeoutInSecond": 5}, "protectedSettings": {"runAsPassword": "p"}}`)
+
+	exitCode, stdout, _ := runTool(t, "validate", path)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Equal(t, "warning: publicSettings.timeoutInSecond: unknown field, it is ignored. Did you mean 'timeoutInSeconds'?\n"+path+": valid\n", stdout)
+}
+
+func Test_validate_handlerSettingsFile(t *testing.T) {
+	path := writeFile(t, `{"runtimeSettings": [{"handlerSettings": {"publicSettings": {"source": {"scriptUri": "https://a/b.sh"}}}}]}`)
+
+	exitCode, stdout, _ := runTool(t, "validate", path)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Equal(t, path+": valid\n", stdout)
+}
+
+func Test_validate_invalid(t *testing.T) {
+	path := writeFile(t, `{"publicSettings": {"source": {"script": 1}, "retry": {"maxAttempts": "3"}}}`)
+	exitCode, stdout, _ := runTool(t, "validate", path)
+	require.Equal(t, ExitFailure, exitCode)
+	require.Equal(t, "error: publicSettings.retry.maxAttempts: Invalid type. Expected: integer, given: string\n"+
+		"error: publicSettings.source.script: Invalid type. Expected: string, given: integer\n"+
+		path+": invalid\n", stdout)
+
+	path = writeFile(t, `{"publicSettings": {"source": {"script": "date"}, "retry": {"maxAttempts": 30}}}`)
+	exitCode, stdout, _ = runTool(t, "validate", path)
+	require.Equal(t, ExitFailure, exitCode)
+	require.Equal(t, "error: invalid configuration: 'retry.maxAttempts' must be between 1 and 10\n"+path+": invalid\n", stdout)
+
+	path = writeFile(t, `{"source": {"script": "date"}}`)
+	exitCode, _, stderr := runTool(t, "validate", path)
+	require.Equal(t, ExitFailure, exitCode)
+	require.Contains(t, stderr, "neither runtimeSettings, publicSettings nor protectedSettings")
+}
+
+func Test_validate_usage(t *testing.T) {
+	exitCode, _, stderr := runTool(t, "validate")
+	require.Equal(t, ExitUsage, exitCode)
+	require.Contains(t, stderr, "Usage: validate <settings file>")
+
+	_, ok := Lookup("enable")
+	require.False(t, ok, "the commands of the agent are not tools")
+}
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/Azure/run-command-handler-linux/internal/audit"
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+)
+
+const verifyAuditUsage = "[-data-dir <dir>]"
+
+var verifyAuditTool = Tool{
+	Name:        "verify-audit",
+	Usage:       verifyAuditUsage,
+	Description: "Verifies the chain of hashes of the audit log of the executions, and reports the first record which was edited, removed or reordered, or the truncation of the log.",
+	Run:         runVerifyAudit,
+}
+
+// runVerifyAudit prints the number of records of the audit log, or the first problem found in it.
+func runVerifyAudit(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	dataDir := flags.String("data-dir", constants.DataDir, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return usageError(stderr, "verify-audit", verifyAuditUsage, "Incorrect usage.")
+	}
+
+	count, err := audit.Verify(*dataDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		fmt.Fprintf(stdout, "%s: verification failed after %d records\n", audit.Path(*dataDir), count)
+		return ExitFailure
+	}
+	fmt.Fprintf(stdout, "%s: %d records verified\n", audit.Path(*dataDir), count)
+	return ExitOkay
+}
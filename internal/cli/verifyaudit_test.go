@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/audit"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_verifyAudit(t *testing.T) {
+	dataDir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		require.Nil(t, audit.Append(dataDir, audit.Record{ExtensionName: "name", SeqNum: i}))
+	}
+
+	exitCode, stdout, _ := runTool(t, "verify-audit", "-data-dir", dataDir)
+	require.Equal(t, ExitOkay, exitCode)
+	require.Equal(t, audit.Path(dataDir)+": 2 records verified\n", stdout)
+
+	b, err := os.ReadFile(audit.Path(dataDir))
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(audit.Path(dataDir), bytes.Replace(b, []byte(`"seqNum":0`), []byte(`"seqNum":5`), 1), 0600))
+	exitCode, stdout, stderr := runTool(t, "verify-audit", "-data-dir", dataDir)
+	require.Equal(t, ExitFailure, exitCode)
+	require.Equal(t, audit.Path(dataDir)+": verification failed after 0 records\n", stdout)
+	require.Contains(t, stderr, "record 0 was modified")
+}
+
+func Test_verifyAudit_usage(t *testing.T) {
+	exitCode, _, stderr := runTool(t, "verify-audit", "extra")
+	require.Equal(t, ExitUsage, exitCode)
+	require.Contains(t, stderr, "Usage: verify-audit")
+}
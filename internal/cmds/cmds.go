@@ -1,7 +1,6 @@
 package commands
 
 import (
-	"bufio"
 	"bytes"
 	"compress/gzip"
 	"container/list"
@@ -20,13 +19,13 @@ import (
 	"github.com/Azure/azure-extension-platform/pkg/extensionevents"
 	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
 	"github.com/Azure/azure-extension-platform/pkg/logging"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/Azure/run-command-handler-linux/internal/cleanup"
 	"github.com/Azure/run-command-handler-linux/internal/commandProcessor"
 	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/errorcatalog"
 	"github.com/Azure/run-command-handler-linux/internal/exec"
 	"github.com/Azure/run-command-handler-linux/internal/files"
 	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
@@ -43,21 +42,14 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	maxScriptSize         = 256 * 1024
-	updateStatusInSeconds = 15
-)
+const maxScriptSize = 256 * 1024
 
-const (
-	fullName                = "Microsoft.Compute.CPlat.Core.RunCommandLinux"
-	maxTailLen              = 4 * 1024 // length of max stdout/stderr to be transmitted in .status file
-	maxTelemetryTailLen int = 1800
-)
+const maxTelemetryTailLen int = 1800
 
 var (
 	cmdDefaultReportStatusFunc = status.ReportStatusToLocalFile
 	cmdDefaultCleanupFunc      = cleanup.RunCommandCleanup
-	telemetryResult            = telemetry.SendTelemetry(telemetry.NewTelemetryEventSender(), fullName, versionutil.Version)
+	telemetryResult            = telemetry.SendTelemetry(telemetry.NewTelemetryEventSender(), constants.TelemetryExtensionName, versionutil.Version)
 
 	CmdInstall   = types.CmdInstallTemplate.InitializeFunctions(types.CmdFunctions{Invoke: install, Pre: nil, ReportStatus: cmdDefaultReportStatusFunc, Cleanup: cmdDefaultCleanupFunc})
 	CmdEnable    = types.CmdEnableTemplate.InitializeFunctions(types.CmdFunctions{Invoke: enable, Pre: enablePre, ReportStatus: cmdDefaultReportStatusFunc, Cleanup: cmdDefaultCleanupFunc})
@@ -76,6 +68,9 @@ var (
 	RunCmd  = runCmd
 	DataDir = constants.DataDir
 
+	// Interval of the status reports while the script runs, shortened by the tests
+	updateStatusInSeconds = 15
+
 	// Used by unit tests to mock out executing the command
 	ExecCmdInDir = exec.ExecCmdInDir
 
@@ -107,7 +102,7 @@ func update(ctx *log.Context, h types.HandlerEnvironment, report *types.RunComma
 	// This is necessary to prevent rerunning of already executed Run Commands after upgrade of extension version, and also return their statuses.
 	copyError := CopyStateForUpdate(ctx, upgradeFromVersionDirectory, upgradeToVersionDirectory, extensionEvents)
 	if copyError != nil {
-		return "", "", errors.Wrap(copyError, "Migrating *.mrseq or .status files failed during update."), constants.ExitCode_CopyStateForUpdateFailed
+		return "", "", errorcatalog.CopyStateForUpdateFailed.Wrap(copyError, "migrating *.mrseq or .status files failed during update"), constants.ExitCode_CopyStateForUpdateFailed
 	}
 
 	ctx.Log("event", "update")
@@ -183,7 +178,7 @@ func enablePre(ctx *log.Context, h types.HandlerEnvironment, metadata types.RCMe
 		ctx.Log("event", "exit", "message", "the script configuration has already been processed, will not run again")
 
 		if c.Functions.Cleanup != nil {
-			c.Functions.Cleanup(ctx, metadata, h, "")
+			c.Functions.Cleanup(ctx, metadata, h, "", nil)
 		}
 
 		return ErrAlreadyProcessed
@@ -200,7 +195,7 @@ func enable(ctx *log.Context, h types.HandlerEnvironment, report *types.RunComma
 	if err1 != nil {
 		errMessage := fmt.Sprintf("Failed to get configuration: %v", err1)
 		extensionEvents.LogErrorEvent("enable", errMessage)
-		return "", "", errors.Wrap(err1, "failed to get configuration"), constants.ExitCode_GetHandlerSettingsFailed
+		return "", "", errorcatalog.GetSettingsFailed.Wrap(err1, "failed to get configuration"), constants.ExitCode_GetHandlerSettingsFailed
 	}
 
 	exitCode, err := immediatecmds.Enable(ctx, h, metadata.ExtName, metadata.SeqNum, cfg, extensionEvents)
@@ -210,6 +205,23 @@ func enable(ctx *log.Context, h types.HandlerEnvironment, report *types.RunComma
 		return "", "", err, exitCode
 	}
 
+	// Fail early when the VM cannot execute the script, e.g. when the data directory is mounted noexec
+	if err := preflight(ctx, &cfg); err != nil {
+		extensionEvents.LogErrorEvent("enable", fmt.Sprintf("Preflight checks failed: %v", err))
+		return "", "", errorcatalog.PreflightFailed.Wrap(err, "preflight checks failed"), constants.ExitCode_PreflightFailed
+	}
+
+	// Wait for the run commands this one depends on, and for the run commands sharing its lock. A dry run
+	// executes nothing, it does not wait.
+	if !cfg.PublicSettings.DryRun {
+		lock, err, exitCode := waitForTurn(ctx, h, metadata, c, &cfg, report)
+		if err != nil {
+			extensionEvents.LogErrorEvent("enable", fmt.Sprintf("Failed to wait for other run commands: %v", err))
+			return "", "", err, exitCode
+		}
+		defer lock.Release(ctx)
+	}
+
 	dir := filepath.Join(metadata.DownloadPath, fmt.Sprintf("%d", metadata.SeqNum))
 	scriptFilePath, err := downloadScript(ctx, dir, &cfg)
 	if err != nil {
@@ -217,56 +229,48 @@ func enable(ctx *log.Context, h types.HandlerEnvironment, report *types.RunComma
 		extensionEvents.LogErrorEvent("enable", errMessage)
 		return "",
 			"",
-			errors.Wrap(err, fmt.Sprintf("File downloads failed. Use either a public script URI that points to .sh file, Azure storage blob SAS URI or storage blob accessible by a managed identity and retry. If managed identity is used, make sure it has been given access to container of storage blob '%s' with 'Storage Blob Data Reader' role assignment. In case of user-assigned identity, make sure you add it under VM's identity. For more info, refer https://aka.ms/RunCommandManagedLinux", download.GetUriForLogging(cfg.ScriptURI()))),
+			errorcatalog.ScriptDownloadFailed.Wrapf(err, "failed to download the script '%s'", download.GetUriForLogging(cfg.ScriptURI())),
 			constants.ExitCode_ScriptBlobDownloadFailed
 	}
 
-	err = downloadArtifacts(ctx, dir, &cfg)
-	if err != nil {
+	artifactFilePaths, err := downloadArtifacts(ctx, dir, &cfg)
+	var renderErr *renderError
+	if errors.As(err, &renderErr) {
+		extensionEvents.LogErrorEvent("enable", fmt.Sprintf("Failed to render artifact: %v", err))
+		return "", "", errorcatalog.TemplateRenderFailed.Wrap(err, "artifact templating failed"), constants.ExitCode_RenderTemplateFailed
+	} else if err != nil {
 		errMessage := fmt.Sprintf("Failed to download artifacts: %v", err)
 		extensionEvents.LogErrorEvent("enable", errMessage)
 		return "", "",
-			errors.Wrap(err, "Artifact downloads failed. Use either a public artifact URI that points to .sh file, Azure storage blob SAS URI, or storage blob accessible by a managed identity and retry."),
+			errorcatalog.ArtifactDownloadFailed.Wrap(err, "failed to download the artifacts"),
 			constants.ExitCode_DownloadArtifactFailed
 	}
 
-	blobCreateOrReplaceError := "Error creating AppendBlob '%s' using SAS token or Managed identity. Please use a valid blob SAS URI with [read, append, create, write] permissions OR managed identity. If managed identity is used, make sure Azure blob and identity exist, and identity has been given access to storage blob's container with 'Storage Blob Data Contributor' role assignment. In case of user-assigned identity, make sure you add it under VM's identity and provide outputBlobUri / errorBlobUri and corresponding clientId in outputBlobManagedIdentity / errorBlobManagedIdentity parameter(s). In case of system-assigned identity, do not use outputBlobManagedIdentity / errorBlobManagedIdentity parameter(s). For more info, refer https://aka.ms/RunCommandManagedLinux"
-
-	var outputBlobSASRef *storage.Blob
-	var outputBlobAppendClient *appendblob.Client
-	var outputBlobAppendCreateOrReplaceError error
-	outputFilePosition := int64(0)
-
-	// Create or Replace outputBlobURI if provided. Fail the command if create or replace fails.
-	if cfg.OutputBlobURI != "" {
-		outputBlobSASRef, outputBlobAppendClient, outputBlobAppendCreateOrReplaceError = createOrReplaceAppendBlob(cfg.OutputBlobURI,
-			cfg.ProtectedSettings.OutputBlobSASToken, cfg.ProtectedSettings.OutputBlobManagedIdentity, ctx)
-
-		if outputBlobAppendCreateOrReplaceError != nil {
-			return "",
-				"",
-				errors.Wrap(outputBlobAppendCreateOrReplaceError, fmt.Sprintf(blobCreateOrReplaceError, cfg.OutputBlobURI)),
-				constants.ExitCode_BlobCreateOrReplaceFailed
+	if cfg.PublicSettings.DryRun {
+		err, exitCode := dryRun(ctx, dir, scriptFilePath, artifactFilePaths, &cfg, report)
+		if err != nil {
+			extensionEvents.LogErrorEvent("enable", fmt.Sprintf("Dry run failed: %v", err))
+		}
+		if c.Functions.Cleanup != nil {
+			c.Functions.Cleanup(ctx, metadata, h, cfg.PublicSettings.RunAsUser, cfg.PublicSettings.Cleanup.Retention())
 		}
+		return "", "", err, exitCode
 	}
 
-	var errorBlobSASRef *storage.Blob
-	var errorBlobAppendClient *appendblob.Client
-	var errorBlobAppendCreateOrReplaceError error
-	errorFilePosition := int64(0)
-
-	// Create or Replace errorBlobURI if provided. Fail the command if create or replace fails.
-	if cfg.ErrorBlobURI != "" {
-		errorBlobSASRef, errorBlobAppendClient, errorBlobAppendCreateOrReplaceError = createOrReplaceAppendBlob(cfg.ErrorBlobURI,
-			cfg.ProtectedSettings.ErrorBlobSASToken, cfg.ProtectedSettings.ErrorBlobManagedIdentity, ctx)
+	// Create the sinks receiving the output streams. Fail the command if any of them cannot be created.
+	outputSink, exitCode, err := newOutputSink(ctx, stdoutStream, &cfg, metadata)
+	if err != nil {
+		return "", "", err, exitCode
+	}
+	defer closeSink(ctx, outputSink)
+	outputFilePosition := int64(0)
 
-		if errorBlobAppendCreateOrReplaceError != nil {
-			return "",
-				"",
-				errors.Wrap(errorBlobAppendCreateOrReplaceError, fmt.Sprintf(blobCreateOrReplaceError, cfg.ErrorBlobURI)),
-				constants.ExitCode_BlobCreateOrReplaceFailed
-		}
+	errorSink, exitCode, err := newOutputSink(ctx, stderrStream, &cfg, metadata)
+	if err != nil {
+		return "", "", err, exitCode
 	}
+	defer closeSink(ctx, errorSink)
+	errorFilePosition := int64(0)
 
 	// AsyncExecution requested by customer means the extension should report successful extension deployment to complete the provisioning state
 	// Later the full extension output will be reported
@@ -278,35 +282,48 @@ func enable(ctx *log.Context, h types.HandlerEnvironment, report *types.RunComma
 	}
 
 	stdoutF, stderrF := exec.LogPaths(dir)
-
-	// Implement ticker to update extension status periodically
-	ticker := time.NewTicker(updateStatusInSeconds * time.Second)
-	done := make(chan bool)
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				ctx.Log("event", "report partial status")
-				stdoutTail, stderrTail := getOutput(ctx, stdoutF, stderrF)
-				report.Output = stdoutTail
-				report.Error = stderrTail
-				instanceview.ReportInstanceView(ctx, h, metadata, statusToReport, c, report)
-				outputFilePosition, err = appendToBlob(stdoutF, outputBlobSASRef, outputBlobAppendClient, outputFilePosition, ctx)
-				errorFilePosition, err = appendToBlob(stderrF, errorBlobSASRef, errorBlobAppendClient, errorFilePosition, ctx)
-			}
+	scriptFilesDir := exec.ScriptFilesDir(dir, &cfg)
+
+	// Report the status periodically while the script runs. The execution updates the instance view
+	// meanwhile, the status reports a copy of it.
+	execReport := newExecutionReport(report)
+	stopReports := reportPeriodically(func() {
+		ctx.Log("event", "report partial status")
+		stdoutTail, stderrTail := getOutput(ctx, &cfg, stdoutF, stderrF)
+		progress, err := exec.ReadProgress(scriptFilesDir)
+		if err != nil {
+			ctx.Log("message", "error reading progress of the script", "error", err)
 		}
-	}()
+		view := execReport.snapshot(func(view *types.RunCommandInstanceView) {
+			view.Output = stdoutTail
+			view.Error = stderrTail
+			if progress != "" {
+				view.ExecutionMessage = "Execution in progress: " + progress
+			}
+		})
+		instanceview.ReportInstanceView(ctx, h, metadata, statusToReport, c, &view)
+		outputFilePosition, _ = appendToSink(stdoutF, outputSink, outputFilePosition, ctx)
+		errorFilePosition, _ = appendToSink(stderrF, errorSink, errorFilePosition, ctx)
+	})
 
 	// execute the command, save its error
-	runErr, exitCode := RunCmd(ctx, dir, scriptFilePath, &cfg, metadata)
-
-	ticker.Stop()
-	done <- true
+	runErr, exitCode := RunCmd(ctx, dir, scriptFilePath, &cfg, metadata, execReport)
+	stopReports()
 
 	// collect the logs if available
-	stdoutTail, stderrTail := getOutput(ctx, stdoutF, stderrF)
+	stdoutTail, stderrTail := getOutput(ctx, &cfg, stdoutF, stderrF)
+	if report.Details != nil {
+		report.Details.OutputSHA256, _, _ = fileSHA256(stdoutF)
+		report.Details.ErrorSHA256, _, _ = fileSHA256(stderrF)
+	}
+
+	// attach the result written by the script, if any
+	result, err := readScriptResult(exec.ResultFilePath(dir))
+	if err != nil {
+		ctx.Log("message", "ignoring result of the script", "error", err)
+		report.ResultError = err.Error()
+	}
+	report.Result = result
 
 	isSuccess := runErr == nil
 	telemetryResult("Output", "-- stdout/stderr omitted from telemetry pipeline --", isSuccess, 0)
@@ -317,12 +334,12 @@ func enable(ctx *log.Context, h types.HandlerEnvironment, report *types.RunComma
 		ctx.Log("event", "enable script failed")
 	}
 
-	// Report the output streams to blobs
-	outputFilePosition, err = appendToBlob(stdoutF, outputBlobSASRef, outputBlobAppendClient, outputFilePosition, ctx)
-	errorFilePosition, err = appendToBlob(stderrF, errorBlobSASRef, errorBlobAppendClient, errorFilePosition, ctx)
+	// Report the output streams to the sinks
+	outputFilePosition, _ = appendToSink(stdoutF, outputSink, outputFilePosition, ctx)
+	errorFilePosition, _ = appendToSink(stderrF, errorSink, errorFilePosition, ctx)
 
 	if c.Functions.Cleanup != nil {
-		c.Functions.Cleanup(ctx, metadata, h, cfg.PublicSettings.RunAsUser)
+		c.Functions.Cleanup(ctx, metadata, h, cfg.PublicSettings.RunAsUser, cfg.PublicSettings.Cleanup.Retention())
 	}
 
 	return stdoutTail, stderrTail, runErr, exitCode
@@ -351,44 +368,16 @@ func convertToPlatformHandlerEnv(myEnv types.HandlerEnvironment) *handlerenv.Han
 	return &result
 }
 
-// appendToBlob saves a file (from seeking position to the end of the file) to AppendBlob. Returns the new position (end of the file)
-func appendToBlob(sourceFilePath string, appendBlobRef *storage.Blob, appendBlobClient *appendblob.Client, outputFilePosition int64, ctx *log.Context) (int64, error) {
-	var err error
-	var newOutput []byte
-	if appendBlobRef != nil || appendBlobClient != nil {
-		// Save to blob
-		newOutput, err = files.GetFileFromPosition(sourceFilePath, outputFilePosition)
-		if err == nil {
-			newOutputSize := len(newOutput)
-			if newOutputSize > 0 {
-				if appendBlobRef != nil {
-					err = appendBlobRef.AppendBlock(newOutput, nil)
-				} else if appendBlobClient != nil {
-					ctx.Log("message", fmt.Sprintf("inside appendBlobClient. Output is '%s'", newOutput))
-					_, err = appendBlobClient.AppendBlock(context.Background(), streaming.NopCloser(bytes.NewReader(newOutput)), nil)
-				}
-
-				if err == nil {
-					outputFilePosition += int64(newOutputSize)
-				} else {
-					ctx.Log("message", "AppendToBlob failed", "error", err)
-				}
-			}
-		} else {
-			ctx.Log("message", "AppendToBlob - GetFileFromPosition failed.", "error", err)
-		}
-	}
-
-	return outputFilePosition, err
-}
+// getOutput returns the beginning and the end of stdout and stderr, within the limits to transmit in the .status file.
+func getOutput(ctx *log.Context, cfg *handlersettings.HandlerSettings, stdoutFileName string, stderrFileName string) (string, string) {
+	head, tail := cfg.OutputCaptureLimits()
 
-func getOutput(ctx *log.Context, stdoutFileName string, stderrFileName string) (string, string) {
 	// collect the logs if available
-	stdoutTail, err := files.TailFile(stdoutFileName, maxTailLen)
+	stdoutTail, err := files.HeadAndTailFile(stdoutFileName, int64(head), int64(tail))
 	if err != nil {
 		ctx.Log("message", "error tailing stdout logs", "error", err)
 	}
-	stderrTail, err := files.TailFile(stderrFileName, maxTailLen)
+	stderrTail, err := files.HeadAndTailFile(stderrFileName, int64(head), int64(tail))
 	if err != nil {
 		ctx.Log("message", "error tailing stderr logs", "error", err)
 	}
@@ -692,29 +681,28 @@ func copyFiles(ctx log.Logger, fileExtensionSuffix string, extensionSubdirectory
 
 			sourceFile, sourceFileOpenError := os.Open(sourceFileFullPath)
 			if sourceFileOpenError != nil {
-				errMessage := "Failed to open '%s' file '%s' for reading. Contact ICM team AzureRT\\Extensions for this service error."
-				ctx.Log("message", fmt.Sprintf(errMessage, fileExtensionSuffix, sourceFileFullPath))
+				errMessage := fmt.Sprintf("Failed to open '%s' file '%s' for reading", fileExtensionSuffix, sourceFileFullPath)
+				ctx.Log("message", errMessage)
 				extensionEvents.LogErrorEvent("copyfiles", errMessage)
-				return fileNamesMigrated, errors.Wrapf(sourceFileOpenError, errMessage)
+				return fileNamesMigrated, errors.Wrap(sourceFileOpenError, errMessage)
 			}
 			defer sourceFile.Close()
 
 			destFile, destFileCreateError := os.Create(destinationFileFullPath)
 			if destFileCreateError != nil {
-				errMessage := "Failed to create '%s' file '%s'. Contact ICM team AzureRT\\Extensions for this service error."
-				ctx.Log("message", fmt.Sprintf(errMessage, fileExtensionSuffix, destinationFileFullPath))
+				errMessage := fmt.Sprintf("Failed to create '%s' file '%s'", fileExtensionSuffix, destinationFileFullPath)
+				ctx.Log("message", errMessage)
 				extensionEvents.LogErrorEvent("copyfiles", errMessage)
-				return fileNamesMigrated, errors.Wrapf(destFileCreateError, errMessage)
+				return fileNamesMigrated, errors.Wrap(destFileCreateError, errMessage)
 			}
 			defer destFile.Close()
 
 			_, copyError := io.Copy(destFile, sourceFile)
 			if copyError != nil {
-				errMessage := fmt.Sprintf("Failed to copy '%s' file '%s' to path '%s'. Contact ICM team AzureRT\\Extensions for this service error.",
-					fileExtensionSuffix, sourceFileFullPath, destinationFileFullPath)
+				errMessage := fmt.Sprintf("Failed to copy '%s' file '%s' to path '%s'", fileExtensionSuffix, sourceFileFullPath, destinationFileFullPath)
 				ctx.Log("message", errMessage)
 				extensionEvents.LogErrorEvent("copyfiles", errMessage)
-				return fileNamesMigrated, errors.Wrapf(copyError, errMessage)
+				return fileNamesMigrated, errors.Wrap(copyError, errMessage)
 			} else {
 				message := fmt.Sprintf("File '%s' was copied successfully to '%s'", sourceFileFullPath, destinationFileFullPath)
 				ctx.Log("message", message)
@@ -876,63 +864,93 @@ func downloadScript(ctx *log.Context, dir string, cfg *handlersettings.HandlerSe
 	return scriptFilePath, nil
 }
 
-func downloadArtifacts(ctx *log.Context, dir string, cfg *handlersettings.HandlerSettings) error {
+// downloadArtifacts downloads the artifacts of the settings to dir and returns the paths of the files.
+func downloadArtifacts(ctx *log.Context, dir string, cfg *handlersettings.HandlerSettings) ([]string, error) {
 	artifacts, err := cfg.ReadArtifacts()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if artifacts == nil {
-		return nil
+		return nil, nil
 	}
 
 	ctx.Log("event", "Downloading artifacts")
+	filePaths := make([]string, 0, len(artifacts))
 	for i := 0; i < len(artifacts); i++ {
 		// Download the artifact
 		filePath, err := files.DownloadAndProcessArtifact(ctx, dir, &artifacts[i])
 		if err != nil {
 			ctx.Log("events", "Failed to download artifact", err, "artifact", artifacts[i].ArtifactUri)
-			return errors.Wrapf(err, "failed to download artifact %s", artifacts[i].ArtifactUri)
+			return nil, errors.Wrapf(err, "failed to download artifact %s", artifacts[i].ArtifactUri)
 		}
 
 		ctx.Log("event", "Downloaded artifact complete", "file", filePath)
+
+		// Text artifacts, e.g. configuration files, can be rendered like the script
+		if artifacts[i].Templated && cfg.PublicSettings.Templating {
+			if err := renderTemplate(ctx, filePath, cfg); err != nil {
+				return nil, err
+			}
+		}
+		filePaths = append(filePaths, filePath)
 	}
 
-	return nil
+	return filePaths, nil
 }
 
 // runCmd runs the command (extracted from cfg) in the given dir (assumed to exist).
-func runCmd(ctx *log.Context, dir string, scriptFilePath string, cfg *handlersettings.HandlerSettings, metadata types.RCMetadata) (err error, exitCode int) {
+func runCmd(ctx *log.Context, dir string, scriptFilePath string, cfg *handlersettings.HandlerSettings, metadata types.RCMetadata, report *executionReport) (err error, exitCode int) {
 	ctx.Log("event", "executing command", "output", dir)
 	var scenario string
 
-	// If script is specified - use it directly for command
-	if cfg.Script() != "" {
-		scenario = "embedded-script"
-		// Save the script to a file
-		scriptFilePath = filepath.Join(dir, "script.sh")
-		err := files.SaveScriptFile(scriptFilePath, cfg.Script())
+	// We need to kill previous extension process if exists before starting a new one.
+	pid.KillPreviousExtension(ctx, metadata.PidFilePath)
+
+	// Store the active process id and start time in case its a long running process that needs to be killed later
+	// If process exited successfully the pid file is deleted
+	pid.SaveCurrentPidAndStartTime(metadata.PidFilePath)
+	defer pid.DeleteCurrentPidAndStartTime(metadata.PidFilePath)
+
+	if len(cfg.PublicSettings.Steps) > 0 {
+		begin := time.Now()
+		err, exitCode = runSteps(ctx, dir, cfg, metadata, report)
+		telemetryResult("scenario", fmt.Sprintf("steps;count=%d", len(cfg.PublicSettings.Steps)), err == nil, time.Since(begin))
 		if err != nil {
-			ctx.Log("event", "failed to save script to file", "error", err, "file", scriptFilePath)
-			return errors.Wrap(err, "failed to save script to file"), constants.ExitCode_SaveScriptFailed
+			ctx.Log("event", "failed to execute steps", "error", err, "output", dir)
+			return errors.Wrap(err, "failed to execute command"), exitCode
 		}
+		ctx.Log("event", "executed steps", "output", dir)
+		return nil, constants.ExitCode_Okay
+	}
+
+	if cfg.Script() != "" {
+		scenario = "embedded-script"
 	} else if cfg.ScriptURI() != "" {
 		// If scriptUri is specified then cmd should start it
 		scenario = "public-scriptUri"
 	}
 
-	ctx.Log("event", "prepare command", "scriptFile", scriptFilePath)
-
-	// We need to kill previous extension process if exists before starting a new one.
-	pid.KillPreviousExtension(ctx, metadata.PidFilePath)
+	scriptFilePath, err, exitCode = prepareScript(ctx, dir, scriptFilePath, cfg)
+	if err != nil {
+		return err, exitCode
+	}
+	details := executionDetails(ctx, cfg, scriptFilePath)
+	report.update(func(view *types.RunCommandInstanceView) { view.Details = details })
 
-	// Store the active process id and start time in case its a long running process that needs to be killed later
-	// If process exited successfully the pid file is deleted
-	pid.SaveCurrentPidAndStartTime(metadata.PidFilePath)
-	defer pid.DeleteCurrentPidAndStartTime(metadata.PidFilePath)
+	ctx.Log("event", "prepare command", "scriptFile", scriptFilePath)
 
 	begin := time.Now()
-	err, exitCode = ExecCmdInDir(ctx, scriptFilePath, dir, cfg)
+	opts := exec.Options{
+		RunCommandName: metadata.ExtName,
+		SequenceNumber: metadata.SeqNum,
+		Deadline:       timeoutDeadline(begin, cfg.PublicSettings.TimeoutInSeconds),
+	}
+	err, exitCode = execWithRetries(ctx, scriptFilePath, dir, cfg, opts, func(attempt int) {
+		if cfg.PublicSettings.Retry != nil {
+			report.update(func(view *types.RunCommandInstanceView) { view.Attempts = attempt })
+		}
+	})
 	elapsed := time.Since(begin)
 	isSuccess := err == nil
 
@@ -946,30 +964,122 @@ func runCmd(ctx *log.Context, dir string, scriptFilePath string, cfg *handlerset
 	return nil, constants.ExitCode_Okay
 }
 
-// base64 decode and optionally GZip decompress a script
-func decodeScript(script string) (string, string, error) {
-	// scripts must be base64 encoded
+// executionDetails describes the scripts about to be executed and the user executing them, for the history.
+func executionDetails(ctx *log.Context, cfg *handlersettings.HandlerSettings, scriptFilePaths ...string) *types.ExecutionDetails {
+	details := &types.ExecutionDetails{User: cfg.PublicSettings.RunAsUser}
+	if u, err := planUser(cfg); err == nil {
+		details.User = u
+	}
+	for _, path := range scriptFilePaths {
+		sum, _, err := fileSHA256(path)
+		if err != nil {
+			ctx.Log("message", "failed to hash the script", "error", err)
+		}
+		details.ScriptSHA256 = append(details.ScriptSHA256, sum)
+	}
+	return details
+}
+
+// prepareScript checks the parameters, saves the inline script and renders the script if templating is
+// enabled. It returns the path of the script, which is the downloaded scriptFilePath for a script URI.
+func prepareScript(ctx *log.Context, dir string, scriptFilePath string, cfg *handlersettings.HandlerSettings) (string, error, int) {
+	if err := cfg.ValidateParameters(); err != nil {
+		ctx.Log("event", "invalid parameters", "error", err)
+		return "", errors.Wrap(err, "invalid parameters"), constants.ExitCode_InvalidParameters
+	}
+
+	// If script is specified - use it directly for command
+	if cfg.Script() != "" {
+		// Save the script to a file
+		scriptFilePath = filepath.Join(dir, "script.sh")
+		if err, exitCode := saveInlineScript(ctx, scriptFilePath, cfg); err != nil {
+			return "", err, exitCode
+		}
+	}
+
+	if cfg.PublicSettings.Templating {
+		if err := renderTemplate(ctx, scriptFilePath, cfg); err != nil {
+			return "", err, constants.ExitCode_RenderTemplateFailed
+		}
+	}
+	return scriptFilePath, nil, constants.ExitCode_Okay
+}
+
+// decodeInlineScript decodes the inline script according to its scriptEncoding. The second value
+// describes the decoding for telemetry, it is empty if the script is not encoded.
+func decodeInlineScript(script string, encoding string) (string, string, error) {
+	switch encoding {
+	case handlersettings.ScriptEncodingBase64:
+		s, err := decodeBase64Script(script)
+		if err != nil {
+			return "", "", err
+		}
+		return string(s), fmt.Sprintf("%d;%d;gzip=0", len(script), len(s)), nil
+	case handlersettings.ScriptEncodingGzipBase64:
+		s, err := decodeBase64Script(script)
+		if err != nil {
+			return "", "", err
+		}
+		r, err := gzip.NewReader(bytes.NewReader(s))
+		if err != nil {
+			return "", "", errors.Wrap(err, "script is not gzip compressed")
+		}
+		b, err := decompressScript(r)
+		if err != nil {
+			return "", "", err
+		}
+		return string(b), fmt.Sprintf("%d;%d;gzip=1", len(script), len(b)), nil
+	default:
+		return script, "", nil
+	}
+}
+
+// decodeBase64Script decodes a base64 encoded script, which cannot be larger than maxScriptSize once decoded.
+func decodeBase64Script(script string) ([]byte, error) {
+	// Reject scripts which are too large without decoding them. DecodedLen counts the padding as up to 2 bytes.
+	if base64.StdEncoding.DecodedLen(len(script)) > maxScriptSize+2 {
+		return nil, fmt.Errorf("decoded script exceeds the maximum size of %d bytes", maxScriptSize)
+	}
 	s, err := base64.StdEncoding.DecodeString(script)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to decode script")
+		return nil, errors.Wrap(err, "failed to decode script")
+	}
+	if len(s) > maxScriptSize {
+		return nil, fmt.Errorf("decoded script exceeds the maximum size of %d bytes", maxScriptSize)
 	}
+	return s, nil
+}
 
-	// scripts may be gzip'ed
-	r, err := gzip.NewReader(bytes.NewReader(s))
+// decompressScript decompresses a gzip'ed script, which cannot be larger than maxScriptSize once decompressed.
+func decompressScript(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxScriptSize+1))
 	if err != nil {
-		return string(s), fmt.Sprintf("%d;%d;gzip=0", len(script), len(s)), nil
+		return nil, errors.Wrap(err, "failed to decompress script")
 	}
+	if len(b) > maxScriptSize {
+		return nil, fmt.Errorf("decompressed script exceeds the maximum size of %d bytes", maxScriptSize)
+	}
+	return b, nil
+}
 
-	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-
-	n, err := io.Copy(w, r)
+// saveInlineScript decodes the inline script of cfg and saves it to the file at path.
+func saveInlineScript(ctx *log.Context, path string, cfg *handlersettings.HandlerSettings) (error, int) {
+	script, info, err := decodeInlineScript(cfg.Script(), cfg.ScriptEncoding())
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to decompress script")
+		ctx.Log("event", "failed to decode script", "scriptEncoding", cfg.ScriptEncoding(), "error", err)
+		telemetryResult("scriptEncoding", cfg.ScriptEncoding(), false, 0)
+		return errors.Wrap(err, "failed to decode script"), constants.ExitCode_DecodeScriptFailed
+	}
+	if info != "" {
+		ctx.Log("event", "decoded script", "scriptEncoding", cfg.ScriptEncoding(), "info", info)
+		telemetryResult("scriptEncoding", fmt.Sprintf("%s;%s", cfg.ScriptEncoding(), info), true, 0)
 	}
 
-	w.Flush()
-	return buf.String(), fmt.Sprintf("%d;%d;gzip=1", len(script), n), nil
+	if err := files.SaveScriptFile(path, script); err != nil {
+		ctx.Log("event", "failed to save script to file", "error", err, "file", path)
+		return errors.Wrap(err, "failed to save script to file"), constants.ExitCode_SaveScriptFailed
+	}
+	return nil, constants.ExitCode_Okay
 }
 
 func createOrReplaceAppendBlobUsingManagedIdentity(blobUri string, managedIdentity *handlersettings.RunCommandManagedIdentity) (*appendblob.Client, error) {
@@ -1,6 +1,9 @@
 package commands
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -17,6 +20,7 @@ import (
 	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
 	"github.com/Azure/azure-extension-platform/pkg/logging"
 	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/exec"
 	"github.com/Azure/run-command-handler-linux/internal/files"
 	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
 	"github.com/Azure/run-command-handler-linux/internal/settings"
@@ -441,7 +445,7 @@ func enable_extension(t *testing.T, fakeEnv types.HandlerEnvironment, tempDir st
 	err = encoder.Encode(handlerSettings)
 	require.Nil(t, err, "Could not serialze settings file")
 
-	RunCmd = func(ctx *log.Context, dir, scriptFilePath string, cfg *handlersettings.HandlerSettings, metadata types.RCMetadata) (error, int) {
+	RunCmd = func(ctx *log.Context, dir, scriptFilePath string, cfg *handlersettings.HandlerSettings, metadata types.RCMetadata, report *executionReport) (error, int) {
 		wasCalled = true
 		return nil, 0 // mock behavior
 	}
@@ -500,16 +504,22 @@ func Test_runCmd_success(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	// Ensure that the script succeeds
-	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings) (error, int) {
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
 		return nil, 0
 	}
 	metadata := types.NewRCMetadata("extName", 0, constants.DownloadFolder, DataDir)
+	report := types.RunCommandInstanceView{}
 	err, exitCode := runCmd(log.NewContext(log.NewNopLogger()), dir, "", &handlersettings.HandlerSettings{
 		PublicSettings: handlersettings.PublicSettings{Source: &handlersettings.ScriptSource{Script: script}},
-	}, metadata)
+	}, metadata, newExecutionReport(&report))
 	require.Nil(t, err, "command should run successfully")
 	require.Equal(t, constants.ExitCode_Okay, exitCode)
 
+	// The hash of the script is kept for the history
+	require.NotNil(t, report.Details)
+	require.Equal(t, []string{"0e87632cd46bd4907c516317eb6d81fe0f921a23c7643018f21292894b470681"}, report.Details.ScriptSHA256)
+	require.NotEmpty(t, report.Details.User)
+
 	// Check embedded script if saved to file
 	_, err = os.Stat(filepath.Join(dir, "script.sh"))
 	require.Nil(t, err, "script.sh should exist")
@@ -524,14 +534,14 @@ func Test_runCmd_fail(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	// Ensure that the script fails
-	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings) (error, int) {
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
 		return errors.New("the chipmunks have risen in revolt"), 42
 	}
 
 	metadata := types.NewRCMetadata("extName", 0, constants.DownloadFolder, DataDir)
 	err, exitCode := runCmd(log.NewContext(log.NewNopLogger()), dir, "", &handlersettings.HandlerSettings{
 		PublicSettings: handlersettings.PublicSettings{Source: &handlersettings.ScriptSource{Script: "non-existing-cmd"}},
-	}, metadata)
+	}, metadata, newExecutionReport(&types.RunCommandInstanceView{}))
 	require.NotNil(t, err, "command terminated with exit status")
 	require.Contains(t, err.Error(), "failed to execute command")
 	require.NotEqual(t, constants.ExitCode_Okay, exitCode)
@@ -570,7 +580,7 @@ func Test_downloadArtifacts_Invalid(t *testing.T) {
 	defer srv.Close()
 
 	// The count of public vs protected settings differs
-	err = downloadArtifacts(log.NewContext(log.NewNopLogger()),
+	_, err = downloadArtifacts(log.NewContext(log.NewNopLogger()),
 		dir,
 		&handlersettings.HandlerSettings{
 			PublicSettings: handlersettings.PublicSettings{
@@ -592,7 +602,7 @@ func Test_downloadArtifacts_Invalid(t *testing.T) {
 	require.Contains(t, err.Error(), "RunCommand artifact download failed. Reason: Invalid artifact specification. This is a product bug.")
 
 	// ArtifactIds don't match
-	err = downloadArtifacts(log.NewContext(log.NewNopLogger()),
+	_, err = downloadArtifacts(log.NewContext(log.NewNopLogger()),
 		dir,
 		&handlersettings.HandlerSettings{
 			PublicSettings: handlersettings.PublicSettings{
@@ -626,7 +636,7 @@ func Test_downloadArtifactsFail(t *testing.T) {
 	srv := httptest.NewServer(httpbin.GetMux())
 	defer srv.Close()
 
-	err = downloadArtifacts(log.NewContext(log.NewNopLogger()),
+	_, err = downloadArtifacts(log.NewContext(log.NewNopLogger()),
 		dir,
 		&handlersettings.HandlerSettings{
 			PublicSettings: handlersettings.PublicSettings{
@@ -660,7 +670,7 @@ func Test_downloadArtifacts(t *testing.T) {
 	srv := httptest.NewServer(httpbin.GetMux())
 	defer srv.Close()
 
-	err = downloadArtifacts(log.NewContext(log.NewNopLogger()),
+	filePaths, err := downloadArtifacts(log.NewContext(log.NewNopLogger()),
 		dir,
 		&handlersettings.HandlerSettings{
 			PublicSettings: handlersettings.PublicSettings{
@@ -689,6 +699,8 @@ func Test_downloadArtifacts(t *testing.T) {
 			},
 		})
 	require.Nil(t, err)
+	require.Equal(t, 2, len(filePaths))
+	require.Equal(t, filepath.Join(dir, "flipper"), filePaths[0])
 
 	// check the downloaded files
 	fp := filepath.Join(dir, "flipper")
@@ -700,22 +712,145 @@ func Test_downloadArtifacts(t *testing.T) {
 	require.Nil(t, err, "%s is missing from download dir", fp)
 }
 
-func Test_decodeScript(t *testing.T) {
-	testSubject := "bHMK"
-	s, info, err := decodeScript(testSubject)
+func Test_decodeInlineScript(t *testing.T) {
+	s, info, err := decodeInlineScript("ls\n", "")
+	require.NoError(t, err)
+	require.Equal(t, "", info)
+	require.Equal(t, "ls\n", s)
+
+	s, _, err = decodeInlineScript("bHMK", handlersettings.ScriptEncodingNone)
+	require.NoError(t, err)
+	require.Equal(t, "bHMK", s)
 
+	// the encoded scripts are decoded, the info describes the sizes for telemetry
+	s, info, err = decodeInlineScript("bHMK", handlersettings.ScriptEncodingBase64)
 	require.NoError(t, err)
-	require.Equal(t, info, "4;3;gzip=0")
-	require.Equal(t, s, "ls\n")
+	require.Equal(t, "4;3;gzip=0", info)
+	require.Equal(t, "ls\n", s)
+
+	// base64 scripts are not decompressed
+	gzipped := "H4sIACD731kAA8sp5gIAfShLWgMAAAA="
+	s, _, err = decodeInlineScript(gzipped, handlersettings.ScriptEncodingBase64)
+	require.NoError(t, err)
+	require.Equal(t, 23, len(s))
+
+	s, info, err = decodeInlineScript(gzipped, handlersettings.ScriptEncodingGzipBase64)
+	require.NoError(t, err)
+	require.Equal(t, "32;3;gzip=1", info)
+	require.Equal(t, "ls\n", s)
+
+	_, _, err = decodeInlineScript("bHMK", handlersettings.ScriptEncodingGzipBase64)
+	require.ErrorContains(t, err, "script is not gzip compressed")
+
+	_, _, err = decodeInlineScript("not base64!", handlersettings.ScriptEncodingBase64)
+	require.ErrorContains(t, err, "failed to decode script")
 }
 
-func Test_decodeScriptGzip(t *testing.T) {
-	testSubject := "H4sIACD731kAA8sp5gIAfShLWgMAAAA="
-	s, info, err := decodeScript(testSubject)
+func Test_decodeInlineScript_sizeLimits(t *testing.T) {
+	largest := strings.Repeat("a", maxScriptSize)
+	s, _, err := decodeInlineScript(base64.StdEncoding.EncodeToString([]byte(largest)), handlersettings.ScriptEncodingBase64)
+	require.NoError(t, err)
+	require.Equal(t, largest, s)
+
+	_, _, err = decodeInlineScript(base64.StdEncoding.EncodeToString([]byte(largest+"a")), handlersettings.ScriptEncodingBase64)
+	require.ErrorContains(t, err, "decoded script exceeds the maximum size of 262144 bytes")
 
+	// a small compressed script can expand beyond the limit
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write([]byte(largest + "a"))
 	require.NoError(t, err)
-	require.Equal(t, info, "32;3;gzip=1")
-	require.Equal(t, s, "ls\n")
+	require.NoError(t, w.Close())
+	_, _, err = decodeInlineScript(base64.StdEncoding.EncodeToString(buf.Bytes()), handlersettings.ScriptEncodingGzipBase64)
+	require.ErrorContains(t, err, "decompressed script exceeds the maximum size of 262144 bytes")
+}
+
+func Test_runCmd_encodedScript(t *testing.T) {
+	dir := t.TempDir()
+	originalExec := ExecCmdInDir
+	defer func() { ExecCmdInDir = originalExec }()
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
+		return nil, 0
+	}
+
+	metadata := types.NewRCMetadata("extName", 0, constants.DownloadFolder, DataDir)
+	source := handlersettings.ScriptSource{Script: "H4sIACD731kAA8sp5gIAfShLWgMAAAA=", ScriptEncoding: handlersettings.ScriptEncodingGzipBase64}
+	err, _ := runCmd(log.NewContext(log.NewNopLogger()), dir, "", &handlersettings.HandlerSettings{
+		PublicSettings: handlersettings.PublicSettings{Source: &source},
+	}, metadata, newExecutionReport(&types.RunCommandInstanceView{}))
+	require.Nil(t, err)
+	content, err := os.ReadFile(filepath.Join(dir, "script.sh"))
+	require.Nil(t, err)
+	require.Equal(t, "ls\n", string(content))
+
+	source.Script = "bHMK"
+	err, exitCode := runCmd(log.NewContext(log.NewNopLogger()), dir, "", &handlersettings.HandlerSettings{
+		PublicSettings: handlersettings.PublicSettings{Source: &source},
+	}, metadata, newExecutionReport(&types.RunCommandInstanceView{}))
+	require.ErrorContains(t, err, "failed to decode script")
+	require.Equal(t, constants.ExitCode_DecodeScriptFailed, exitCode)
+}
+
+func Test_runCmd_invalidParameters(t *testing.T) {
+	dir := t.TempDir()
+	originalExec := ExecCmdInDir
+	defer func() { ExecCmdInDir = originalExec }()
+	executed := false
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
+		executed = true
+		return nil, 0
+	}
+
+	metadata := types.NewRCMetadata("extName", 0, constants.DownloadFolder, DataDir)
+	err, exitCode := runCmd(log.NewContext(log.NewNopLogger()), dir, "", &handlersettings.HandlerSettings{
+		PublicSettings: handlersettings.PublicSettings{
+			Source:     &handlersettings.ScriptSource{Script: "date"},
+			Parameters: []handlersettings.ParameterDefinition{{Name: "count", Value: "many", Type: handlersettings.ParameterTypeInt}},
+		},
+	}, metadata, newExecutionReport(&types.RunCommandInstanceView{}))
+	require.EqualError(t, err, "invalid parameters: parameter 'count': is not an integer")
+	require.Equal(t, constants.ExitCode_InvalidParameters, exitCode)
+	require.False(t, executed, "script must not be executed")
+}
+
+func Test_runCmd_templating(t *testing.T) {
+	dir := t.TempDir()
+	originalExec := ExecCmdInDir
+	defer func() { ExecCmdInDir = originalExec }()
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
+		return nil, 0
+	}
+
+	metadata := types.NewRCMetadata("extName", 0, constants.DownloadFolder, DataDir)
+	cfg := handlersettings.HandlerSettings{
+		PublicSettings: handlersettings.PublicSettings{
+			Source:     &handlersettings.ScriptSource{Script: "echo {{ .Parameters.name }} {{ .Parameters.token }}"},
+			Parameters: []handlersettings.ParameterDefinition{{Name: "name", Value: "world"}},
+			Templating: true,
+		},
+		ProtectedSettings: handlersettings.ProtectedSettings{
+			ProtectedParameters: []handlersettings.ParameterDefinition{{Name: "token", Value: "s3cr3t;id"}},
+		},
+	}
+	err, _ := runCmd(log.NewContext(log.NewNopLogger()), dir, "", &cfg, metadata, newExecutionReport(&types.RunCommandInstanceView{}))
+	require.Nil(t, err)
+	content, err := os.ReadFile(filepath.Join(dir, "script.sh"))
+	require.Nil(t, err)
+	require.Equal(t, "echo world 's3cr3t;id'", string(content))
+
+	// placeholders are left as is without templating
+	cfg.PublicSettings.Templating = false
+	err, _ = runCmd(log.NewContext(log.NewNopLogger()), dir, "", &cfg, metadata, newExecutionReport(&types.RunCommandInstanceView{}))
+	require.Nil(t, err)
+	content, err = os.ReadFile(filepath.Join(dir, "script.sh"))
+	require.Nil(t, err)
+	require.Equal(t, "echo {{ .Parameters.name }} {{ .Parameters.token }}", string(content))
+
+	cfg.PublicSettings.Templating = true
+	cfg.PublicSettings.Source.Script = "echo {{ .Parameters.missing }}"
+	err, exitCode := runCmd(log.NewContext(log.NewNopLogger()), dir, "", &cfg, metadata, newExecutionReport(&types.RunCommandInstanceView{}))
+	require.ErrorContains(t, err, "failed to render 'script.sh'")
+	require.Equal(t, constants.ExitCode_RenderTemplateFailed, exitCode)
 }
 
 func Test_downloadScriptUri_BySASFailsSucceedsByManagedIdentity(t *testing.T) {
@@ -760,14 +895,14 @@ func Test_TreatFailureAsDeploymentFailureIsTrue_Fails(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	// Ensure that the script fails
-	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings) (error, int) {
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
 		return errors.New("the chipmunks do not like the script"), 127
 	}
 
 	metadata := types.NewRCMetadata("extName", 0, constants.DownloadFolder, DataDir)
 	err, exitCode := runCmd(log.NewContext(log.NewNopLogger()), dir, "", &handlersettings.HandlerSettings{
 		PublicSettings: handlersettings.PublicSettings{Source: &handlersettings.ScriptSource{Script: script}, TreatFailureAsDeploymentFailure: true},
-	}, metadata)
+	}, metadata, newExecutionReport(&types.RunCommandInstanceView{}))
 	require.NotNil(t, err)
 	require.Contains(t, err.Error(), "failed to execute command: the chipmunks do not like the script")
 	require.NotEqual(t, constants.ExitCode_Okay, exitCode)
@@ -784,14 +919,14 @@ func Test_TreatFailureAsDeploymentFailureIsTrue_SimpleScriptSucceeds(t *testing.
 	defer os.RemoveAll(dir)
 
 	// Ensure that the script succeeds
-	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings) (error, int) {
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
 		return nil, 0
 	}
 
 	metadata := types.NewRCMetadata("extName", 0, constants.DownloadFolder, DataDir)
 	err, exitCode := runCmd(log.NewContext(log.NewNopLogger()), dir, "", &handlersettings.HandlerSettings{
 		PublicSettings: handlersettings.PublicSettings{Source: &handlersettings.ScriptSource{Script: script}, TreatFailureAsDeploymentFailure: false},
-	}, metadata)
+	}, metadata, newExecutionReport(&types.RunCommandInstanceView{}))
 	require.Nil(t, err)
 	require.Equal(t, constants.ExitCode_Okay, exitCode)
 }
@@ -1445,3 +1580,18 @@ func mustReadFile(t *testing.T, p string) string {
 	}
 	return string(b)
 }
+
+func Test_getOutput_headAndTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	stdoutF, stderrF := filepath.Join(dir, "stdout"), filepath.Join(dir, "stderr")
+	require.Nil(t, ioutil.WriteFile(stdoutF, []byte("starting\n"+strings.Repeat("working\n", 1000)+"done\n"), 0600))
+
+	head, tail := 9, 5
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{OutputHeadBytes: &head, OutputTailBytes: &tail}}
+	stdout, stderr := getOutput(log.NewContext(log.NewNopLogger()), &cfg, stdoutF, stderrF)
+	require.Equal(t, "starting\n[... 8000 bytes truncated ...]\ndone\n", stdout)
+	require.Equal(t, "", stderr, "missing stderr file is reported as empty")
+}
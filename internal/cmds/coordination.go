@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/coordination"
+	"github.com/Azure/run-command-handler-linux/internal/errorcatalog"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/instanceview"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// waitForTurn waits for the run commands in dependsOn to complete and takes the exclusive lock of cfg, if any.
+// The instance view is reported as Pending while waiting. The returned lock has to be released once the
+// run command is executed, it is nil if there is no exclusive lock.
+func waitForTurn(ctx *log.Context, h types.HandlerEnvironment, metadata types.RCMetadata, c types.Cmd, cfg *handlersettings.HandlerSettings, report *types.RunCommandInstanceView) (*coordination.Lock, error, int) {
+	if len(cfg.PublicSettings.DependsOn) == 0 && cfg.PublicSettings.ExclusiveLockName == "" {
+		return nil, nil, constants.ExitCode_Okay
+	}
+
+	reportPending := func(message string) {
+		report.ExecutionState = types.Pending
+		report.ExecutionMessage = message
+		instanceview.ReportInstanceView(ctx, h, metadata, types.StatusTransitioning, c, report)
+	}
+	defer func() {
+		if report.ExecutionState == types.Pending {
+			report.ExecutionState = types.Running
+			report.ExecutionMessage = "Execution in progress"
+		}
+	}()
+
+	begin := time.Now()
+	timeout := cfg.WaitTimeout()
+	if len(cfg.PublicSettings.DependsOn) > 0 {
+		for _, name := range cfg.PublicSettings.DependsOn {
+			if name == metadata.ExtName {
+				return nil, errorcatalog.DependencyWaitFailed.New(fmt.Sprintf("run command '%s' cannot depend on itself", name)), constants.ExitCode_WaitForDependencyFailed
+			}
+		}
+
+		err := coordination.WaitForRunCommands(ctx, filepath.Dir(metadata.MostRecentSequence), h.HandlerEnvironment.StatusFolder, cfg.PublicSettings.DependsOn, timeout, func(name string) {
+			reportPending(fmt.Sprintf("Waiting for run command '%s' to complete", name))
+		})
+		telemetryResult("dependsOn", fmt.Sprintf("count=%d", len(cfg.PublicSettings.DependsOn)), err == nil, time.Since(begin))
+		if errors.Is(err, coordination.ErrWaitTimedOut) {
+			return nil, errorcatalog.DependencyWaitTimedOut.Wrapf(err, "failed to wait for dependencies within %s", timeout), constants.ExitCode_WaitForDependencyTimedOut
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to wait for dependencies"), constants.ExitCode_WaitForDependencyFailed
+		}
+	}
+
+	if cfg.PublicSettings.ExclusiveLockName == "" {
+		return nil, nil, constants.ExitCode_Okay
+	}
+	// The time spent waiting for the dependencies counts towards the wait timeout
+	lock, err := coordination.AcquireLock(ctx, DataDir, cfg.PublicSettings.ExclusiveLockName, timeout-time.Since(begin), func() {
+		reportPending(fmt.Sprintf("Waiting for lock '%s'", cfg.PublicSettings.ExclusiveLockName))
+	})
+	telemetryResult("exclusiveLock", "", err == nil, time.Since(begin))
+	if errors.Is(err, coordination.ErrWaitTimedOut) {
+		return nil, errorcatalog.LockWaitTimedOut.Wrapf(err, "failed to acquire lock within %s", timeout), constants.ExitCode_WaitForLockTimedOut
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to acquire lock"), constants.ExitCode_AcquireLockFailed
+	}
+	return lock, nil, constants.ExitCode_Okay
+}
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// Kinds of the files of an execution plan
+const (
+	plannedScript   = "script"
+	plannedStep     = "step"
+	plannedArtifact = "artifact"
+)
+
+// dryRun prepares the execution like runCmd, from the downloaded script and artifacts, without executing
+// anything. The plan of the execution is reported in report, it is partial if the preparation fails.
+func dryRun(ctx *log.Context, dir string, scriptFilePath string, artifactFilePaths []string, cfg *handlersettings.HandlerSettings, report *types.RunCommandInstanceView) (error, int) {
+	ctx.Log("event", "dry run", "output", dir)
+	begin := time.Now()
+	plan := &types.ExecutionPlan{Files: []types.PlannedFile{}}
+	report.Plan = plan
+
+	username, err := planUser(cfg)
+	if err != nil {
+		ctx.Log("event", "failed to lookup the user", "error", err)
+		return err, constants.ExitCode_RunAsLookupUserFailed
+	}
+	plan.User = username
+
+	if len(cfg.PublicSettings.Steps) > 0 {
+		scriptFilePaths, err, exitCode := prepareSteps(ctx, dir, cfg)
+		if err != nil {
+			return err, exitCode
+		}
+		for i, path := range scriptFilePaths {
+			if err, exitCode := planScript(plan, plannedStep, cfg.PublicSettings.Steps[i].Name, path, cfg); err != nil {
+				return errors.Wrapf(err, "step '%s'", cfg.PublicSettings.Steps[i].Name), exitCode
+			}
+		}
+	} else {
+		scriptFilePath, err, exitCode := prepareScript(ctx, dir, scriptFilePath, cfg)
+		if err != nil {
+			return err, exitCode
+		}
+		if err, exitCode := planScript(plan, plannedScript, "", scriptFilePath, cfg); err != nil {
+			return err, exitCode
+		}
+	}
+
+	for _, path := range artifactFilePaths {
+		file, err := plannedFile(plannedArtifact, "", path)
+		if err != nil {
+			return err, constants.ExitCode_DownloadArtifactFailed
+		}
+		plan.Files = append(plan.Files, file)
+	}
+
+	telemetryResult("scenario", "dry-run", true, time.Since(begin))
+	ctx.Log("event", "dry run completed", "files", len(plan.Files))
+	return nil, constants.ExitCode_Okay
+}
+
+// planUser returns the user who would execute the script, checking the RunAs user exists.
+func planUser(cfg *handlersettings.HandlerSettings) (string, error) {
+	if cfg.PublicSettings.RunAsUser != "" {
+		u, err := user.Lookup(cfg.PublicSettings.RunAsUser)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to lookup RunAs user '%s'", cfg.PublicSettings.RunAsUser)
+		}
+		return u.Username, nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to lookup the current user")
+	}
+	return u.Username, nil
+}
+
+// planScript adds the script at path to the plan, with its interpreter. The syntax of bash scripts is checked
+// if the settings require it.
+func planScript(plan *types.ExecutionPlan, kind, name, path string, cfg *handlersettings.HandlerSettings) (error, int) {
+	file, err := plannedFile(kind, name, path)
+	if err != nil {
+		return err, constants.ExitCode_SaveScriptFailed
+	}
+	if file.Interpreter, err = exec.Interpreter(path); err != nil {
+		return errors.Wrapf(err, "failed to read the interpreter of '%s'", filepath.Base(path)), constants.ExitCode_SaveScriptFailed
+	}
+
+	if cfg.PublicSettings.SyntaxCheck && filepath.Base(file.Interpreter) == "bash" {
+		if err := exec.CheckSyntax(path); err != nil {
+			plan.Files = append(plan.Files, file)
+			return errors.Wrapf(err, "invalid script '%s'", filepath.Base(path)), constants.ExitCode_SyntaxCheckFailed
+		}
+		file.SyntaxChecked = true
+	}
+	plan.Files = append(plan.Files, file)
+	return nil, constants.ExitCode_Okay
+}
+
+// plannedFile returns the description of the file at path, with its size and SHA-256 hash.
+func plannedFile(kind, name, path string) (types.PlannedFile, error) {
+	sum, size, err := fileSHA256(path)
+	if err != nil {
+		return types.PlannedFile{}, err
+	}
+	return types.PlannedFile{Kind: kind, Name: name, Path: path, Size: size, SHA256: sum}, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 hash of the file at path and its size.
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to open '%s'", filepath.Base(path))
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to read '%s'", filepath.Base(path))
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// noExecutions fails the test if a script is executed.
+func noExecutions(t *testing.T) {
+	originalExec := ExecCmdInDir
+	t.Cleanup(func() { ExecCmdInDir = originalExec })
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
+		t.Fatalf("a dry run executed %s", scriptFilePath)
+		return nil, 0
+	}
+}
+
+func Test_dryRun(t *testing.T) {
+	noExecutions(t)
+	dir := t.TempDir()
+	artifact := filepath.Join(dir, "config.yaml")
+	require.Nil(t, os.WriteFile(artifact, []byte("abc"), 0600))
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		Source:      &handlersettings.ScriptSource{Script: "echo {{ .Parameters.name }}"},
+		Parameters:  []handlersettings.ParameterDefinition{{Name: "name", Value: "world"}},
+		Templating:  true,
+		DryRun:      true,
+		SyntaxCheck: true,
+	}}
+	report := types.RunCommandInstanceView{}
+
+	err, exitCode := dryRun(log.NewContext(log.NewNopLogger()), dir, "", []string{artifact}, &cfg, &report)
+	require.Nil(t, err)
+	require.Equal(t, constants.ExitCode_Okay, exitCode)
+	require.NotNil(t, report.Plan)
+	require.NotEmpty(t, report.Plan.User)
+	require.Equal(t, []types.PlannedFile{
+		{
+			Kind:          plannedScript,
+			Path:          filepath.Join(dir, "script.sh"),
+			Size:          10,
+			SHA256:        "e13c5f7dcd6a1305f4ede515aaf6f3e286abec60b0fd452d568f422cfbb2be82", // echo world
+			Interpreter:   "/bin/bash",
+			SyntaxChecked: true,
+		},
+		{
+			Kind:   plannedArtifact,
+			Path:   artifact,
+			Size:   3,
+			SHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", // abc
+		},
+	}, report.Plan.Files)
+	_, err = os.Stat(filepath.Join(dir, "stdout"))
+	require.True(t, os.IsNotExist(err), "nothing was executed")
+}
+
+func Test_dryRun_steps(t *testing.T) {
+	noExecutions(t)
+	dir := t.TempDir()
+	cfg := stepsTestSettings(inlineStep("first", "#!/bin/sh\necho one"), inlineStep("second", "echo two"))
+	cfg.PublicSettings.DryRun = true
+	report := types.RunCommandInstanceView{}
+
+	err, _ := dryRun(log.NewContext(log.NewNopLogger()), dir, "", nil, cfg, &report)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(report.Plan.Files))
+	require.Equal(t, plannedStep, report.Plan.Files[0].Kind)
+	require.Equal(t, "first", report.Plan.Files[0].Name)
+	require.Equal(t, "/bin/sh", report.Plan.Files[0].Interpreter)
+	require.Equal(t, "second", report.Plan.Files[1].Name)
+	require.False(t, report.Plan.Files[1].SyntaxChecked)
+}
+
+func Test_dryRun_failures(t *testing.T) {
+	noExecutions(t)
+	dir := t.TempDir()
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		Source:      &handlersettings.ScriptSource{Script: "if true; then echo missing fi"},
+		DryRun:      true,
+		SyntaxCheck: true,
+	}}
+
+	report := types.RunCommandInstanceView{}
+	err, exitCode := dryRun(log.NewContext(log.NewNopLogger()), dir, "", nil, &cfg, &report)
+	require.ErrorContains(t, err, "invalid script 'script.sh': syntax error")
+	require.Equal(t, constants.ExitCode_SyntaxCheckFailed, exitCode)
+	require.Equal(t, 1, len(report.Plan.Files), "the plan is reported up to the failure")
+
+	cfg.PublicSettings.Parameters = []handlersettings.ParameterDefinition{{Name: "count", Value: "many", Type: handlersettings.ParameterTypeInt}}
+	err, exitCode = dryRun(log.NewContext(log.NewNopLogger()), dir, "", nil, &cfg, &report)
+	require.ErrorContains(t, err, "invalid parameters")
+	require.Equal(t, constants.ExitCode_InvalidParameters, exitCode)
+
+	cfg.PublicSettings.RunAsUser = "no-such-user-for-dry-run"
+	err, exitCode = dryRun(log.NewContext(log.NewNopLogger()), dir, "", nil, &cfg, &report)
+	require.ErrorContains(t, err, "failed to lookup RunAs user 'no-such-user-for-dry-run'")
+	require.Equal(t, constants.ExitCode_RunAsLookupUserFailed, exitCode)
+}
+
+func Test_planUser(t *testing.T) {
+	current, err := user.Current()
+	require.Nil(t, err)
+
+	username, err := planUser(&handlersettings.HandlerSettings{})
+	require.Nil(t, err)
+	require.Equal(t, current.Username, username, "the handler executes the script without RunAs user")
+
+	_, err = planUser(&handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{RunAsUser: "no-such-user-for-dry-run"}})
+	require.ErrorContains(t, err, "failed to lookup RunAs user 'no-such-user-for-dry-run'")
+}
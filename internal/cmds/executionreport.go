@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/types"
+)
+
+// executionReport is the instance view of the execution of enable. The execution updates it while the
+// status of the execution is reported periodically, the lock is shared by both.
+type executionReport struct {
+	mu   sync.Mutex
+	view *types.RunCommandInstanceView
+}
+
+func newExecutionReport(view *types.RunCommandInstanceView) *executionReport {
+	return &executionReport{view: view}
+}
+
+// update changes the instance view under the lock.
+func (r *executionReport) update(f func(view *types.RunCommandInstanceView)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(r.view)
+}
+
+// snapshot changes the instance view with f, if not nil, and returns a copy of it taken under the lock
+// which can be reported while the execution goes on.
+func (r *executionReport) snapshot(f func(view *types.RunCommandInstanceView)) types.RunCommandInstanceView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f != nil {
+		f(r.view)
+	}
+	view := *r.view
+	view.Steps = append([]types.StepInstanceView(nil), r.view.Steps...)
+	return view
+}
+
+// reportPeriodically calls report every updateStatusInSeconds until the returned function is called, which
+// returns once the report in progress, if any, completed.
+func reportPeriodically(report func()) (stop func()) {
+	ticker := time.NewTicker(time.Duration(updateStatusInSeconds) * time.Second)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report()
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		done <- true
+	}
+}
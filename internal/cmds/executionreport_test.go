@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/instanceview"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_executionReport_snapshot(t *testing.T) {
+	view := types.RunCommandInstanceView{Steps: []types.StepInstanceView{{Name: "first", ExecutionState: types.Running}}}
+	report := newExecutionReport(&view)
+
+	snapshot := report.snapshot(func(view *types.RunCommandInstanceView) { view.Output = "partial" })
+	report.update(func(view *types.RunCommandInstanceView) { view.Steps[0].ExecutionState = types.Succeeded })
+
+	require.Equal(t, "partial", view.Output)
+	require.Equal(t, "partial", snapshot.Output)
+	require.EqualValues(t, types.Running, snapshot.Steps[0].ExecutionState, "the steps are copied")
+	require.EqualValues(t, types.Succeeded, view.Steps[0].ExecutionState)
+}
+
+// The status is reported while the steps update the instance view, run with -race.
+func Test_reportPeriodically_duringSteps(t *testing.T) {
+	realExecutions(t)
+	defer func(old int) { updateStatusInSeconds = old }(updateStatusInSeconds)
+	updateStatusInSeconds = 1
+
+	view := types.RunCommandInstanceView{ExecutionState: types.Running}
+	report := newExecutionReport(&view)
+	var mu sync.Mutex
+	var reported []string
+	stopReports := reportPeriodically(func() {
+		snapshot := report.snapshot(func(view *types.RunCommandInstanceView) { view.Output = "partial" })
+		msg, err := instanceview.SerializeInstanceView(&snapshot)
+		require.Nil(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, msg)
+	})
+
+	cfg := stepsTestSettings(inlineStep("first", "sleep 1.2; echo one"), inlineStep("second", "sleep 1.2; echo two"))
+	err, _ := runSteps(log.NewContext(log.NewNopLogger()), t.TempDir(), cfg, types.RCMetadata{}, report)
+	stopReports()
+	require.Nil(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, reported, "the status was reported during the execution")
+	require.Contains(t, reported[0], `"name":"first"`)
+	require.EqualValues(t, types.Succeeded, view.Steps[1].ExecutionState)
+	require.Equal(t, "two\n", view.Steps[1].Output)
+}
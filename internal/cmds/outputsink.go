@@ -0,0 +1,295 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/errorcatalog"
+	"github.com/Azure/run-command-handler-linux/internal/files"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/Azure/run-command-handler-linux/pkg/download"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const httpSinkTimeout = 30 * time.Second
+
+// outputStream identifies which stream of the script is forwarded to a sink
+type outputStream string
+
+const (
+	stdoutStream outputStream = "stdout"
+	stderrStream outputStream = "stderr"
+)
+
+// OutputSink receives the output of the script while it is being produced.
+// Each stream (stdout and stderr) is forwarded to its own sink.
+type OutputSink interface {
+	// Append forwards the next chunk of output to the sink.
+	Append(ctx *log.Context, b []byte) error
+
+	// Close flushes pending output and releases the resources held by the sink.
+	Close() error
+}
+
+// newOutputSink creates the sink configured for the given stream. A nil sink is returned
+// if the stream is not forwarded anywhere. On failure, the exit code to report is returned.
+func newOutputSink(ctx *log.Context, stream outputStream, cfg *handlersettings.HandlerSettings, metadata types.RCMetadata) (OutputSink, int, error) {
+	sinkSettings := cfg.OutputStreamSink()
+	blobURI, sasToken, managedIdentity, headers := cfg.OutputBlobURI, cfg.ProtectedSettings.OutputBlobSASToken, cfg.ProtectedSettings.OutputBlobManagedIdentity, cfg.ProtectedSettings.OutputSinkHeaders
+	if stream == stderrStream {
+		sinkSettings = cfg.ErrorStreamSink()
+		blobURI, sasToken, managedIdentity, headers = cfg.ErrorBlobURI, cfg.ProtectedSettings.ErrorBlobSASToken, cfg.ProtectedSettings.ErrorBlobManagedIdentity, cfg.ProtectedSettings.ErrorSinkHeaders
+	}
+
+	if sinkSettings == nil {
+		return nil, constants.ExitCode_Okay, nil
+	}
+
+	ctx.Log("message", fmt.Sprintf("forwarding %s to sink of type '%s'", stream, sinkSettings.Type))
+	switch sinkSettings.Type {
+	case handlersettings.OutputSinkAppendBlob:
+		// Create or Replace the blob. Fail the command if create or replace fails.
+		blobSASRef, blobAppendClient, err := createOrReplaceAppendBlob(blobURI, sasToken, managedIdentity, ctx)
+		if err != nil {
+			return nil, constants.ExitCode_BlobCreateOrReplaceFailed, errorcatalog.OutputBlobCreateFailed.Wrapf(err, "error creating AppendBlob '%s' using SAS token or Managed identity", blobURI)
+		}
+		return &appendBlobSink{blobRef: blobSASRef, blobClient: blobAppendClient}, constants.ExitCode_Okay, nil
+
+	case handlersettings.OutputSinkLocalFile:
+		sink, err := newLocalFileSink(sinkSettings.Path, stream, metadata)
+		if err != nil {
+			return nil, constants.ExitCode_OutputSinkCreateFailed, errors.Wrapf(err, "failed to create local file sink for %s", stream)
+		}
+		return sink, constants.ExitCode_Okay, nil
+
+	case handlersettings.OutputSinkSyslog:
+		sink, err := newSyslogSink(sinkSettings.Address, sinkSettings.Tag, stream, metadata)
+		if err != nil {
+			return nil, constants.ExitCode_OutputSinkCreateFailed, errors.Wrapf(err, "failed to connect to syslog for %s", stream)
+		}
+		return sink, constants.ExitCode_Okay, nil
+
+	case handlersettings.OutputSinkHTTP:
+		return newHTTPSink(sinkSettings.URI, headers, stream, metadata), constants.ExitCode_Okay, nil
+	}
+
+	return nil, constants.ExitCode_OutputSinkCreateFailed, fmt.Errorf("unsupported output sink type '%s'", sinkSettings.Type)
+}
+
+// appendToSink forwards a file (from seeking position to the end of the file) to the sink. Returns the new position (end of the file).
+// The position is not moved if the sink fails, so the same output is forwarded again on the next call.
+func appendToSink(sourceFilePath string, sink OutputSink, outputFilePosition int64, ctx *log.Context) (int64, error) {
+	if sink == nil {
+		return outputFilePosition, nil
+	}
+
+	newOutput, err := files.GetFileFromPosition(sourceFilePath, outputFilePosition)
+	if err != nil {
+		ctx.Log("message", "AppendToSink - GetFileFromPosition failed.", "error", err)
+		return outputFilePosition, err
+	}
+
+	if len(newOutput) > 0 {
+		err = sink.Append(ctx, newOutput)
+		if err != nil {
+			ctx.Log("message", "AppendToSink failed", "error", err)
+			return outputFilePosition, err
+		}
+		outputFilePosition += int64(len(newOutput))
+	}
+
+	return outputFilePosition, nil
+}
+
+// closeSink closes the sink if any and logs failures. Output that could not be
+// forwarded is still available in the local stdout / stderr files.
+func closeSink(ctx *log.Context, sink OutputSink) {
+	if sink == nil {
+		return
+	}
+	if err := sink.Close(); err != nil {
+		ctx.Log("message", "failed to close output sink", "error", err)
+	}
+}
+
+// appendBlobSink appends the output to an Azure storage append blob, either through a SAS token or a managed identity.
+type appendBlobSink struct {
+	blobRef    *storage.Blob
+	blobClient *appendblob.Client
+}
+
+func (s *appendBlobSink) Append(ctx *log.Context, b []byte) error {
+	if s.blobRef != nil {
+		return s.blobRef.AppendBlock(b, nil)
+	}
+	if s.blobClient != nil {
+		_, err := s.blobClient.AppendBlock(context.Background(), streaming.NopCloser(bytes.NewReader(b)), nil)
+		return err
+	}
+	return nil
+}
+
+func (s *appendBlobSink) Close() error {
+	return nil
+}
+
+// localFileSink copies the output to a persistent log directory, which survives the cleanup of the download directory.
+type localFileSink struct {
+	f *os.File
+}
+
+func newLocalFileSink(dir string, stream outputStream, metadata types.RCMetadata) (*localFileSink, error) {
+	if dir == "" {
+		dir = constants.OutputLogDirectory
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create directory '%s'", dir)
+	}
+
+	// E.g. /var/log/azure/run-command-handler/output/RC0001.2.stdout.log
+	path := filepath.Join(dir, fmt.Sprintf("%s.%d.%s.log", metadata.ExtName, metadata.SeqNum, stream))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open file '%s'", path)
+	}
+	return &localFileSink{f: f}, nil
+}
+
+func (s *localFileSink) Append(ctx *log.Context, b []byte) error {
+	_, err := s.f.Write(b)
+	return err
+}
+
+func (s *localFileSink) Close() error {
+	return s.f.Close()
+}
+
+// syslogSink sends every line of output as a syslog message over a local socket. stdout lines
+// are sent with the info severity and stderr lines with the err severity.
+type syslogSink struct {
+	w       *syslog.Writer
+	stream  outputStream
+	pending []byte // last line of the previous chunk, if it was not terminated yet
+}
+
+func newSyslogSink(address string, tag string, stream outputStream, metadata types.RCMetadata) (*syslogSink, error) {
+	if tag == "" {
+		tag = metadata.ExtName
+	}
+
+	network := ""
+	if address != "" {
+		network = "unixgram"
+	}
+
+	w, err := syslog.Dial(network, address, syslog.LOG_USER|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w, stream: stream}, nil
+}
+
+func (s *syslogSink) Append(ctx *log.Context, b []byte) error {
+	data := append(s.pending, b...)
+	s.pending = nil
+
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			s.pending = data
+			return nil
+		}
+		if err := s.send(string(data[:i])); err != nil {
+			return err
+		}
+		data = data[i+1:]
+	}
+	return nil
+}
+
+func (s *syslogSink) send(line string) error {
+	if s.stream == stderrStream {
+		return s.w.Err(line)
+	}
+	return s.w.Info(line)
+}
+
+func (s *syslogSink) Close() error {
+	var err error
+	if len(s.pending) > 0 {
+		err = s.send(string(s.pending))
+		s.pending = nil
+	}
+	if closeErr := s.w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// httpSink sends every chunk of output in the body of a POST request to a generic endpoint, the receiver
+// appends the chunks. The run command name, sequence number and stream are sent as headers so the receiver
+// can correlate the chunks.
+type httpSink struct {
+	client  *http.Client
+	uri     string
+	headers map[string]string
+	stream  outputStream
+	extName string
+	seqNum  int
+}
+
+func newHTTPSink(uri string, headers map[string]string, stream outputStream, metadata types.RCMetadata) *httpSink {
+	return &httpSink{
+		client:  &http.Client{Timeout: httpSinkTimeout},
+		uri:     uri,
+		headers: headers,
+		stream:  stream,
+		extName: metadata.ExtName,
+		seqNum:  metadata.SeqNum,
+	}
+}
+
+func (s *httpSink) Append(ctx *log.Context, b []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.uri, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create request for '%s'", download.GetUriForLogging(s.uri))
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("X-Run-Command-Name", s.extName)
+	req.Header.Set("X-Run-Command-Sequence", strconv.Itoa(s.seqNum))
+	req.Header.Set("X-Run-Command-Stream", string(s.stream))
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "request to '%s' failed", download.GetUriForLogging(s.uri))
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("request to '%s' failed with status code %d", download.GetUriForLogging(s.uri), resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
@@ -0,0 +1,176 @@
+package commands
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+type failingSink struct {
+	appended []byte
+	fail     bool
+}
+
+func (s *failingSink) Append(ctx *log.Context, b []byte) error {
+	if s.fail {
+		return errors.New("the chipmunks ate the output")
+	}
+	s.appended = append(s.appended, b...)
+	return nil
+}
+
+func (s *failingSink) Close() error { return nil }
+
+func Test_newOutputSink_notConfigured(t *testing.T) {
+	metadata := types.NewRCMetadata("extName", 0, constants.DownloadFolder, DataDir)
+	sink, exitCode, err := newOutputSink(log.NewContext(log.NewNopLogger()), stdoutStream, &handlersettings.HandlerSettings{}, metadata)
+	require.Nil(t, err)
+	require.Nil(t, sink)
+	require.Equal(t, constants.ExitCode_Okay, exitCode)
+}
+
+func Test_appendToSink_keepsPositionOnFailure(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "stdout")
+	require.Nil(t, os.WriteFile(source, []byte("hello\n"), 0600))
+
+	ctx := log.NewContext(log.NewNopLogger())
+	sink := &failingSink{fail: true}
+	position, err := appendToSink(source, sink, 0, ctx)
+	require.NotNil(t, err)
+	require.EqualValues(t, 0, position)
+
+	sink.fail = false
+	position, err = appendToSink(source, sink, position, ctx)
+	require.Nil(t, err)
+	require.EqualValues(t, 6, position)
+
+	require.Nil(t, os.WriteFile(source, []byte("hello\nworld\n"), 0600))
+	position, err = appendToSink(source, sink, position, ctx)
+	require.Nil(t, err)
+	require.EqualValues(t, 12, position)
+	require.Equal(t, "hello\nworld\n", string(sink.appended))
+}
+
+func Test_localFileSink(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		ErrorSink: &handlersettings.OutputSinkSettings{Type: handlersettings.OutputSinkLocalFile, Path: filepath.Join(dir, "logs")},
+	}}
+	metadata := types.NewRCMetadata("RC0001", 3, constants.DownloadFolder, DataDir)
+	ctx := log.NewContext(log.NewNopLogger())
+
+	sink, _, err := newOutputSink(ctx, stderrStream, &cfg, metadata)
+	require.Nil(t, err)
+	require.Nil(t, sink.Append(ctx, []byte("first ")))
+	require.Nil(t, sink.Append(ctx, []byte("second\n")))
+	require.Nil(t, sink.Close())
+
+	content, err := os.ReadFile(filepath.Join(dir, "logs", "RC0001.3.stderr.log"))
+	require.Nil(t, err)
+	require.Equal(t, "first second\n", string(content))
+}
+
+func Test_httpSink(t *testing.T) {
+	var bodies []string
+	var headers []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method, "the chunks are appended")
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		headers = append(headers, r.Header)
+	}))
+	defer srv.Close()
+
+	cfg := handlersettings.HandlerSettings{
+		PublicSettings: handlersettings.PublicSettings{
+			OutputSink: &handlersettings.OutputSinkSettings{Type: handlersettings.OutputSinkHTTP, URI: srv.URL + "/logs"},
+		},
+		ProtectedSettings: handlersettings.ProtectedSettings{
+			OutputSinkHeaders: map[string]string{"Authorization": "Bearer chipmunk"},
+		},
+	}
+	metadata := types.NewRCMetadata("RC0001", 3, constants.DownloadFolder, DataDir)
+	ctx := log.NewContext(log.NewNopLogger())
+
+	sink, _, err := newOutputSink(ctx, stdoutStream, &cfg, metadata)
+	require.Nil(t, err)
+	require.Nil(t, sink.Append(ctx, []byte("hello\n")))
+	require.Nil(t, sink.Append(ctx, []byte("world\n")))
+	require.Nil(t, sink.Close())
+
+	require.Equal(t, []string{"hello\n", "world\n"}, bodies, "one request per chunk, in order")
+	require.Equal(t, "Bearer chipmunk", headers[0].Get("Authorization"))
+	require.Equal(t, "RC0001", headers[0].Get("X-Run-Command-Name"))
+	require.Equal(t, "3", headers[0].Get("X-Run-Command-Sequence"))
+	require.Equal(t, "stdout", headers[0].Get("X-Run-Command-Stream"))
+}
+
+func Test_httpSink_failureStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	metadata := types.NewRCMetadata("RC0001", 3, constants.DownloadFolder, DataDir)
+	sink := newHTTPSink(srv.URL, nil, stdoutStream, metadata)
+	err := sink.Append(log.NewContext(log.NewNopLogger()), []byte("hello\n"))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "failed with status code 403")
+}
+
+func Test_syslogSink_sendsCompleteLines(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		OutputSink: &handlersettings.OutputSinkSettings{Type: handlersettings.OutputSinkSyslog, Address: socketPath, Tag: "chipmunk"},
+	}}
+	metadata := types.NewRCMetadata("RC0001", 3, constants.DownloadFolder, DataDir)
+	ctx := log.NewContext(log.NewNopLogger())
+
+	sink, _, err := newOutputSink(ctx, stdoutStream, &cfg, metadata)
+	require.Nil(t, err)
+	require.Nil(t, sink.Append(ctx, []byte("first line\nsecond ")))
+	require.Nil(t, sink.Append(ctx, []byte("line\nthird")))
+	require.Nil(t, sink.Close())
+
+	var messages []string
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		require.Nil(t, err)
+		messages = append(messages, string(buf[:n]))
+	}
+
+	require.True(t, strings.HasSuffix(strings.TrimSpace(messages[0]), "chipmunk["+strconv.Itoa(os.Getpid())+"]: first line"), messages[0])
+	require.True(t, strings.HasSuffix(strings.TrimSpace(messages[1]), ": second line"), messages[1])
+	require.True(t, strings.HasSuffix(strings.TrimSpace(messages[2]), ": third"), messages[2])
+}
@@ -0,0 +1,24 @@
+package commands
+
+import (
+	"github.com/Azure/run-command-handler-linux/internal/doctor"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/go-kit/kit/log"
+)
+
+// preflight runs the checks of the doctor which apply to the execution of cfg. The warnings are logged, the failed
+// checks are returned as an error with their remediation.
+func preflight(ctx *log.Context, cfg *handlersettings.HandlerSettings) error {
+	env := doctor.Environment{DataDir: DataDir}
+	if cfg.PublicSettings.RunAsUser != "" {
+		env.RunAsUsers = []string{cfg.PublicSettings.RunAsUser}
+	}
+
+	findings, err := doctor.Preflight(env)
+	for _, f := range findings {
+		if f.Result == doctor.Warn {
+			ctx.Log("message", "preflight check warning", "check", f.Check, "warning", f.Message)
+		}
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_preflight(t *testing.T) {
+	defer func(dataDir string) { DataDir = dataDir }(DataDir)
+	DataDir = t.TempDir()
+	ctx := log.NewContext(log.NewNopLogger())
+
+	cfg := handlersettings.HandlerSettings{}
+	require.NoError(t, preflight(ctx, &cfg))
+
+	cfg.PublicSettings.RunAsUser = "root"
+	require.NoError(t, preflight(ctx, &cfg))
+}
+
+func Test_preflight_missingRunAsUser(t *testing.T) {
+	defer func(dataDir string) { DataDir = dataDir }(DataDir)
+	DataDir = t.TempDir()
+
+	cfg := handlersettings.HandlerSettings{}
+	cfg.PublicSettings.RunAsUser = "no-such-user-of-the-preflight"
+	err := preflight(log.NewContext(log.NewNopLogger()), &cfg)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "runas-users: the RunAs users no-such-user-of-the-preflight do not exist")
+}
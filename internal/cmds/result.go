@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// maxResultSize is the maximum size of the result written by the script, which is transmitted in the .status file
+const maxResultSize = 8 * 1024
+
+// readScriptResult returns the JSON object the script wrote to its result file, compacted.
+// A nil result is returned if the file does not exist or is empty.
+func readScriptResult(path string) (json.RawMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to open result file")
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, maxResultSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read result file")
+	}
+	if len(b) > maxResultSize {
+		return nil, fmt.Errorf("result file exceeds the maximum size of %d bytes", maxResultSize)
+	}
+
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if b[0] != '{' || !json.Valid(b) {
+		return nil, errors.New("result file does not contain a valid JSON object")
+	}
+
+	var result bytes.Buffer
+	if err := json.Compact(&result, b); err != nil {
+		return nil, errors.Wrap(err, "failed to compact result")
+	}
+	return result.Bytes(), nil
+}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readScriptResult(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "result.json")
+
+	// no result file
+	result, err := readScriptResult(path)
+	require.Nil(t, err)
+	require.Nil(t, result)
+
+	// empty result file
+	require.Nil(t, os.WriteFile(path, []byte(" \n"), 0600))
+	result, err = readScriptResult(path)
+	require.Nil(t, err)
+	require.Nil(t, result)
+
+	// valid object is compacted
+	require.Nil(t, os.WriteFile(path, []byte("{\n  \"rebootRequired\": true,\n  \"packages\": [\"a\", \"b\"]\n}\n"), 0600))
+	result, err = readScriptResult(path)
+	require.Nil(t, err)
+	require.Equal(t, `{"rebootRequired":true,"packages":["a","b"]}`, string(result))
+}
+
+func Test_readScriptResult_invalid(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "result.json")
+
+	for _, content := range []string{`["not", "an", "object"]`, `{"truncated": `, `done`} {
+		require.Nil(t, os.WriteFile(path, []byte(content), 0600))
+		_, err = readScriptResult(path)
+		require.EqualError(t, err, "result file does not contain a valid JSON object", content)
+	}
+
+	require.Nil(t, os.WriteFile(path, []byte(`{"big": "`+strings.Repeat("x", maxResultSize)+`"}`), 0600))
+	_, err = readScriptResult(path)
+	require.EqualError(t, err, "result file exceeds the maximum size of 8192 bytes")
+}
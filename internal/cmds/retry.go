@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// sleep waits before a retry. Replaced in tests.
+var sleep = time.Sleep
+
+// execWithRetries executes the script in dir, and executes it again while it fails with an exit code the
+// retry policy of cfg allows to retry. opts are the options of the first attempt, all the attempts share
+// its deadline. onAttempt is called before every attempt with its number, starting at 1.
+func execWithRetries(ctx *log.Context, scriptFilePath string, dir string, cfg *handlersettings.HandlerSettings, opts exec.Options, onAttempt func(attempt int)) (error, int) {
+	policy := handlersettings.RetryPolicy{MaxAttempts: 1}
+	if cfg.PublicSettings.Retry != nil {
+		policy = *cfg.PublicSettings.Retry
+	}
+
+	for attempt := 1; ; attempt++ {
+		opts.Attempt = attempt
+		if attempt > 1 {
+			// The output of a retry is appended to the output of the previous attempts
+			opts.Append, opts.Marker = true, fmt.Sprintf("[... attempt %d ...]", attempt)
+		}
+		onAttempt(attempt)
+
+		err, exitCode := ExecCmdInDir(ctx, scriptFilePath, dir, cfg, opts)
+		if err == nil || attempt >= policy.MaxAttempts {
+			return err, exitCode
+		}
+
+		// Only failures of the script itself are retried, a timeout leaves no time for another attempt
+		var exitErr *exec.ScriptExitError
+		if !errors.As(err, &exitErr) || exitErr.Signaled || !policy.ShouldRetry(exitErr.ExitCode) || isSuccessExitCode(cfg, exitErr.ExitCode) {
+			return err, exitCode
+		}
+
+		delay := policy.Delay(attempt)
+		if !opts.Deadline.IsZero() && time.Now().Add(delay).After(opts.Deadline) {
+			ctx.Log("message", fmt.Sprintf("attempt %d failed, not enough time left to retry before the timeout", attempt))
+			return err, exitCode
+		}
+
+		ctx.Log("message", fmt.Sprintf("attempt %d of %d failed with exit code %d, retrying in %s", attempt, policy.MaxAttempts, exitErr.ExitCode, delay))
+		telemetryResult("retry", fmt.Sprintf("attempt %d failed with exit code %d", attempt, exitErr.ExitCode), false, 0)
+		sleep(delay)
+	}
+}
+
+// timeoutDeadline returns when a script started at the specified time has to be killed, or a zero time if there is no timeout.
+func timeoutDeadline(start time.Time, timeoutInSeconds int) time.Time {
+	if timeoutInSeconds <= 0 {
+		return time.Time{}
+	}
+	return start.Add(time.Duration(timeoutInSeconds) * time.Second)
+}
+
+// isSuccessExitCode returns true if the exit code is one of the success exit codes of cfg.
+func isSuccessExitCode(cfg *handlersettings.HandlerSettings, exitCode int) bool {
+	for _, code := range cfg.PublicSettings.SuccessExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// mockExecutions replaces the execution of the script by the given results, one per attempt,
+// and records the options and the delays of every attempt.
+func mockExecutions(t *testing.T, exitCodes ...int) (*[]exec.Options, *[]time.Duration) {
+	var options []exec.Options
+	var delays []time.Duration
+
+	originalExec, originalSleep := ExecCmdInDir, sleep
+	t.Cleanup(func() { ExecCmdInDir, sleep = originalExec, originalSleep })
+
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
+		options = append(options, opts)
+		exitCode := exitCodes[len(options)-1]
+		if exitCode == 0 {
+			return nil, 0
+		}
+		return &exec.ScriptExitError{ExitCode: exitCode}, exitCode
+	}
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	return &options, &delays
+}
+
+// retryTestExec executes the script like runCmd does
+func retryTestExec(cfg *handlersettings.HandlerSettings, report *types.RunCommandInstanceView) (error, int) {
+	opts := exec.Options{Deadline: timeoutDeadline(time.Now(), cfg.PublicSettings.TimeoutInSeconds)}
+	return execWithRetries(log.NewContext(log.NewNopLogger()), "script.sh", "/dir", cfg, opts, func(attempt int) {
+		report.Attempts = attempt
+	})
+}
+
+func Test_execWithRetries_noPolicy(t *testing.T) {
+	options, _ := mockExecutions(t, 1)
+	report := types.RunCommandInstanceView{}
+
+	err, exitCode := retryTestExec(&handlersettings.HandlerSettings{}, &report)
+	require.NotNil(t, err)
+	require.Equal(t, 1, exitCode)
+	require.Equal(t, []exec.Options{{Attempt: 1}}, *options)
+	require.Equal(t, 1, report.Attempts)
+}
+
+func Test_execWithRetries_succeedsAfterRetries(t *testing.T) {
+	options, delays := mockExecutions(t, 1, 1, 0)
+	report := types.RunCommandInstanceView{}
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		Retry: &handlersettings.RetryPolicy{MaxAttempts: 5, DelaySeconds: 2, Backoff: handlersettings.RetryBackoffExponential},
+	}}
+
+	err, exitCode := retryTestExec(&cfg, &report)
+	require.Nil(t, err)
+	require.Equal(t, 0, exitCode)
+	require.Equal(t, 3, len(*options))
+	require.Equal(t, exec.Options{Attempt: 1}, (*options)[0])
+	require.Equal(t, exec.Options{Attempt: 3, Append: true, Marker: "[... attempt 3 ...]"}, (*options)[2])
+	require.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second}, *delays)
+	require.Equal(t, 3, report.Attempts)
+}
+
+func Test_execWithRetries_givesUp(t *testing.T) {
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		Retry: &handlersettings.RetryPolicy{MaxAttempts: 3, RetryOnExitCodes: []int{100}},
+	}}
+
+	// after the maximum number of attempts
+	options, _ := mockExecutions(t, 100, 100, 100, 0)
+	report := types.RunCommandInstanceView{}
+	err, exitCode := retryTestExec(&cfg, &report)
+	require.NotNil(t, err)
+	require.Equal(t, 100, exitCode)
+	require.Equal(t, 3, len(*options))
+	require.Equal(t, 3, report.Attempts)
+
+	// on an exit code which is not retried
+	options, _ = mockExecutions(t, 100, 2, 0)
+	err, exitCode = retryTestExec(&cfg, &report)
+	require.NotNil(t, err)
+	require.Equal(t, 2, exitCode)
+	require.Equal(t, 2, len(*options))
+
+	// on a success exit code
+	options, _ = mockExecutions(t, 3010, 0)
+	cfg.PublicSettings.Retry.RetryOnExitCodes = nil
+	cfg.PublicSettings.SuccessExitCodes = []int{3010}
+	_, exitCode = retryTestExec(&cfg, &report)
+	require.Equal(t, 3010, exitCode)
+	require.Equal(t, 1, len(*options))
+}
+
+func Test_execWithRetries_withinTimeout(t *testing.T) {
+	options, delays := mockExecutions(t, 1, 0)
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		TimeoutInSeconds: 60,
+		Retry:            &handlersettings.RetryPolicy{MaxAttempts: 2, DelaySeconds: 120},
+	}}
+
+	err, _ := retryTestExec(&cfg, &types.RunCommandInstanceView{})
+	require.NotNil(t, err, "the delay exceeds the timeout, there is no retry")
+	require.Equal(t, 1, len(*options))
+	require.Empty(t, *delays)
+
+	// all the attempts share the same deadline
+	options, _ = mockExecutions(t, 1, 0)
+	cfg.PublicSettings.Retry.DelaySeconds = 0
+	err, _ = retryTestExec(&cfg, &types.RunCommandInstanceView{})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(*options))
+	require.False(t, (*options)[0].Deadline.IsZero())
+	require.Equal(t, (*options)[0].Deadline, (*options)[1].Deadline)
+}
+
+func Test_execWithRetries_otherFailuresAreNotRetried(t *testing.T) {
+	originalExec := ExecCmdInDir
+	defer func() { ExecCmdInDir = originalExec }()
+
+	attempts := 0
+	ExecCmdInDir = func(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts exec.Options) (error, int) {
+		attempts++
+		return errors.New("failed to open stdout file"), -205
+	}
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{Retry: &handlersettings.RetryPolicy{MaxAttempts: 3}}}
+
+	err, _ := retryTestExec(&cfg, &types.RunCommandInstanceView{})
+	require.NotNil(t, err)
+	require.Equal(t, 1, attempts)
+}
@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/files"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// Bytes of the output of a step reported in its status
+const (
+	stepOutputHeadBytes = 256
+	stepOutputTailBytes = 1024
+)
+
+// stepsDir returns the directory where the script of the specified step is saved.
+func stepsDir(dir string, i int) string {
+	return filepath.Join(dir, "steps", strconv.Itoa(i))
+}
+
+// stepSettings returns the settings the step is executed with: the settings of the run command, with the
+// source, parameters and timeout of the step.
+func stepSettings(cfg *handlersettings.HandlerSettings, step handlersettings.StepSettings) *handlersettings.HandlerSettings {
+	stepCfg := *cfg
+	stepCfg.PublicSettings.Steps = nil
+	stepCfg.PublicSettings.Source = step.Source
+	stepCfg.PublicSettings.Parameters = step.Parameters
+	stepCfg.PublicSettings.TimeoutInSeconds = step.TimeoutInSeconds
+	// The SAS token of the run command is for its source, not for the scripts of the steps
+	stepCfg.ProtectedSettings.SourceSASToken = ""
+	return &stepCfg
+}
+
+// prepareSteps checks the parameters and saves or downloads the script of every step, so a step which
+// cannot be executed fails the run command before any step runs. It returns the path of the script of every step.
+func prepareSteps(ctx *log.Context, dir string, cfg *handlersettings.HandlerSettings) ([]string, error, int) {
+	scriptFilePaths := make([]string, len(cfg.PublicSettings.Steps))
+	for i, step := range cfg.PublicSettings.Steps {
+		stepDir := stepsDir(dir, i)
+		if err := os.MkdirAll(stepDir, 0700); err != nil {
+			return nil, errors.Wrapf(err, "failed to prepare directory of step '%s'", step.Name), constants.ExitCode_SaveScriptFailed
+		}
+
+		stepCfg := stepSettings(cfg, step)
+		if err := stepCfg.ValidateParameters(); err != nil {
+			ctx.Log("event", "invalid parameters of step", "step", step.Name, "error", err)
+			return nil, errors.Wrapf(err, "invalid parameters of step '%s'", step.Name), constants.ExitCode_InvalidParameters
+		}
+
+		if stepCfg.Script() != "" {
+			scriptFilePaths[i] = filepath.Join(stepDir, "script.sh")
+			if err, exitCode := saveInlineScript(ctx, scriptFilePaths[i], stepCfg); err != nil {
+				return nil, errors.Wrapf(err, "step '%s'", step.Name), exitCode
+			}
+		} else {
+			file, err := files.DownloadAndProcessScript(ctx, stepCfg.ScriptURI(), stepDir, stepCfg)
+			if err != nil {
+				ctx.Log("event", "failed to download script of step", "step", step.Name, "error", err)
+				return nil, errors.Wrapf(err, "failed to download script of step '%s'", step.Name), constants.ExitCode_ScriptBlobDownloadFailed
+			}
+			scriptFilePaths[i] = file
+		}
+
+		if stepCfg.PublicSettings.Templating {
+			if err := renderTemplate(ctx, scriptFilePaths[i], stepCfg); err != nil {
+				return nil, errors.Wrapf(err, "step '%s'", step.Name), constants.ExitCode_RenderTemplateFailed
+			}
+		}
+	}
+	return scriptFilePaths, nil, constants.ExitCode_Okay
+}
+
+// runSteps executes the steps of cfg in order, in dir, and reports the status of every step in report.
+// The output of every step is appended to the output of the run command. A failed step fails the run
+// command and skips the remaining steps, unless it is allowed to fail with continueOnError.
+func runSteps(ctx *log.Context, dir string, cfg *handlersettings.HandlerSettings, metadata types.RCMetadata, report *executionReport) (error, int) {
+	steps := cfg.PublicSettings.Steps
+	scriptFilePaths, err, exitCode := prepareSteps(ctx, dir, cfg)
+	if err != nil {
+		return err, exitCode
+	}
+	details := executionDetails(ctx, cfg, scriptFilePaths...)
+	report.update(func(view *types.RunCommandInstanceView) {
+		view.Details = details
+		view.Steps = make([]types.StepInstanceView, len(steps))
+		for i, step := range steps {
+			view.Steps[i] = types.StepInstanceView{Name: step.Name, ExecutionState: types.Pending}
+		}
+	})
+
+	deadline := timeoutDeadline(time.Now(), cfg.PublicSettings.TimeoutInSeconds)
+	stdoutFile, stderrFile := exec.LogPaths(dir)
+	for i, step := range steps {
+		stepCfg := stepSettings(cfg, step)
+
+		// The output of the step starts where the output of the previous steps ends
+		stdoutPosition, stderrPosition := fileSize(stdoutFile), fileSize(stderrFile)
+
+		begin := time.Now()
+		opts := exec.Options{
+			RunCommandName: metadata.ExtName,
+			SequenceNumber: metadata.SeqNum,
+			Deadline:       timeoutDeadline(begin, step.TimeoutInSeconds),
+		}
+		if opts.Deadline.IsZero() || (!deadline.IsZero() && deadline.Before(opts.Deadline)) {
+			opts.Deadline = deadline
+		}
+		if i > 0 {
+			opts.Append, opts.Marker = true, fmt.Sprintf("[... step %d: %s ...]", i+1, step.Name)
+			// The marker line separates the output of the steps, it is not part of the output of the step
+			stdoutPosition += int64(len(opts.Marker) + 1)
+			stderrPosition += int64(len(opts.Marker) + 1)
+		}
+
+		ctx.Log("event", "executing step", "step", step.Name)
+		report.update(func(view *types.RunCommandInstanceView) {
+			view.Steps[i].ExecutionState = types.Running
+			view.Steps[i].StartTime = begin.UTC().Format(time.RFC3339)
+		})
+		stepErr, stepExitCode := execWithRetries(ctx, scriptFilePaths[i], dir, stepCfg, opts, func(attempt int) {
+			if cfg.PublicSettings.Retry != nil {
+				report.update(func(view *types.RunCommandInstanceView) { view.Steps[i].Attempts = attempt })
+			}
+		})
+
+		stepOut, stepErrOut := stepOutput(ctx, stdoutFile, stdoutPosition), stepOutput(ctx, stderrFile, stderrPosition)
+		state := stepState(cfg, stepErr)
+		report.update(func(view *types.RunCommandInstanceView) {
+			view.Steps[i].EndTime = time.Now().UTC().Format(time.RFC3339)
+			view.Steps[i].ExitCode = stepExitCode
+			view.Steps[i].Output = stepOut
+			view.Steps[i].Error = stepErrOut
+			view.Steps[i].ExecutionState = state
+		})
+		telemetryResult("step", fmt.Sprintf("step %d of %d", i+1, len(steps)), state == types.Succeeded, time.Since(begin))
+
+		if state == types.Succeeded {
+			continue
+		}
+		ctx.Log("event", "step failed", "step", step.Name, "error", stepErr)
+		if step.ContinueOnError {
+			continue
+		}
+
+		report.update(func(view *types.RunCommandInstanceView) {
+			for j := i + 1; j < len(steps); j++ {
+				view.Steps[j].ExecutionState = types.Skipped
+			}
+		})
+		return errors.Wrapf(stepErr, "step '%s' failed", step.Name), stepExitCode
+	}
+	return nil, constants.ExitCode_Okay
+}
+
+// stepState returns the execution state of a step which completed with the specified error.
+func stepState(cfg *handlersettings.HandlerSettings, err error) types.ExecutionState {
+	if err == nil {
+		return types.Succeeded
+	}
+	var exitErr *exec.ScriptExitError
+	if !errors.As(err, &exitErr) {
+		return types.Failed
+	}
+	if exitErr.Signaled {
+		return types.TimedOut
+	}
+	if isSuccessExitCode(cfg, exitErr.ExitCode) {
+		return types.Succeeded
+	}
+	return types.Failed
+}
+
+// stepOutput returns the head and the tail of the output written to the file after the specified position.
+func stepOutput(ctx *log.Context, path string, position int64) string {
+	b, err := files.HeadAndTailFileFrom(path, position, stepOutputHeadBytes, stepOutputTailBytes)
+	if err != nil {
+		ctx.Log("message", "failed to read output of step", "file", path, "error", err)
+		return ""
+	}
+	return string(b)
+}
+
+// fileSize returns the size of the file, or 0 if it cannot be retrieved.
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
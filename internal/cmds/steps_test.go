@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func stepsTestSettings(steps ...handlersettings.StepSettings) *handlersettings.HandlerSettings {
+	return &handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{Steps: steps}}
+}
+
+// realExecutions executes the scripts of the steps, other tests replace the execution.
+func realExecutions(t *testing.T) {
+	originalExec := ExecCmdInDir
+	t.Cleanup(func() { ExecCmdInDir = originalExec })
+	ExecCmdInDir = exec.ExecCmdInDir
+}
+
+func inlineStep(name, script string) handlersettings.StepSettings {
+	return handlersettings.StepSettings{Name: name, Source: &handlersettings.ScriptSource{Script: script}}
+}
+
+func Test_runSteps_succeeds(t *testing.T) {
+	realExecutions(t)
+	dir := t.TempDir()
+	report := types.RunCommandInstanceView{}
+	cfg := stepsTestSettings(
+		inlineStep("first", "echo one; echo warn >&2; touch created"),
+		inlineStep("second", "test -f created && echo two"))
+
+	err, exitCode := runSteps(log.NewContext(log.NewNopLogger()), dir, cfg, types.RCMetadata{}, newExecutionReport(&report))
+	require.Nil(t, err)
+	require.Equal(t, 0, exitCode)
+	require.Equal(t, 2, len(report.Steps))
+
+	require.Equal(t, "first", report.Steps[0].Name)
+	require.EqualValues(t, types.Succeeded, report.Steps[0].ExecutionState)
+	require.Equal(t, "one\n", report.Steps[0].Output)
+	require.Equal(t, "warn\n", report.Steps[0].Error)
+	require.NotEmpty(t, report.Steps[0].StartTime)
+	require.NotEmpty(t, report.Steps[0].EndTime)
+
+	require.EqualValues(t, types.Succeeded, report.Steps[1].ExecutionState, "steps share the working directory")
+	require.Equal(t, "two\n", report.Steps[1].Output)
+	require.Equal(t, "", report.Steps[1].Error)
+
+	b, err := os.ReadFile(dir + "/stdout")
+	require.Nil(t, err)
+	require.Equal(t, "one\n[... step 2: second ...]\ntwo\n", string(b))
+
+	require.Equal(t, 2, len(report.Details.ScriptSHA256), "the script of every step is hashed")
+}
+
+func Test_runSteps_failureSkipsRemainingSteps(t *testing.T) {
+	realExecutions(t)
+	dir := t.TempDir()
+	report := types.RunCommandInstanceView{}
+	cfg := stepsTestSettings(
+		inlineStep("first", "exit 3"),
+		inlineStep("second", "echo two"))
+
+	err, exitCode := runSteps(log.NewContext(log.NewNopLogger()), dir, cfg, types.RCMetadata{}, newExecutionReport(&report))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "step 'first' failed")
+	require.Equal(t, 3, exitCode)
+	require.EqualValues(t, types.Failed, report.Steps[0].ExecutionState)
+	require.Equal(t, 3, report.Steps[0].ExitCode)
+	require.EqualValues(t, types.Skipped, report.Steps[1].ExecutionState)
+	require.Empty(t, report.Steps[1].StartTime)
+}
+
+func Test_runSteps_continueOnError(t *testing.T) {
+	realExecutions(t)
+	dir := t.TempDir()
+	report := types.RunCommandInstanceView{}
+	failing := inlineStep("first", "exit 3")
+	failing.ContinueOnError = true
+	cfg := stepsTestSettings(failing, inlineStep("second", "echo two"))
+
+	err, exitCode := runSteps(log.NewContext(log.NewNopLogger()), dir, cfg, types.RCMetadata{}, newExecutionReport(&report))
+	require.Nil(t, err)
+	require.Equal(t, 0, exitCode)
+	require.EqualValues(t, types.Failed, report.Steps[0].ExecutionState)
+	require.EqualValues(t, types.Succeeded, report.Steps[1].ExecutionState)
+}
+
+func Test_runSteps_successExitCode(t *testing.T) {
+	realExecutions(t)
+	dir := t.TempDir()
+	report := types.RunCommandInstanceView{}
+	cfg := stepsTestSettings(inlineStep("first", "exit 3"), inlineStep("second", "echo two"))
+	cfg.PublicSettings.SuccessExitCodes = []int{3}
+
+	err, _ := runSteps(log.NewContext(log.NewNopLogger()), dir, cfg, types.RCMetadata{}, newExecutionReport(&report))
+	require.Nil(t, err)
+	require.EqualValues(t, types.Succeeded, report.Steps[0].ExecutionState)
+	require.Equal(t, 3, report.Steps[0].ExitCode)
+	require.EqualValues(t, types.Succeeded, report.Steps[1].ExecutionState)
+}
+
+func Test_runSteps_stepTimeout(t *testing.T) {
+	realExecutions(t)
+	dir := t.TempDir()
+	report := types.RunCommandInstanceView{}
+	slow := inlineStep("slow", "sleep 30")
+	slow.TimeoutInSeconds = 1
+	cfg := stepsTestSettings(slow, inlineStep("second", "echo two"))
+
+	err, _ := runSteps(log.NewContext(log.NewNopLogger()), dir, cfg, types.RCMetadata{}, newExecutionReport(&report))
+	require.NotNil(t, err)
+	require.EqualValues(t, types.TimedOut, report.Steps[0].ExecutionState)
+	require.EqualValues(t, types.Skipped, report.Steps[1].ExecutionState)
+}
+
+func Test_stepSettings(t *testing.T) {
+	cfg := stepsTestSettings(inlineStep("first", "echo one"))
+	cfg.PublicSettings.TimeoutInSeconds = 60
+	cfg.PublicSettings.Parameters = []handlersettings.ParameterDefinition{{Name: "a", Value: "b"}}
+	cfg.ProtectedSettings.SourceSASToken = "sas"
+	step := cfg.PublicSettings.Steps[0]
+
+	stepCfg := stepSettings(cfg, step)
+	require.Nil(t, stepCfg.PublicSettings.Steps)
+	require.Equal(t, "echo one", stepCfg.Script())
+	require.Nil(t, stepCfg.PublicSettings.Parameters)
+	require.Equal(t, 0, stepCfg.PublicSettings.TimeoutInSeconds)
+	require.Equal(t, "", stepCfg.ScriptSAS())
+	require.Equal(t, "sas", cfg.ScriptSAS(), "settings of the run command are unchanged")
+	require.Equal(t, 1, len(cfg.PublicSettings.Steps))
+}
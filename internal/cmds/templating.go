@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"path/filepath"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/templating"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// maxTemplateSize is the maximum size of a script or an artifact rendered as a template
+const maxTemplateSize = 1024 * 1024
+
+// renderError is returned when a script or an artifact cannot be rendered, which is a user error.
+type renderError struct {
+	err error
+}
+
+func (e *renderError) Error() string { return e.err.Error() }
+func (e *renderError) Cause() error  { return e.err }
+func (e *renderError) Unwrap() error { return e.err }
+
+// renderTemplate renders the file at path with the parameters of cfg. The rendered content may
+// contain the values of protected parameters, so neither it nor the values are logged.
+func renderTemplate(ctx *log.Context, path string, cfg *handlersettings.HandlerSettings) error {
+	data, err := templating.NewData(cfg)
+	if err != nil {
+		return &renderError{errors.Wrap(err, "invalid parameters")}
+	}
+
+	name := filepath.Base(path)
+	if err := templating.RenderFile(path, name, data, maxTemplateSize); err != nil {
+		ctx.Log("event", "failed to render template", "file", name, "error", err)
+		return &renderError{errors.Wrapf(err, "failed to render '%s'", name)}
+	}
+	ctx.Log("event", "rendered template", "file", name)
+	return nil
+}
@@ -0,0 +1,80 @@
+package commandProcessor
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/audit"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+)
+
+// Source types of the audit records
+const (
+	auditSourceScript    = "script"
+	auditSourceScriptURI = "scriptUri"
+	auditSourceSteps     = "steps"
+)
+
+// auditExecution appends the completed execution to the audit log. A failure to audit the execution is logged, it
+// does not fail the command.
+func auditExecution(ctx *log.Context, cfg handlersettings.HandlerSettings, cfgErr error, metadata types.RCMetadata, instView *types.RunCommandInstanceView) {
+	if err := audit.Append(DataDir, newAuditRecord(cfg, cfgErr, metadata, instView)); err != nil {
+		ctx.Log("message", "failed to append the execution to the audit log", "error", err)
+	}
+}
+
+// newAuditRecord describes the execution. Only the hosts of the script URIs and the names of the parameters are
+// recorded, never the SAS tokens or the values.
+func newAuditRecord(cfg handlersettings.HandlerSettings, cfgErr error, metadata types.RCMetadata, instView *types.RunCommandInstanceView) audit.Record {
+	record := audit.Record{
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		ExtensionName: metadata.ExtName,
+		SeqNum:        metadata.SeqNum,
+		State:         instView.ExecutionState,
+		ExitCode:      instView.ExitCode,
+	}
+	if details := instView.Details; details != nil {
+		record.User = details.User
+		record.ScriptSHA256 = details.ScriptSHA256
+	}
+	if cfgErr != nil {
+		return record
+	}
+
+	addHost := func(uri string) {
+		if u, err := url.Parse(uri); err == nil && u.Host != "" {
+			record.URIHosts = append(record.URIHosts, u.Host)
+		}
+	}
+	addNames := func(parameters []handlersettings.ParameterDefinition) {
+		for _, p := range parameters {
+			if p.Name != "" {
+				record.ParameterNames = append(record.ParameterNames, p.Name)
+			}
+		}
+	}
+
+	switch {
+	case len(cfg.PublicSettings.Steps) > 0:
+		record.SourceType = auditSourceSteps
+		for _, step := range cfg.PublicSettings.Steps {
+			if step.Source != nil && step.Source.ScriptURI != "" {
+				addHost(step.Source.ScriptURI)
+			}
+			addNames(step.Parameters)
+		}
+	case cfg.ScriptURI() != "":
+		record.SourceType = auditSourceScriptURI
+		addHost(cfg.ScriptURI())
+	default:
+		record.SourceType = auditSourceScript
+	}
+	addNames(cfg.PublicSettings.Parameters)
+	addNames(cfg.ProtectedSettings.ProtectedParameters)
+	if record.User == "" {
+		record.User = cfg.PublicSettings.RunAsUser
+	}
+	return record
+}
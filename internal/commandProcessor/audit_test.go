@@ -0,0 +1,53 @@
+package commandProcessor
+
+import (
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newAuditRecord(t *testing.T) {
+	metadata := types.RCMetadata{ExtName: "name", SeqNum: 4}
+	instView := &types.RunCommandInstanceView{ExecutionState: types.Failed, ExitCode: 3}
+
+	var cfg handlersettings.HandlerSettings
+	cfg.PublicSettings.Source = &handlersettings.ScriptSource{ScriptURI: "https://account.blob.core.windows.net/c/script.sh?sig=secret"}
+	cfg.PublicSettings.Parameters = []handlersettings.ParameterDefinition{{Name: "a", Value: "1"}, {Value: "positional"}}
+	cfg.PublicSettings.RunAsUser = "user"
+	cfg.ProtectedSettings.ProtectedParameters = []handlersettings.ParameterDefinition{{Name: "password", Value: "secret"}}
+	cfg.ProtectedSettings.SourceSASToken = "sig=secret"
+
+	record := newAuditRecord(cfg, nil, metadata, instView)
+	require.Equal(t, "name", record.ExtensionName)
+	require.Equal(t, 4, record.SeqNum)
+	require.Equal(t, "scriptUri", record.SourceType)
+	require.Equal(t, []string{"account.blob.core.windows.net"}, record.URIHosts, "never the SAS")
+	require.Equal(t, []string{"a", "password"}, record.ParameterNames, "never the values")
+	require.Equal(t, "user", record.User)
+	require.EqualValues(t, types.Failed, record.State)
+	require.Equal(t, 3, record.ExitCode)
+
+	// The details of the execution take precedence over the settings
+	instView.Details = &types.ExecutionDetails{User: "root", ScriptSHA256: []string{"abc"}}
+	record = newAuditRecord(cfg, nil, metadata, instView)
+	require.Equal(t, "root", record.User)
+	require.Equal(t, []string{"abc"}, record.ScriptSHA256)
+
+	cfg.PublicSettings.Source = nil
+	cfg.PublicSettings.Steps = []handlersettings.StepSettings{
+		{Name: "one", Source: &handlersettings.ScriptSource{ScriptURI: "https://example.com/one.sh"}, Parameters: []handlersettings.ParameterDefinition{{Name: "b"}}},
+		{Name: "two", Source: &handlersettings.ScriptSource{Script: "date"}},
+	}
+	record = newAuditRecord(cfg, nil, metadata, instView)
+	require.Equal(t, "steps", record.SourceType)
+	require.Equal(t, []string{"example.com"}, record.URIHosts)
+	require.Equal(t, []string{"b", "a", "password"}, record.ParameterNames)
+
+	// The settings are not used when they could not be read
+	record = newAuditRecord(cfg, errors.New("invalid settings"), metadata, instView)
+	require.Empty(t, record.SourceType)
+	require.Nil(t, record.ParameterNames)
+}
@@ -3,7 +3,9 @@ package commandProcessor
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -22,10 +24,13 @@ import (
 
 var (
 	handlerEnvironmentGetter func(name, version string) (he *handlerenv.HandlerEnvironment, _ error) = handlerenv.GetHandlerEnvironment
+
+	// DataDir is where the handler stores the downloaded files and the output of the scripts
+	DataDir = constants.DataDir
 )
 
 func ProcessImmediateHandlerCommand(cmd types.Cmd, hs handlersettings.HandlerSettingsFile, extensionName string, seqNum int) error {
-	ctx := initializeLogger(cmd)
+	ctx := initializeLogger(cmd, os.Stdout)
 	ctx = ctx.With("extensionName", extensionName)
 	ctx.Log("event", "start")
 	ctx.Log("message", "processing immediate command")
@@ -50,7 +55,7 @@ func ProcessImmediateHandlerCommand(cmd types.Cmd, hs handlersettings.HandlerSet
 }
 
 func ProcessHandlerCommand(cmd types.Cmd) error {
-	ctx := initializeLogger(cmd)
+	ctx := initializeLogger(cmd, os.Stdout)
 	ctx.Log("event", "start")
 	ctx.Log("message", "processing command")
 
@@ -68,6 +73,22 @@ func ProcessHandlerCommand(cmd types.Cmd) error {
 	return ProcessHandlerCommandWithDetails(ctx, cmd, hEnv, extensionName, seqNum, constants.DownloadFolder)
 }
 
+// ProcessLocalHandlerCommand processes the command in the specified handler environment instead of the one
+// created by the agent, e.g. for the run-local tool. The log of the handler is written to logWriter.
+func ProcessLocalHandlerCommand(cmd types.Cmd, hEnv types.HandlerEnvironment, extensionName string, seqNum int, logWriter io.Writer) error {
+	ctx := initializeLogger(cmd, logWriter)
+	ctx = ctx.With("extensionName", extensionName).With("seq", seqNum)
+	ctx.Log("event", "start")
+	ctx.Log("message", "processing local command")
+
+	err := executePreSteps(ctx, cmd, hEnv, extensionName, seqNum, constants.DownloadFolder)
+	if err != nil {
+		return errors.Wrap(err, "failed on pre steps")
+	}
+
+	return ProcessHandlerCommandWithDetails(ctx, cmd, hEnv, extensionName, seqNum, constants.DownloadFolder)
+}
+
 func ProcessHandlerCommandWithDetails(ctx *log.Context, cmd types.Cmd, hEnv types.HandlerEnvironment, extensionName string, seqNum int, downloadFolder string) error {
 	ctx.Log("message", fmt.Sprintf("processing command for extensionName: %v and seqNum: %v", extensionName, seqNum))
 	instView := types.RunCommandInstanceView{
@@ -80,7 +101,7 @@ func ProcessHandlerCommandWithDetails(ctx *log.Context, cmd types.Cmd, hEnv type
 		EndTime:          "",
 	}
 
-	metadata := types.NewRCMetadata(extensionName, seqNum, downloadFolder, constants.DataDir)
+	metadata := types.NewRCMetadata(extensionName, seqNum, downloadFolder, DataDir)
 	instanceview.ReportInstanceView(ctx, hEnv, metadata, types.StatusTransitioning, cmd, &instView)
 
 	// execute the subcommand
@@ -88,29 +109,58 @@ func ProcessHandlerCommandWithDetails(ctx *log.Context, cmd types.Cmd, hEnv type
 
 	instView.Output = stdout
 	instView.Error = stderr
+	successExitCode := constants.ExitCode_Okay
+
+	// The success exit codes and the failure detection rules of the settings decide the result of the script
+	var cfg handlersettings.HandlerSettings
+	var cfgErr error
+	if cmdInvokeError != nil || cmd.Name == types.CmdEnableTemplate.Name {
+		cfg, cfgErr = handlersettings.GetHandlerSettings(hEnv.HandlerEnvironment.ConfigFolder, extensionName, seqNum, ctx)
+		if cfgErr == nil && cmd.Name == types.CmdEnableTemplate.Name && !cfg.PublicSettings.DryRun {
+			outputDir := filepath.Join(metadata.DownloadPath, strconv.Itoa(seqNum))
+			outcome := evaluateExecution(ctx, &cfg, outputDir, cmdInvokeError, exitCode, stdout, stderr)
+			cmdInvokeError, exitCode, instView.MatchedRule = outcome.err, outcome.exitCode, outcome.matchedRule
+			successExitCode = exitCode
+		}
+	}
+
 	if cmdInvokeError != nil {
 		ctx.Log("event", "failed to handle", "error", cmdInvokeError)
 		instView.ExecutionMessage = "Execution failed: " + cmdInvokeError.Error()
 		instView.ExecutionState = types.Failed
 		instView.EndTime = time.Now().UTC().Format(time.RFC3339)
 		instView.ExitCode = exitCode
+		reportFailure(ctx, cmd.Name, cmdInvokeError, exitCode, &instView)
 		statusToReport := types.StatusSuccess
 
 		// If TreatFailureAsDeploymentFailure is set to true and the exit code is non-zero, set extension status to error
-		cfg, err := handlersettings.GetHandlerSettings(hEnv.HandlerEnvironment.ConfigFolder, extensionName, seqNum, ctx)
-		if err == nil && cfg.PublicSettings.TreatFailureAsDeploymentFailure && cmd.FailExitCode != 0 {
+		if cfgErr == nil && cfg.PublicSettings.TreatFailureAsDeploymentFailure && cmd.FailExitCode != 0 {
 			statusToReport = types.StatusError
 		}
 
+		if cmd.Name == types.CmdEnableTemplate.Name {
+			recordExecution(ctx, cfg, cfgErr, metadata, &instView)
+			auditExecution(ctx, cfg, cfgErr, metadata, &instView)
+		}
 		instanceview.ReportInstanceView(ctx, hEnv, metadata, statusToReport, cmd, &instView)
-		return errors.Wrapf(err, "command execution failed")
+		return errors.Wrapf(cfgErr, "command execution failed")
+	} else if cfgErr == nil && cmd.Name == types.CmdEnableTemplate.Name && cfg.PublicSettings.DryRun {
+		// Nothing was executed, the plan of the execution is in the instance view
+		instView.ExecutionMessage = "Validation completed"
+		instView.ExecutionState = types.Validated
+		instView.EndTime = time.Now().UTC().Format(time.RFC3339)
+		instView.ExitCode = successExitCode
 	} else { // No error. Succeeded
 		instView.ExecutionMessage = "Execution completed"
 		instView.ExecutionState = types.Succeeded
 		instView.EndTime = time.Now().UTC().Format(time.RFC3339)
-		instView.ExitCode = constants.ExitCode_Okay
+		instView.ExitCode = successExitCode
 	}
 
+	if cmd.Name == types.CmdEnableTemplate.Name {
+		recordExecution(ctx, cfg, cfgErr, metadata, &instView)
+		auditExecution(ctx, cfg, cfgErr, metadata, &instView)
+	}
 	instanceview.ReportInstanceView(ctx, hEnv, metadata, types.StatusSuccess, cmd, &instView)
 	ctx.Log("event", "end")
 
@@ -139,7 +189,7 @@ func executePreSteps(ctx *log.Context, cmd types.Cmd, hEnv types.HandlerEnvironm
 	// check sub-command preconditions, if any, before executing
 	if cmd.Functions.Pre != nil {
 		ctx.Log("event", "pre-check")
-		metadata := types.NewRCMetadata(extensionName, seqNum, downloadFolder, constants.DataDir)
+		metadata := types.NewRCMetadata(extensionName, seqNum, downloadFolder, DataDir)
 		if err := cmd.Functions.Pre(ctx, hEnv, metadata, cmd); err != nil {
 			ctx.Log("event", "pre-check failed", "error", err)
 			return errors.Wrapf(err, "pre-check step failed")
@@ -149,10 +199,10 @@ func executePreSteps(ctx *log.Context, cmd types.Cmd, hEnv types.HandlerEnvironm
 	return nil
 }
 
-func initializeLogger(cmd types.Cmd) *log.Context {
+func initializeLogger(cmd types.Cmd, w io.Writer) *log.Context {
 	logging.New(nil)
 	ctx := log.NewContext(log.NewSyncLogger(log.NewLogfmtLogger(
-		os.Stdout))).With("time", log.DefaultTimestamp).With("version", versionutil.VersionString())
+		w))).With("time", log.DefaultTimestamp).With("version", versionutil.VersionString())
 	ctx = ctx.With("operation", strings.ToLower(cmd.Name))
 	return ctx
 }
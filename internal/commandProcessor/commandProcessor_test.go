@@ -31,12 +31,12 @@ func enablePreThrowError(ctx *log.Context, h types.HandlerEnvironment, metadata
 
 func Test_InitializeLogger(t *testing.T) {
 	cmd := types.CmdEnableTemplate.InitializeFunctions(types.CmdFunctions{Invoke: nil, Pre: nil, ReportStatus: status.ReportStatusToLocalFile, Cleanup: cleanup.RunCommandCleanup})
-	initializeLogger(cmd)
+	initializeLogger(cmd, os.Stdout)
 }
 
 func Test_ExecutePreStepsNilPreFunction(t *testing.T) {
 	cmd := types.CmdEnableTemplate.InitializeFunctions(types.CmdFunctions{Invoke: nil, Pre: nil, ReportStatus: status.ReportStatusToLocalFile, Cleanup: cleanup.RunCommandCleanup})
-	ctx := initializeLogger(cmd)
+	ctx := initializeLogger(cmd, os.Stdout)
 	extName, seqNum := "testExtension", 5
 	fakeEnv := types.HandlerEnvironment{}
 
@@ -46,7 +46,7 @@ func Test_ExecutePreStepsNilPreFunction(t *testing.T) {
 
 func Test_ExecutePreSteps(t *testing.T) {
 	cmd := types.CmdEnableTemplate.InitializeFunctions(types.CmdFunctions{Invoke: nil, Pre: enablePreSuccess, ReportStatus: status.ReportStatusToLocalFile, Cleanup: cleanup.RunCommandCleanup})
-	ctx := initializeLogger(cmd)
+	ctx := initializeLogger(cmd, os.Stdout)
 	extName, seqNum := "testExtension", 5
 	fakeEnv := types.HandlerEnvironment{}
 
@@ -56,7 +56,7 @@ func Test_ExecutePreSteps(t *testing.T) {
 
 func Test_ExecutePreStepsAndFailed(t *testing.T) {
 	cmd := types.CmdEnableTemplate.InitializeFunctions(types.CmdFunctions{Invoke: nil, Pre: enablePreThrowError, ReportStatus: status.ReportStatusToLocalFile, Cleanup: cleanup.RunCommandCleanup})
-	ctx := initializeLogger(cmd)
+	ctx := initializeLogger(cmd, os.Stdout)
 	extName, seqNum := "testExtension", 5
 	fakeEnv := types.HandlerEnvironment{}
 
@@ -0,0 +1,47 @@
+package commandProcessor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/errorcatalog"
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/telemetry"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/Azure/run-command-handler-linux/pkg/versionutil"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// telemetryError sends the failures of the commands, overridden by the tests
+var telemetryError = telemetry.SendErrorTelemetry(telemetry.NewTelemetryEventSender(), constants.TelemetryExtensionName, versionutil.Version)
+
+// reportFailure sets the code and the category of the failure in the instance view and sends them to the
+// telemetry. The message of the error is not sent, it can contain the URIs and the output of the script.
+func reportFailure(ctx *log.Context, cmdName string, err error, exitCode int, instView *types.RunCommandInstanceView) {
+	failure := classifyFailure(err, exitCode)
+	instView.ErrorCode = failure.Code
+	instView.ErrorCategory = string(failure.Category)
+
+	var duration time.Duration
+	if start, parseErr := time.Parse(time.RFC3339, instView.StartTime); parseErr == nil {
+		duration = time.Since(start)
+	}
+	if telemetryErr := telemetryError(cmdName, fmt.Sprintf("exitCode=%d", exitCode), failure.Code, string(failure.Category), duration); telemetryErr != nil {
+		ctx.Log("message", "failed to send the failure to the telemetry", "error", telemetryErr)
+	}
+}
+
+// classifyFailure returns the failure of the catalog of a failed command. The script failed when it exited with a
+// non-zero exit code, a rule of the settings which failed a script exiting with 0 is reported with its own exit code.
+func classifyFailure(err error, exitCode int) errorcatalog.Entry {
+	var exitErr *exec.ScriptExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.Signaled {
+			return errorcatalog.ScriptTerminated
+		}
+		return errorcatalog.ScriptFailed
+	}
+	return errorcatalog.Classify(err, exitCode)
+}
@@ -0,0 +1,39 @@
+package commandProcessor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/errorcatalog"
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_classifyFailure(t *testing.T) {
+	exitErr := errors.Wrap(&exec.ScriptExitError{ExitCode: 2}, "failed to execute command")
+	require.Equal(t, errorcatalog.ScriptFailed, classifyFailure(exitErr, 2))
+	require.Equal(t, errorcatalog.ScriptTerminated, classifyFailure(&exec.ScriptExitError{ExitCode: -1, Signaled: true}, -1))
+	require.Equal(t, errorcatalog.OutputRuleFailed, classifyFailure(errors.New("output rule 'fatal' matched"), constants.ExitCode_OutputRuleFailed))
+	require.Equal(t, errorcatalog.ScriptDownloadFailed, classifyFailure(errors.New("404"), constants.ExitCode_ScriptBlobDownloadFailed))
+}
+
+func Test_reportFailure(t *testing.T) {
+	defer func(old func(string, string, string, string, time.Duration) error) { telemetryError = old }(telemetryError)
+	var sent []string
+	telemetryError = func(operation, message, errorCode, errorCategory string, _ time.Duration) error {
+		sent = []string{operation, message, errorCode, errorCategory}
+		return nil
+	}
+
+	instView := types.RunCommandInstanceView{StartTime: time.Now().UTC().Format(time.RFC3339)}
+	err := errorcatalog.RunAsUserNotFound.Wrap(errors.New("unknown user"), "Failed to lookup RunAs user 'alice'")
+	reportFailure(log.NewContext(log.NewNopLogger()), "enable", err, constants.ExitCode_RunAsLookupUserFailed, &instView)
+
+	require.Equal(t, "RunAsUserNotFound", instView.ErrorCode)
+	require.Equal(t, "config", instView.ErrorCategory)
+	require.Equal(t, []string{"enable", "exitCode=-102", "RunAsUserNotFound", "config"}, sent, "the message of the error is not sent")
+}
@@ -0,0 +1,36 @@
+package commandProcessor
+
+import (
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/internal/history"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+)
+
+// recordExecution appends the completed execution to the history of the VM, with the retention of the settings
+// if they could be read. A failure to record the execution is logged, it does not fail the command.
+func recordExecution(ctx *log.Context, cfg handlersettings.HandlerSettings, cfgErr error, metadata types.RCMetadata, instView *types.RunCommandInstanceView) {
+	retention := history.NewRetention(0, 0, 0)
+	if r := cfg.PublicSettings.History; cfgErr == nil && r != nil {
+		retention = history.NewRetention(r.MaxRecords, r.MaxAgeInDays, r.MaxSizeInKB)
+	}
+
+	record := history.Record{
+		Name:      metadata.ExtName,
+		SeqNum:    metadata.SeqNum,
+		StartTime: instView.StartTime,
+		EndTime:   instView.EndTime,
+		State:     instView.ExecutionState,
+		ExitCode:  instView.ExitCode,
+	}
+	if details := instView.Details; details != nil {
+		record.User = details.User
+		record.ScriptSHA256 = details.ScriptSHA256
+		record.OutputSHA256 = details.OutputSHA256
+		record.ErrorSHA256 = details.ErrorSHA256
+	}
+
+	if err := history.Append(DataDir, record, retention); err != nil {
+		ctx.Log("message", "failed to record the execution in the history", "error", err)
+	}
+}
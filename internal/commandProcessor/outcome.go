@@ -0,0 +1,160 @@
+package commandProcessor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	matchedSuccessExitCodes = "successExitCodes"
+	matchedFailOnStderr     = "failOnStderr"
+
+	// maxOutputLineSize is the longest line of the output the rules are matched against, the rest of a longer
+	// line is not matched
+	maxOutputLineSize = 64 * 1024
+)
+
+// executionOutcome is the result of the execution once the success exit codes and the failure
+// detection rules of the settings are applied.
+type executionOutcome struct {
+	err         error  // nil if the execution succeeded
+	exitCode    int    // exit code to report
+	matchedRule string // name of the rule which decided the result, if any
+}
+
+// evaluateExecution applies the success exit codes and the failure detection rules to the result
+// of the script. Failures which did not come from the script itself (e.g. a failed download or a
+// timeout) are returned unchanged. stdout and stderr are the output captured for the instance view,
+// the rules are matched against the full output in outputDir when it is still available. When a rule
+// fails a script which exited with 0, the exit code to report is ExitCode_OutputRuleFailed.
+func evaluateExecution(ctx *log.Context, cfg *handlersettings.HandlerSettings, outputDir string, invokeErr error, exitCode int, stdout, stderr string) executionOutcome {
+	outcome := executionOutcome{err: invokeErr, exitCode: exitCode}
+	if invokeErr != nil {
+		var exitErr *exec.ScriptExitError
+		if !errors.As(invokeErr, &exitErr) || exitErr.Signaled {
+			return outcome
+		}
+	}
+
+	stdoutFile, stderrFile := exec.LogPaths(outputDir)
+	for _, rule := range cfg.PublicSettings.OutputRules {
+		matched, err := matchOutputRule(rule, stdoutFile, stderrFile, stdout, stderr)
+		if err != nil {
+			ctx.Log("message", "failed to evaluate output rule", "rule", rule.Name, "error", err)
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		ctx.Log("message", fmt.Sprintf("output rule '%s' matched, execution %s", rule.Name, rule.Result))
+		outcome.matchedRule = rule.Name
+		if rule.Result == handlersettings.OutputRuleResultSucceeded {
+			outcome.err = nil
+		} else if invokeErr == nil {
+			outcome.err = fmt.Errorf("output rule '%s' matched", rule.Name)
+			outcome.exitCode = constants.ExitCode_OutputRuleFailed
+		}
+		return outcome
+	}
+
+	if cfg.PublicSettings.FailOnStderr && strings.TrimSpace(stderr) != "" {
+		ctx.Log("message", "script wrote to stderr, execution Failed")
+		outcome.matchedRule = matchedFailOnStderr
+		if invokeErr == nil {
+			outcome.err = errors.New("script wrote to stderr")
+			outcome.exitCode = constants.ExitCode_OutputRuleFailed
+		}
+		return outcome
+	}
+
+	if invokeErr != nil && exitCode != constants.ExitCode_Okay {
+		for _, code := range cfg.PublicSettings.SuccessExitCodes {
+			if code == exitCode {
+				ctx.Log("message", fmt.Sprintf("exit code %d is a success exit code", exitCode))
+				outcome.err = nil
+				outcome.matchedRule = matchedSuccessExitCodes
+				return outcome
+			}
+		}
+	}
+	return outcome
+}
+
+// matchOutputRule returns true if any line of the streams the rule applies to matches its pattern.
+func matchOutputRule(rule handlersettings.OutputRule, stdoutFile, stderrFile, stdout, stderr string) (bool, error) {
+	pattern, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return false, err
+	}
+
+	if rule.Stream != handlersettings.OutputRuleStreamStderr {
+		if matched, err := matchLines(pattern, stdoutFile, stdout); matched || err != nil {
+			return matched, err
+		}
+	}
+	if rule.Stream != handlersettings.OutputRuleStreamStdout {
+		return matchLines(pattern, stderrFile, stderr)
+	}
+	return false, nil
+}
+
+// matchLines matches the pattern against every line of the file, or of the captured output if the file does not exist.
+// Only the first maxOutputLineSize bytes of a longer line are matched.
+func matchLines(pattern *regexp.Regexp, path string, captured string) (bool, error) {
+	var r io.Reader = strings.NewReader(captured)
+	f, err := os.Open(path)
+	if err == nil {
+		defer f.Close()
+		r = f
+	} else if !os.IsNotExist(err) {
+		return false, errors.Wrap(err, "failed to open output file")
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxOutputLineSize)
+	scanner.Split(scanLinesTruncated())
+	for scanner.Scan() {
+		if pattern.Match(scanner.Bytes()) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, errors.Wrap(err, "failed to read output")
+	}
+	return false, nil
+}
+
+// scanLinesTruncated returns a split function which splits the output in lines like bufio.ScanLines. A line
+// longer than maxOutputLineSize is returned truncated and the rest of it is skipped.
+func scanLinesTruncated() bufio.SplitFunc {
+	skipping := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if skipping {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				skipping = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if advance > 0 || token != nil || err != nil || len(data) < maxOutputLineSize {
+			return advance, token, err
+		}
+		// the buffer is full without a complete line
+		skipping = true
+		return len(data), data[:maxOutputLineSize], nil
+	}
+}
@@ -0,0 +1,134 @@
+package commandProcessor
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/exec"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var outcomeTestContext = log.NewContext(log.NewNopLogger())
+
+func scriptExitError(exitCode int) error {
+	return errors.Wrap(&exec.ScriptExitError{ExitCode: exitCode}, "failed to execute command")
+}
+
+func Test_evaluateExecution_noRules(t *testing.T) {
+	cfg := handlersettings.HandlerSettings{}
+
+	outcome := evaluateExecution(outcomeTestContext, &cfg, "/non/existing", nil, 0, "out", "err")
+	require.Nil(t, outcome.err)
+	require.Equal(t, "", outcome.matchedRule)
+
+	err := scriptExitError(3)
+	outcome = evaluateExecution(outcomeTestContext, &cfg, "/non/existing", err, 3, "out", "err")
+	require.Equal(t, err, outcome.err)
+	require.Equal(t, 3, outcome.exitCode)
+}
+
+func Test_evaluateExecution_successExitCodes(t *testing.T) {
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{SuccessExitCodes: []int{3010}}}
+
+	outcome := evaluateExecution(outcomeTestContext, &cfg, "/non/existing", scriptExitError(3010), 3010, "", "")
+	require.Nil(t, outcome.err)
+	require.Equal(t, 3010, outcome.exitCode)
+	require.Equal(t, "successExitCodes", outcome.matchedRule)
+
+	outcome = evaluateExecution(outcomeTestContext, &cfg, "/non/existing", scriptExitError(1), 1, "", "")
+	require.NotNil(t, outcome.err)
+
+	// failures which do not come from the script are not changed
+	downloadErr := errors.New("failed to download script")
+	outcome = evaluateExecution(outcomeTestContext, &cfg, "/non/existing", downloadErr, 3010, "", "")
+	require.Equal(t, downloadErr, outcome.err)
+
+	// neither are timeouts
+	timeoutErr := &exec.ScriptExitError{ExitCode: -1, Signaled: true}
+	cfg.PublicSettings.SuccessExitCodes = []int{-1}
+	outcome = evaluateExecution(outcomeTestContext, &cfg, "/non/existing", timeoutErr, -1, "", "")
+	require.Equal(t, timeoutErr, outcome.err)
+}
+
+func Test_evaluateExecution_failOnStderr(t *testing.T) {
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{FailOnStderr: true}}
+
+	outcome := evaluateExecution(outcomeTestContext, &cfg, "/non/existing", nil, 0, "out", " \n")
+	require.Nil(t, outcome.err)
+
+	outcome = evaluateExecution(outcomeTestContext, &cfg, "/non/existing", nil, 0, "out", "warning: deprecated\n")
+	require.EqualError(t, outcome.err, "script wrote to stderr")
+	require.Equal(t, "failOnStderr", outcome.matchedRule)
+	require.Equal(t, constants.ExitCode_OutputRuleFailed, outcome.exitCode, "the script exited with 0")
+
+	outcome = evaluateExecution(outcomeTestContext, &cfg, "/non/existing", scriptExitError(2), 2, "out", "warning: deprecated\n")
+	require.Equal(t, 2, outcome.exitCode, "the exit code of a failed script is kept")
+}
+
+func Test_evaluateExecution_outputRules(t *testing.T) {
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		FailOnStderr: true,
+		OutputRules: []handlersettings.OutputRule{
+			{Name: "already-installed", Stream: "stderr", Pattern: `^E: .* is already the newest version`, Result: "Succeeded"},
+			{Name: "errors-in-log", Pattern: `(?i)^error:`, Result: "Failed"},
+		},
+	}}
+
+	// the first matching rule applies and takes precedence over failOnStderr and the exit code
+	outcome := evaluateExecution(outcomeTestContext, &cfg, "/non/existing", scriptExitError(100), 100, "", "E: curl is already the newest version\n")
+	require.Nil(t, outcome.err)
+	require.Equal(t, "already-installed", outcome.matchedRule)
+	require.Equal(t, 100, outcome.exitCode)
+
+	outcome = evaluateExecution(outcomeTestContext, &cfg, "/non/existing", nil, 0, "starting\nERROR: disk full\ndone\n", "")
+	require.EqualError(t, outcome.err, "output rule 'errors-in-log' matched")
+	require.Equal(t, "errors-in-log", outcome.matchedRule)
+	require.Equal(t, constants.ExitCode_OutputRuleFailed, outcome.exitCode)
+
+	// rules are matched line by line, and only against their stream
+	outcome = evaluateExecution(outcomeTestContext, &cfg, "/non/existing", nil, 0, "no E: curl is already the newest version", "")
+	require.Nil(t, outcome.err)
+	require.Equal(t, "", outcome.matchedRule)
+}
+
+func Test_evaluateExecution_matchesFullOutput(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	stdoutFile, _ := exec.LogPaths(dir)
+	require.Nil(t, os.WriteFile(stdoutFile, []byte("step 1\nFATAL: could not connect\nstep 3\n"), 0600))
+
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		OutputRules: []handlersettings.OutputRule{{Name: "fatal", Stream: "stdout", Pattern: `^FATAL:`, Result: "Failed"}},
+	}}
+
+	// the captured output was truncated, the full output still matches
+	outcome := evaluateExecution(outcomeTestContext, &cfg, dir, nil, 0, "step 1\n[... 27 bytes truncated ...]\nstep 3\n", "")
+	require.EqualError(t, outcome.err, "output rule 'fatal' matched")
+}
+
+func Test_matchLines_longLines(t *testing.T) {
+	pattern := regexp.MustCompile(`^FATAL:|^done$`)
+	long := strings.Repeat("x", 3*maxOutputLineSize)
+
+	// the rest of a long line is skipped, the next lines are still matched
+	matched, err := matchLines(pattern, "/non/existing", long+"FATAL: in the long line\ndone\n")
+	require.Nil(t, err)
+	require.True(t, matched)
+
+	matched, err = matchLines(pattern, "/non/existing", long+"FATAL: in the long line\nnot done")
+	require.Nil(t, err)
+	require.False(t, matched)
+
+	// the start of a long line is matched
+	matched, err = matchLines(pattern, "/non/existing", "FATAL: "+long)
+	require.Nil(t, err)
+	require.True(t, matched)
+}
@@ -38,6 +38,9 @@ const (
 	// The output directory for logs of immediate run command
 	ImmediateRCOutputDirectory = "/var/log/azure/run-command-handler/ImmediateRunCommandService.log"
 
+	// Default directory where the localFile output sink writes the script output
+	OutputLogDirectory = "/var/log/azure/run-command-handler/output"
+
 	// This is the directory where we place all the .mrseq files
 	WorkingDirectory = "../run-command-handler/workdir"
 
@@ -51,6 +54,9 @@ const (
 	RunCommandExtensionName     = "Microsoft.CPlat.Core.RunCommandHandlerLinux"
 	RunCommandTestExtensionName = "Microsoft.Azure.Extensions.Edp.RunCommandHandlerLinuxTest"
 
+	// Name of the run command extension in the telemetry
+	TelemetryExtensionName = "Microsoft.Compute.CPlat.Core.RunCommandLinux"
+
 	// The current version of the extension. This value is provided by the agent for all commands.
 	// See more in: https://github.com/Azure/azure-vmextension-publishing/wiki/2.0-Partner-Guide-Handler-Design-Details#236-summary
 	VersionEnvName = "VERSION"
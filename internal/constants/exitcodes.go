@@ -8,6 +8,15 @@ const (
 	ExitCode_ScriptBlobDownloadFailed  = -100
 	ExitCode_BlobCreateOrReplaceFailed = -101
 	ExitCode_RunAsLookupUserFailed     = -102
+	ExitCode_OutputSinkCreateFailed    = -103
+	ExitCode_WaitForLockTimedOut       = -104
+	ExitCode_WaitForDependencyTimedOut = -105
+	ExitCode_DecodeScriptFailed        = -106
+	ExitCode_InvalidParameters         = -107
+	ExitCode_RenderTemplateFailed      = -108
+	ExitCode_SyntaxCheckFailed         = -109
+	ExitCode_PreflightFailed           = -110
+	ExitCode_OutputRuleFailed          = -111
 
 	// Service Errors (-200s):
 	ExitCode_CreateDataDirectoryFailed                    = -200
@@ -35,6 +44,9 @@ const (
 	ExitCode_ImmediateTaskTimeout                         = -222
 	ExitCode_ImmediateTaskFailed                          = -223
 	ExitCode_CouldNotRehydrateMrSeq                       = -224
+	ExitCode_CreateScriptFilesFailed                      = -225
+	ExitCode_AcquireLockFailed                            = -226
+	ExitCode_WaitForDependencyFailed                      = -227
 
 	// Unknown errors (-300s):
 )
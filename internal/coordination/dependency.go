@@ -0,0 +1,86 @@
+package coordination
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// WaitForRunCommands waits until the latest execution of every named run command is completed, at most
+// for the timeout. The latest sequence number of a run command is read from its .mrseq file in mrseqDir,
+// and its execution state from the matching status file in statusFolder. onWait is called once with the
+// name of the first run command which is not completed, if any.
+func WaitForRunCommands(ctx *log.Context, mrseqDir string, statusFolder string, names []string, timeout time.Duration, onWait func(name string)) error {
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for _, name := range names {
+		for {
+			state, completed, err := runCommandState(mrseqDir, statusFolder, name)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read the state of run command '%s'", name)
+			}
+			if completed {
+				ctx.Log("event", "dependency completed", "runCommand", name, "executionState", state)
+				break
+			}
+
+			if !waiting {
+				ctx.Log("event", "waiting for dependency", "runCommand", name)
+				onWait(name)
+				waiting = true
+			}
+			if time.Now().Add(pollInterval).After(deadline) {
+				return errors.Wrap(ErrWaitTimedOut, fmt.Sprintf("run command '%s' did not complete", name))
+			}
+			time.Sleep(pollInterval)
+		}
+	}
+	return nil
+}
+
+// runCommandState returns the execution state of the latest execution of the run command, and whether
+// it is completed. A run command which did not execute yet, or did not report its status yet, is not completed.
+func runCommandState(mrseqDir string, statusFolder string, name string) (types.ExecutionState, bool, error) {
+	b, err := os.ReadFile(filepath.Join(mrseqDir, name+constants.MrSeqFileExtension))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, errors.Wrap(err, "failed to read sequence number")
+	}
+	seqNum, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return "", false, errors.Wrapf(err, "cannot parse sequence number %q", b)
+	}
+
+	b, err = os.ReadFile(filepath.Join(statusFolder, fmt.Sprintf("%s.%d%s", name, seqNum, constants.StatusFileExtension)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, errors.Wrap(err, "failed to read status file")
+	}
+	var report types.StatusReport
+	if err := json.Unmarshal(b, &report); err != nil || len(report) == 0 {
+		// The status file is replaced atomically, it is only invalid if something else wrote it
+		return "", false, errors.New("status file is not valid")
+	}
+
+	// The status of asyncExecution run commands is success while they are still running, so the
+	// execution state of the instance view is used when the status includes it.
+	status := report[0].Status
+	var instanceView types.RunCommandInstanceView
+	if err := json.Unmarshal([]byte(status.FormattedMessage.Message), &instanceView); err == nil && instanceView.ExecutionState != "" {
+		return instanceView.ExecutionState, instanceView.ExecutionState != types.Pending && instanceView.ExecutionState != types.Running, nil
+	}
+	return types.ExecutionState(status.Status), status.Status != types.StatusTransitioning, nil
+}
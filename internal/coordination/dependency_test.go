@@ -0,0 +1,93 @@
+package coordination
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/status"
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// saveStatus writes the status of the execution of a run command like the handler does.
+func saveStatus(t *testing.T, mrseqDir, statusFolder, name string, seqNum int, statusType types.StatusType, message string) {
+	require.Nil(t, os.WriteFile(filepath.Join(mrseqDir, name+".mrseq"), []byte{byte('0' + seqNum)}, 0600))
+	b, err := status.MarshalStatusReportIntoJson(types.NewStatusReport(statusType, "Enable", message, name), false)
+	require.Nil(t, err)
+	require.Nil(t, status.SaveStatusReport(statusFolder, name, seqNum, b))
+}
+
+func instanceViewMessage(t *testing.T, state types.ExecutionState) string {
+	b, err := types.RunCommandInstanceView{ExecutionState: state}.Marshal()
+	require.Nil(t, err)
+	return string(b)
+}
+
+func Test_runCommandState(t *testing.T) {
+	mrseqDir, statusFolder := t.TempDir(), t.TempDir()
+
+	_, completed, err := runCommandState(mrseqDir, statusFolder, "first")
+	require.Nil(t, err)
+	require.False(t, completed, "run command did not execute yet")
+
+	require.Nil(t, os.WriteFile(filepath.Join(mrseqDir, "first.mrseq"), []byte("2"), 0600))
+	_, completed, err = runCommandState(mrseqDir, statusFolder, "first")
+	require.Nil(t, err)
+	require.False(t, completed, "run command did not report its status yet")
+
+	saveStatus(t, mrseqDir, statusFolder, "first", 2, types.StatusTransitioning, instanceViewMessage(t, types.Running))
+	state, completed, err := runCommandState(mrseqDir, statusFolder, "first")
+	require.Nil(t, err)
+	require.False(t, completed)
+	require.Equal(t, types.Running, state)
+
+	// asyncExecution reports success while the script is still running
+	saveStatus(t, mrseqDir, statusFolder, "first", 2, types.StatusSuccess, instanceViewMessage(t, types.Running))
+	_, completed, err = runCommandState(mrseqDir, statusFolder, "first")
+	require.Nil(t, err)
+	require.False(t, completed)
+
+	saveStatus(t, mrseqDir, statusFolder, "first", 2, types.StatusError, instanceViewMessage(t, types.Failed))
+	state, completed, err = runCommandState(mrseqDir, statusFolder, "first")
+	require.Nil(t, err)
+	require.True(t, completed)
+	require.EqualValues(t, types.Failed, state)
+
+	// status without instance view
+	saveStatus(t, mrseqDir, statusFolder, "first", 3, types.StatusSuccess, "Disable succeeded")
+	state, completed, err = runCommandState(mrseqDir, statusFolder, "first")
+	require.Nil(t, err)
+	require.True(t, completed)
+	require.EqualValues(t, types.StatusSuccess, state)
+
+	require.Nil(t, os.WriteFile(filepath.Join(statusFolder, "first.3.status"), []byte("oops"), 0600))
+	_, _, err = runCommandState(mrseqDir, statusFolder, "first")
+	require.ErrorContains(t, err, "status file is not valid")
+}
+
+func Test_waitForRunCommands(t *testing.T) {
+	shortPollInterval(t)
+	mrseqDir, statusFolder := t.TempDir(), t.TempDir()
+	saveStatus(t, mrseqDir, statusFolder, "first", 1, types.StatusSuccess, instanceViewMessage(t, types.Succeeded))
+	saveStatus(t, mrseqDir, statusFolder, "second", 1, types.StatusTransitioning, instanceViewMessage(t, types.Running))
+
+	var waitedFor []string
+	onWait := func(name string) { waitedFor = append(waitedFor, name) }
+
+	require.Nil(t, WaitForRunCommands(testContext, mrseqDir, statusFolder, []string{"first"}, time.Second, onWait))
+	require.Empty(t, waitedFor)
+
+	err := WaitForRunCommands(testContext, mrseqDir, statusFolder, []string{"first", "second"}, 50*time.Millisecond, onWait)
+	require.True(t, errors.Is(err, ErrWaitTimedOut), "%v", err)
+	require.Contains(t, err.Error(), "run command 'second' did not complete")
+	require.Equal(t, []string{"second"}, waitedFor)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		saveStatus(t, mrseqDir, statusFolder, "second", 1, types.StatusSuccess, instanceViewMessage(t, types.Succeeded))
+	}()
+	require.Nil(t, WaitForRunCommands(testContext, mrseqDir, statusFolder, []string{"first", "second"}, 5*time.Second, onWait))
+}
@@ -0,0 +1,85 @@
+package coordination
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// LocksDirectory is the directory of the lock files, under the data directory
+	LocksDirectory = "locks"
+
+	lockFileExtension = ".lock"
+)
+
+// ErrWaitTimedOut is returned when the lock or the dependencies were not available before the wait timeout.
+var ErrWaitTimedOut = errors.New("timed out")
+
+// pollInterval is the time between two checks of the lock or the dependencies. Replaced in tests.
+var pollInterval = time.Second
+
+// Lock is an exclusive lock shared by the processes of the handler. It is released when
+// the process holding it exits, so a crashed run command never leaves it behind.
+type Lock struct {
+	name string
+	f    *os.File
+}
+
+// LockPath returns the path of the file backing the named lock.
+func LockPath(dataDir string, name string) string {
+	return filepath.Join(dataDir, LocksDirectory, name+lockFileExtension)
+}
+
+// AcquireLock takes the named lock, waiting at most for the timeout if another process holds it.
+// onWait is called once if the lock is not immediately available.
+func AcquireLock(ctx *log.Context, dataDir string, name string, timeout time.Duration, onWait func()) (*Lock, error) {
+	path := LockPath(dataDir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create locks directory")
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open lock file '%s'", path)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for waiting := false; ; waiting = true {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			ctx.Log("event", "lock acquired", "lock", name)
+			return &Lock{name: name, f: f}, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, errors.Wrapf(err, "failed to lock file '%s'", path)
+		}
+
+		if !waiting {
+			ctx.Log("event", "waiting for lock", "lock", name)
+			onWait()
+		}
+		if time.Now().Add(pollInterval).After(deadline) {
+			f.Close()
+			return nil, errors.Wrap(ErrWaitTimedOut, fmt.Sprintf("lock '%s' is held by another run command", name))
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Release releases the lock. It is safe to call on a nil lock.
+func (l *Lock) Release(ctx *log.Context) {
+	if l == nil {
+		return
+	}
+	if err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN); err != nil {
+		ctx.Log("message", "failed to release lock", "lock", l.name, "error", err)
+	}
+	l.f.Close()
+	ctx.Log("event", "lock released", "lock", l.name)
+}
@@ -0,0 +1,63 @@
+package coordination
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var testContext = log.NewContext(log.NewNopLogger())
+
+func shortPollInterval(t *testing.T) {
+	original := pollInterval
+	t.Cleanup(func() { pollInterval = original })
+	pollInterval = 10 * time.Millisecond
+}
+
+func Test_lockPath(t *testing.T) {
+	require.Equal(t, "/var/lib/waagent/run-command-handler/locks/apt.lock", LockPath("/var/lib/waagent/run-command-handler", "apt"))
+}
+
+func Test_acquireLock_exclusive(t *testing.T) {
+	shortPollInterval(t)
+	dataDir := t.TempDir()
+
+	waited := 0
+	lock, err := AcquireLock(testContext, dataDir, "apt", time.Second, func() { waited++ })
+	require.Nil(t, err)
+	require.Equal(t, 0, waited, "lock is available")
+
+	fi, err := os.Stat(LockPath(dataDir, "apt"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600).String(), fi.Mode().String())
+
+	// another run command has to wait, until the timeout
+	_, err = AcquireLock(testContext, dataDir, "apt", 50*time.Millisecond, func() { waited++ })
+	require.True(t, errors.Is(err, ErrWaitTimedOut), "%v", err)
+	require.Contains(t, err.Error(), "lock 'apt' is held by another run command")
+	require.Equal(t, 1, waited)
+
+	// other locks are independent
+	other, err := AcquireLock(testContext, dataDir, "yum", 50*time.Millisecond, func() { waited++ })
+	require.Nil(t, err)
+	other.Release(testContext)
+
+	// or until the lock is released
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lock.Release(testContext)
+	}()
+	next, err := AcquireLock(testContext, dataDir, "apt", 5*time.Second, func() { waited++ })
+	require.Nil(t, err)
+	require.Equal(t, 2, waited)
+	next.Release(testContext)
+}
+
+func Test_releaseNilLock(t *testing.T) {
+	var lock *Lock
+	lock.Release(testContext)
+}
@@ -0,0 +1,216 @@
+// Package diagnostics builds the bundle of the logs and of the state of the handler requested by the support. The
+// secrets are redacted and the protected settings are never collected.
+package diagnostics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/pkg/errors"
+)
+
+const (
+	// bundleDir is the directory of the files in the bundle
+	bundleDir = "run-command-handler-diagnostics"
+
+	// maxFileSize is the size collected from the end of a file, in bytes
+	maxFileSize = 32 * 1024 * 1024
+
+	// maxListedFiles is the number of entries of the listing of the data directory
+	maxListedFiles = 10000
+)
+
+// Sources locates the files collected in the bundle. The empty locations are not collected.
+type Sources struct {
+	LogDir       string // handler logs and log of the immediate run command service
+	ExtensionDir string // HandlerEnvironment.json and the .mrseq and .pidstart files
+	ConfigFolder string
+	StatusFolder string
+	DataDir      string // only listed, the scripts and their output are not collected
+	UnitName     string // systemd unit of the immediate run command service
+	UnitFile     string
+}
+
+// systemctl runs systemctl and returns its output, overridden by the tests.
+var systemctl = func(args ...string) ([]byte, error) {
+	return exec.Command("systemctl", args...).CombinedOutput()
+}
+
+// bundle writes the files of the bundle and records the sources which could not be collected.
+type bundle struct {
+	tw       *tar.Writer
+	now      time.Time
+	problems []string
+}
+
+// Collect writes the bundle to w, as a gzipped tar, and returns the sources which could not be collected. The
+// problems are also written to errors.txt in the bundle.
+func Collect(w io.Writer, s Sources, now time.Time) ([]string, error) {
+	gw := gzip.NewWriter(w)
+	b := &bundle{tw: tar.NewWriter(gw), now: now}
+
+	if s.LogDir != "" {
+		b.addFiles("logs", s.LogDir, "*", redactFile)
+	}
+	if s.ExtensionDir != "" {
+		b.addFile("extension", filepath.Join(s.ExtensionDir, handlersettings.HandlerEnvFileName), redactFile)
+		b.addFiles("extension", s.ExtensionDir, "*"+constants.MrSeqFileExtension, nil)
+		b.addFiles("extension", s.ExtensionDir, "*.pidstart", nil)
+	}
+	if s.ConfigFolder != "" {
+		b.addFiles("config", s.ConfigFolder, "*"+constants.ConfigFileExtension, stripProtectedSettings)
+	}
+	if s.StatusFolder != "" {
+		b.addFiles("status", s.StatusFolder, "*", redactFile)
+	}
+	if s.UnitName != "" {
+		b.addSystemd(s.UnitName, s.UnitFile)
+	}
+	if s.DataDir != "" {
+		b.addListing("data/listing.txt", s.DataDir)
+	}
+
+	if len(b.problems) > 0 {
+		b.write("errors.txt", []byte(strings.Join(b.problems, "\n")+"\n"))
+	}
+	if err := b.tw.Close(); err != nil {
+		return b.problems, errors.Wrap(err, "failed to write the bundle")
+	}
+	return b.problems, errors.Wrap(gw.Close(), "failed to write the bundle")
+}
+
+// addFiles adds the regular files of the directory matching the pattern, transformed by transform if not nil.
+func (b *bundle) addFiles(dir, sourceDir, pattern string, transform func([]byte) ([]byte, error)) {
+	if _, err := os.Stat(sourceDir); err != nil {
+		b.problem("%s: %v", sourceDir, err)
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(sourceDir, pattern))
+	if err != nil {
+		b.problem("%s: %v", sourceDir, err)
+		return
+	}
+	for _, p := range paths {
+		if fi, err := os.Lstat(p); err == nil && fi.Mode().IsRegular() {
+			b.addFile(dir, p, transform)
+		}
+	}
+}
+
+// addFile adds the end of the file, transformed by transform if not nil.
+func (b *bundle) addFile(dir, sourcePath string, transform func([]byte) ([]byte, error)) {
+	content, err := readTail(sourcePath, maxFileSize)
+	if err != nil {
+		b.problem("%s: %v", sourcePath, err)
+		return
+	}
+	if transform != nil {
+		if content, err = transform(content); err != nil {
+			b.problem("%s: %v", sourcePath, err)
+			return
+		}
+	}
+	b.write(path.Join(dir, filepath.Base(sourcePath)), content)
+}
+
+// addSystemd adds the unit file and the state of the unit.
+func (b *bundle) addSystemd(unitName, unitFile string) {
+	if unitFile != "" {
+		b.addFile("systemd", unitFile, redactFile)
+	}
+	var state []byte
+	for _, args := range [][]string{
+		{"show", unitName, "--property=LoadState,ActiveState,SubState,UnitFileState,MainPID,NRestarts,ExecMainStartTimestamp"},
+		{"status", "--no-pager", "--lines=50", unitName},
+	} {
+		// systemctl status exits with a non-zero code when the unit is not active, its output is still collected
+		output, err := systemctl(args...)
+		state = append(state, fmt.Sprintf("$ systemctl %s\n", strings.Join(args, " "))...)
+		state = append(state, output...)
+		if err != nil {
+			state = append(state, fmt.Sprintf("(%v)\n", err)...)
+		}
+		state = append(state, '\n')
+	}
+	b.write("systemd/state.txt", Redact(state))
+}
+
+// addListing adds the listing of the directory, with the mode, the size and the modification time of its entries.
+func (b *bundle) addListing(name, dir string) {
+	var listing strings.Builder
+	count := 0
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Fprintf(&listing, "%s: %v\n", p, err)
+			return nil
+		}
+		if count++; count > maxListedFiles {
+			fmt.Fprintf(&listing, "... more than %d entries, listing truncated\n", maxListedFiles)
+			return filepath.SkipAll
+		}
+		fi, err := d.Info()
+		if err != nil {
+			fmt.Fprintf(&listing, "%s: %v\n", p, err)
+			return nil
+		}
+		fmt.Fprintf(&listing, "%s %10d %s %s\n", fi.Mode(), fi.Size(), fi.ModTime().UTC().Format(time.RFC3339), p)
+		return nil
+	})
+	if err != nil {
+		b.problem("%s: %v", dir, err)
+		return
+	}
+	b.write(name, Redact([]byte(listing.String())))
+}
+
+// write adds the file to the bundle. A failure is recorded as a problem.
+func (b *bundle) write(name string, content []byte) {
+	hdr := &tar.Header{
+		Name:     path.Join(bundleDir, name),
+		Mode:     0600,
+		Size:     int64(len(content)),
+		ModTime:  b.now,
+		Typeflag: tar.TypeReg,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		b.problem("%s: %v", name, err)
+		return
+	}
+	if _, err := b.tw.Write(content); err != nil {
+		b.problem("%s: %v", name, err)
+	}
+}
+
+func (b *bundle) problem(format string, args ...interface{}) {
+	b.problems = append(b.problems, fmt.Sprintf(format, args...))
+}
+
+// readTail reads the last max bytes of the file.
+func readTail(path string, max int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > max {
+		if _, err := f.Seek(-max, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(f)
+}
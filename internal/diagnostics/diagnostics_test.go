@@ -0,0 +1,119 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readBundle returns the content of the files of the bundle, by path relative to the bundle directory.
+func readBundle(t *testing.T, b []byte) map[string]string {
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	require.Nil(t, err)
+	tr := tar.NewReader(gr)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.Nil(t, err)
+		require.Equal(t, int64(0600), hdr.Mode)
+		content, err := io.ReadAll(tr)
+		require.Nil(t, err)
+		files[strings.TrimPrefix(hdr.Name, bundleDir+"/")] = string(content)
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	}
+}
+
+func Test_Collect(t *testing.T) {
+	root := t.TempDir()
+	s := Sources{
+		LogDir:       filepath.Join(root, "log"),
+		ExtensionDir: filepath.Join(root, "extension"),
+		ConfigFolder: filepath.Join(root, "extension", "config"),
+		StatusFolder: filepath.Join(root, "extension", "status"),
+		DataDir:      filepath.Join(root, "data"),
+		UnitName:     "managedruncommand.service",
+		UnitFile:     filepath.Join(root, "managedruncommand.service"),
+	}
+	writeFiles(t, root, map[string]string{
+		"log/handler.log":                     "event=download scriptUri=https://a/s.sh?sig=secret\n",
+		"log/ImmediateRunCommandService.log":  "started\n",
+		"log/output/name.1.stdout.log":        "script output\n",
+		"extension/HandlerEnvironment.json":   `[{"handlerEnvironment": {"logFolder": "/var/log/azure"}}]`,
+		"extension/name.mrseq":                "1",
+		"extension/name.pidstart":             "123 456",
+		"extension/bin/run-command-handler":   "binary",
+		"extension/config/name.1.settings":    `{"runtimeSettings": [{"handlerSettings": {"protectedSettings": "MIIB"}}]}`,
+		"extension/config/invalid.1.settings": `{`,
+		"extension/status/name.1.status":      `[{"status": {"formattedMessage": {"message": "password=hunter2"}}}]`,
+		"data/download/name/1/script.sh":      "echo secret",
+		"managedruncommand.service":           "[Unit]\n",
+	})
+	defer func(f func(...string) ([]byte, error)) { systemctl = f }(systemctl)
+	systemctl = func(args ...string) ([]byte, error) {
+		if args[0] == "status" {
+			return []byte("inactive\n"), errors.New("exit status 3")
+		}
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	var buf bytes.Buffer
+	problems, err := Collect(&buf, s, time.Now())
+	require.Nil(t, err)
+	require.Equal(t, 1, len(problems))
+	require.Contains(t, problems[0], "invalid.1.settings: failed to parse the settings")
+
+	files := readBundle(t, buf.Bytes())
+	require.Equal(t, "event=download scriptUri=https://a/s.sh?sig=REDACTED\n", files["logs/handler.log"])
+	require.Equal(t, "started\n", files["logs/ImmediateRunCommandService.log"])
+	require.NotContains(t, files, "logs/name.1.stdout.log", "the output of the scripts is not collected")
+	require.Contains(t, files, "extension/HandlerEnvironment.json")
+	require.Equal(t, "1", files["extension/name.mrseq"])
+	require.Equal(t, "123 456", files["extension/name.pidstart"])
+	require.NotContains(t, files["config/name.1.settings"], "MIIB")
+	require.NotContains(t, files, "config/invalid.1.settings")
+	require.Equal(t, `[{"status": {"formattedMessage": {"message": "password=REDACTED"}}}]`, files["status/name.1.status"])
+	require.Equal(t, "[Unit]\n", files["systemd/managedruncommand.service"])
+	require.Contains(t, files["systemd/state.txt"], "$ systemctl show managedruncommand.service")
+	require.Contains(t, files["systemd/state.txt"], "inactive\n(exit status 3)\n")
+	require.Contains(t, files["data/listing.txt"], filepath.Join(s.DataDir, "download", "name", "1", "script.sh"))
+	require.NotContains(t, files["data/listing.txt"], "echo secret")
+	require.Contains(t, files["errors.txt"], "invalid.1.settings")
+}
+
+func Test_Collect_missingSources(t *testing.T) {
+	var buf bytes.Buffer
+	problems, err := Collect(&buf, Sources{LogDir: filepath.Join(t.TempDir(), "missing")}, time.Now())
+	require.Nil(t, err)
+	require.Equal(t, 1, len(problems))
+	require.Contains(t, readBundle(t, buf.Bytes())["errors.txt"], "missing")
+}
+
+func Test_readTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	require.Nil(t, os.WriteFile(path, []byte("0123456789"), 0600))
+	b, err := readTail(path, 4)
+	require.Nil(t, err)
+	require.Equal(t, "6789", string(b))
+	b, err = readTail(path, 20)
+	require.Nil(t, err)
+	require.Equal(t, "0123456789", string(b))
+}
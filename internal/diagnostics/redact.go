@@ -0,0 +1,65 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// redacted replaces the secrets in the bundle
+const redacted = "REDACTED"
+
+// redactions replace the secrets which can appear in the logs, the status files and the settings: the signatures
+// of the SAS URIs, the values of the JSON fields and of the logfmt keys named like secrets, the bearer tokens, and
+// the RunAs password and the variables of the RunAs commands logged by the previous versions of the handler, e.g.
+// echo <password> | sudo -S -u <user> env 'NAME=value' ... <command>.
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(\becho\s+)[^\n]*?(\s*\|\s*sudo\s+-S\b)`), "${1}" + redacted + "${2}"},
+	{regexp.MustCompile(`(\bsudo\s+-S\s+-u\s+\S+\s+env\s+)(?:(?:'[A-Za-z_]\w*=[^']*'(?:\\+''[^']*')*|[A-Za-z_]\w*=[^\s'"]*)\s+)+`), "${1}" + redacted + " "},
+	{regexp.MustCompile(`(?i)([?&](?:sig|signature)=)[^&\s"'<>\\]+`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)("[^"]*(?:password|secret|token|credential|key)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`), `${1}"` + redacted + `"`},
+	{regexp.MustCompile(`(?i)(\b\w*(?:password|secret|token|credential)\w*=)(?:"(?:[^"\\]|\\.)*"|[^\s"]+)`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)(\bbearer\s+)[a-z0-9\-._~+/]+=*`), "${1}" + redacted},
+}
+
+// Redact returns the content with the secrets replaced.
+func Redact(b []byte) []byte {
+	for _, r := range redactions {
+		b = r.pattern.ReplaceAll(b, []byte(r.replacement))
+	}
+	return b
+}
+
+func redactFile(b []byte) ([]byte, error) {
+	return Redact(b), nil
+}
+
+// stripProtectedSettings removes the protected settings of the handler settings file, which are encrypted with the
+// certificate of the agent, and redacts the public settings. The settings of the previous sequence numbers are
+// emptied by the cleanup, they are collected as they are.
+func stripProtectedSettings(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return b, nil
+	}
+	var file map[string]interface{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the settings")
+	}
+	runtimeSettings, _ := file["runtimeSettings"].([]interface{})
+	for _, r := range runtimeSettings {
+		if r, ok := r.(map[string]interface{}); ok {
+			if s, ok := r["handlerSettings"].(map[string]interface{}); ok {
+				delete(s, "protectedSettings")
+			}
+		}
+	}
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the settings")
+	}
+	return Redact(b), nil
+}
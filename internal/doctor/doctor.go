@@ -0,0 +1,242 @@
+// Package doctor checks the environment the handler depends on: the tools, the certificates, the file systems, the
+// services and the RunAs users. The checks are run by the doctor tool, and the ones which apply to an execution are
+// run by enable as preflight.
+package doctor
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/pkg/systemd"
+	"github.com/pkg/errors"
+)
+
+// Results of a check
+const (
+	Pass = "pass"
+	Warn = "warn"
+	Fail = "fail"
+)
+
+// Free space of the data directory below which the disk space check warns and fails, in bytes
+const (
+	lowFreeSpace = 100 * 1024 * 1024
+	minFreeSpace = 10 * 1024 * 1024
+)
+
+const (
+	dialTimeout  = 3 * time.Second
+	statfsNoExec = 0x8 // ST_NOEXEC flag of statfs
+)
+
+// Environment is what the checks examine. The checks of the empty fields pass.
+type Environment struct {
+	DataDir      string
+	ConfigFolder string   // the certificates of the protected settings are two levels up, e.g. in /var/lib/waagent
+	Thumbprints  []string // certificates of the protected settings of the run commands
+	RunAsUsers   []string
+	WireServer   string // host:port of the wire server
+}
+
+// Finding is the outcome of a check, with the remediation when the check does not pass.
+type Finding struct {
+	Check       string `json:"check"`
+	Result      string `json:"result"`
+	Message     string `json:"message"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// Check is one of the checks of the catalog.
+type Check struct {
+	Name      string
+	Preflight bool // run by enable before the execution
+	Run       func(env Environment) Finding
+}
+
+// Checks is the catalog, in the order of the report.
+var Checks = []Check{
+	{Name: "openssl", Run: checkOpenSSL},
+	{Name: "certificates", Run: checkCertificates},
+	{Name: "noexec", Preflight: true, Run: checkNoExec},
+	{Name: "disk-space", Preflight: true, Run: checkDiskSpace},
+	{Name: "systemd", Run: checkSystemd},
+	{Name: "wire-server", Run: checkWireServer},
+	{Name: "runas-users", Preflight: true, Run: checkRunAsUsers},
+}
+
+// Overridden by the tests
+var (
+	lookPath       = exec.LookPath
+	statfs         = syscall.Statfs
+	systemdPresent = systemd.IsSystemDPresent
+)
+
+// Run runs the checks and returns their findings, in the same order.
+func Run(env Environment, checks []Check) []Finding {
+	findings := make([]Finding, 0, len(checks))
+	for _, c := range checks {
+		f := c.Run(env)
+		f.Check = c.Name
+		findings = append(findings, f)
+	}
+	return findings
+}
+
+// Preflight runs the checks which apply to an execution. The error describes the failed checks with their
+// remediation.
+func Preflight(env Environment) ([]Finding, error) {
+	var checks []Check
+	for _, c := range Checks {
+		if c.Preflight {
+			checks = append(checks, c)
+		}
+	}
+	findings := Run(env, checks)
+
+	var failures []string
+	for _, f := range findings {
+		if f.Result == Fail {
+			failures = append(failures, fmt.Sprintf("%s: %s. %s", f.Check, f.Message, f.Remediation))
+		}
+	}
+	if len(failures) > 0 {
+		return findings, errors.Errorf("the environment of the VM cannot execute the run command: %s", strings.Join(failures, "; "))
+	}
+	return findings, nil
+}
+
+func pass(message string) Finding {
+	return Finding{Result: Pass, Message: message}
+}
+
+func checkOpenSSL(Environment) Finding {
+	path, err := lookPath("openssl")
+	if err != nil {
+		return Finding{Result: Fail, Message: "openssl is not installed",
+			Remediation: "Install openssl, it decrypts the protected settings of the run commands."}
+	}
+	return pass("openssl is installed at " + path)
+}
+
+func checkCertificates(env Environment) Finding {
+	if len(env.Thumbprints) == 0 {
+		return pass("no protected settings to decrypt")
+	}
+	var missing []string
+	for _, thumbprint := range env.Thumbprints {
+		crt, prv := handlersettings.CertificatePaths(env.ConfigFolder, thumbprint)
+		for _, path := range []string{crt, prv} {
+			if _, err := os.Stat(path); err != nil {
+				missing = append(missing, path)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return Finding{Result: Fail, Message: "missing certificate files " + strings.Join(missing, ", "),
+			Remediation: "The certificates are written by the guest agent. Restart the agent, e.g. systemctl restart walinuxagent, so that it fetches them again."}
+	}
+	return pass(fmt.Sprintf("the certificates of %d thumbprints are present", len(env.Thumbprints)))
+}
+
+func checkNoExec(env Environment) Finding {
+	if env.DataDir == "" {
+		return pass("no data directory to check")
+	}
+	dir := existingAncestor(env.DataDir)
+	var st syscall.Statfs_t
+	if err := statfs(dir, &st); err != nil {
+		return Finding{Result: Warn, Message: fmt.Sprintf("cannot read the mount options of %s: %v", dir, err)}
+	}
+	if st.Flags&statfsNoExec != 0 {
+		return Finding{Result: Fail, Message: fmt.Sprintf("the data directory %s is on a file system mounted with noexec", env.DataDir),
+			Remediation: fmt.Sprintf("Remount the file system of %s without noexec, e.g. mount -o remount,exec <mount point>, and remove noexec from /etc/fstab.", dir)}
+	}
+	return pass(fmt.Sprintf("the data directory %s allows execution", env.DataDir))
+}
+
+func checkDiskSpace(env Environment) Finding {
+	if env.DataDir == "" {
+		return pass("no data directory to check")
+	}
+	dir := existingAncestor(env.DataDir)
+	var st syscall.Statfs_t
+	if err := statfs(dir, &st); err != nil {
+		return Finding{Result: Warn, Message: fmt.Sprintf("cannot read the free space of %s: %v", dir, err)}
+	}
+	free := st.Bavail * uint64(st.Bsize)
+	message := fmt.Sprintf("%d MB free for the data directory %s", free/(1024*1024), env.DataDir)
+	remediation := "Free space on the file system of the data directory, e.g. with the gc tool, or grow the file system."
+	switch {
+	case free < minFreeSpace:
+		return Finding{Result: Fail, Message: message, Remediation: remediation}
+	case free < lowFreeSpace:
+		return Finding{Result: Warn, Message: message, Remediation: remediation}
+	}
+	return pass(message)
+}
+
+func checkSystemd(Environment) Finding {
+	if !systemdPresent() {
+		return Finding{Result: Warn, Message: "systemd is not running",
+			Remediation: "installAsService and the immediate run command service require systemd, use a VM image booted with systemd to use them."}
+	}
+	return pass("systemd is running")
+}
+
+func checkWireServer(env Environment) Finding {
+	if env.WireServer == "" {
+		return pass("no wire server to check")
+	}
+	conn, err := net.DialTimeout("tcp", env.WireServer, dialTimeout)
+	if err != nil {
+		return Finding{Result: Warn, Message: fmt.Sprintf("the wire server %s is unreachable: %v", env.WireServer, err),
+			Remediation: "Allow outbound TCP connections of root to the wire server in the firewall and the proxy settings, the immediate run commands and their status go through it."}
+	}
+	conn.Close()
+	return pass("the wire server " + env.WireServer + " is reachable")
+}
+
+func checkRunAsUsers(env Environment) Finding {
+	if len(env.RunAsUsers) == 0 {
+		return pass("no RunAs user")
+	}
+	var missingUsers, missingHomes []string
+	for _, name := range env.RunAsUsers {
+		u, err := user.Lookup(name)
+		if err != nil {
+			missingUsers = append(missingUsers, name)
+			continue
+		}
+		if fi, err := os.Stat(u.HomeDir); u.HomeDir == "" || err != nil || !fi.IsDir() {
+			missingHomes = append(missingHomes, fmt.Sprintf("%s (%s)", name, u.HomeDir))
+		}
+	}
+	if len(missingUsers) > 0 {
+		return Finding{Result: Fail, Message: "the RunAs users " + strings.Join(missingUsers, ", ") + " do not exist",
+			Remediation: "Create the users on the VM, e.g. useradd -m <user>, or change runAsUser in the settings."}
+	}
+	if len(missingHomes) > 0 {
+		return Finding{Result: Warn, Message: "the RunAs users " + strings.Join(missingHomes, ", ") + " have no home directory",
+			Remediation: "Create the home directories, e.g. mkhomedir_helper <user>; scripts relying on HOME or a login shell fail without them."}
+	}
+	return pass(fmt.Sprintf("the RunAs users %s exist", strings.Join(env.RunAsUsers, ", ")))
+}
+
+// existingAncestor returns the path, or its closest ancestor which exists, e.g. when the data directory is not
+// created yet.
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			return path
+		}
+		path = filepath.Dir(path)
+	}
+}
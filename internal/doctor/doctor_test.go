@@ -0,0 +1,130 @@
+package doctor
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStatfs returns a file system with the flags and the free space, in bytes.
+func fakeStatfs(t *testing.T, flags int64, free uint64) {
+	old := statfs
+	t.Cleanup(func() { statfs = old })
+	statfs = func(path string, st *syscall.Statfs_t) error {
+		st.Flags = flags
+		st.Bsize = 4096
+		st.Bavail = free / 4096
+		return nil
+	}
+}
+
+func Test_checkOpenSSL(t *testing.T) {
+	defer func(old func(string) (string, error)) { lookPath = old }(lookPath)
+
+	lookPath = func(string) (string, error) { return "/usr/bin/openssl", nil }
+	require.Equal(t, Finding{Result: Pass, Message: "openssl is installed at /usr/bin/openssl"}, checkOpenSSL(Environment{}))
+
+	lookPath = func(string) (string, error) { return "", errors.New("not found") }
+	f := checkOpenSSL(Environment{})
+	require.Equal(t, Fail, f.Result)
+	require.NotEmpty(t, f.Remediation)
+}
+
+func Test_checkCertificates(t *testing.T) {
+	root := t.TempDir()
+	configFolder := filepath.Join(root, "Microsoft.CPlat.Core.RunCommandHandlerLinux-1.3.0", "config")
+	env := Environment{ConfigFolder: configFolder, Thumbprints: []string{"THUMBPRINT"}}
+	require.Nil(t, os.WriteFile(filepath.Join(root, "THUMBPRINT.crt"), nil, 0600))
+
+	f := checkCertificates(env)
+	require.Equal(t, Fail, f.Result)
+	require.Equal(t, "missing certificate files "+filepath.Join(root, "THUMBPRINT.prv"), f.Message)
+
+	require.Nil(t, os.WriteFile(filepath.Join(root, "THUMBPRINT.prv"), nil, 0600))
+	require.Equal(t, Pass, checkCertificates(env).Result)
+	require.Equal(t, Pass, checkCertificates(Environment{}).Result, "no protected settings")
+}
+
+func Test_checkNoExec(t *testing.T) {
+	env := Environment{DataDir: filepath.Join(t.TempDir(), "not", "created")}
+
+	fakeStatfs(t, 0, 1<<30)
+	require.Equal(t, Pass, checkNoExec(env).Result)
+
+	fakeStatfs(t, statfsNoExec, 1<<30)
+	f := checkNoExec(env)
+	require.Equal(t, Fail, f.Result)
+	require.Contains(t, f.Message, "mounted with noexec")
+	require.Contains(t, f.Remediation, "mount -o remount,exec")
+}
+
+func Test_checkDiskSpace(t *testing.T) {
+	env := Environment{DataDir: t.TempDir()}
+
+	fakeStatfs(t, 0, 1<<30)
+	require.Equal(t, Pass, checkDiskSpace(env).Result)
+	fakeStatfs(t, 0, 50*1024*1024)
+	require.Equal(t, Warn, checkDiskSpace(env).Result)
+	fakeStatfs(t, 0, 1024*1024)
+	f := checkDiskSpace(env)
+	require.Equal(t, Fail, f.Result)
+	require.Equal(t, "1 MB free for the data directory "+env.DataDir, f.Message)
+}
+
+func Test_checkSystemd(t *testing.T) {
+	defer func(old func() bool) { systemdPresent = old }(systemdPresent)
+
+	systemdPresent = func() bool { return true }
+	require.Equal(t, Pass, checkSystemd(Environment{}).Result)
+	systemdPresent = func() bool { return false }
+	require.Equal(t, Warn, checkSystemd(Environment{}).Result)
+}
+
+func Test_checkWireServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := l.Addr().String()
+	require.Equal(t, Pass, checkWireServer(Environment{WireServer: addr}).Result)
+
+	l.Close()
+	f := checkWireServer(Environment{WireServer: addr})
+	require.Equal(t, Warn, f.Result)
+	require.Contains(t, f.Message, "is unreachable")
+}
+
+func Test_checkRunAsUsers(t *testing.T) {
+	require.Equal(t, Pass, checkRunAsUsers(Environment{RunAsUsers: []string{"root"}}).Result)
+
+	f := checkRunAsUsers(Environment{RunAsUsers: []string{"root", "no-such-user-of-the-doctor"}})
+	require.Equal(t, Fail, f.Result)
+	require.Equal(t, "the RunAs users no-such-user-of-the-doctor do not exist", f.Message)
+}
+
+func Test_Run(t *testing.T) {
+	checks := []Check{
+		{Name: "first", Run: func(Environment) Finding { return pass("ok") }},
+		{Name: "second", Run: func(Environment) Finding { return Finding{Result: Warn, Message: "low"} }},
+	}
+	require.Equal(t, []Finding{
+		{Check: "first", Result: Pass, Message: "ok"},
+		{Check: "second", Result: Warn, Message: "low"},
+	}, Run(Environment{}, checks))
+}
+
+func Test_Preflight(t *testing.T) {
+	fakeStatfs(t, statfsNoExec, 1<<30)
+	findings, err := Preflight(Environment{DataDir: t.TempDir()})
+	require.Len(t, findings, 3, "noexec, disk-space and runas-users")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "the environment of the VM cannot execute the run command: noexec: the data directory ")
+	require.Contains(t, err.Error(), "Remount the file system")
+
+	fakeStatfs(t, 0, 1<<30)
+	_, err = Preflight(Environment{DataDir: t.TempDir(), RunAsUsers: []string{"root"}})
+	require.NoError(t, err)
+}
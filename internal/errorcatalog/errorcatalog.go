@@ -0,0 +1,191 @@
+// Package errorcatalog describes the failures of the run commands: a stable code, the category telling who can fix
+// the failure and the remediation shown to the customer. The code and the category are reported in the instance
+// view and in the telemetry, separately from the message.
+package errorcatalog
+
+import (
+	"fmt"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/pkg/errors"
+)
+
+// Category tells who can fix a failure.
+type Category string
+
+const (
+	// User failures come from the script or the VM of the customer
+	User Category = "user"
+
+	// Config failures come from the settings of the run command
+	Config Category = "config"
+
+	// Platform failures come from the handler or the guest agent
+	Platform Category = "platform"
+
+	// Network failures come from the storage or the endpoints the handler connects to
+	Network Category = "network"
+)
+
+// Entry is a failure of the catalog.
+type Entry struct {
+	Code        string   `json:"code"`
+	Category    Category `json:"category"`
+	ExitCode    int      `json:"exitCode,omitempty"` // exit code of the handler, zero for the failures of the script
+	Remediation string   `json:"remediation"`
+}
+
+// Error is a failure of the catalog, with what failed and its cause.
+type Error struct {
+	Entry   Entry
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Entry.Remediation != "" {
+		msg += ". " + e.Entry.Remediation
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an error of the entry.
+func (e Entry) New(message string) *Error {
+	return &Error{Entry: e, Message: message}
+}
+
+// Wrap returns an error of the entry caused by err.
+func (e Entry) Wrap(err error, message string) *Error {
+	return &Error{Entry: e, Message: message, Err: err}
+}
+
+// Wrapf returns an error of the entry caused by err, with a formatted message.
+func (e Entry) Wrapf(err error, format string, args ...interface{}) *Error {
+	return e.Wrap(err, fmt.Sprintf(format, args...))
+}
+
+const (
+	// retryOrContactSupport is the remediation of the failures of the handler itself
+	retryOrContactSupport = "This is an error of the handler. Retry the run command; if the error persists, collect the logs of the VM with the collect-logs tool of the handler and open a support request with them"
+
+	// refer is appended to the remediations documented for managed run commands
+	refer = "For more info, refer https://aka.ms/RunCommandManagedLinux"
+)
+
+// Failures of the handler, by exit code
+var (
+	ScriptDownloadFailed = Entry{Code: "ScriptDownloadFailed", Category: Network, ExitCode: constants.ExitCode_ScriptBlobDownloadFailed,
+		Remediation: "Use either a public script URI that points to .sh file, Azure storage blob SAS URI or storage blob accessible by a managed identity and retry. If managed identity is used, make sure it has been given access to container of storage blob with 'Storage Blob Data Reader' role assignment. In case of user-assigned identity, make sure you add it under VM's identity. " + refer}
+	OutputBlobCreateFailed = Entry{Code: "OutputBlobCreateFailed", Category: Network, ExitCode: constants.ExitCode_BlobCreateOrReplaceFailed,
+		Remediation: "Use a valid blob SAS URI with [read, append, create, write] permissions OR managed identity. If managed identity is used, make sure Azure blob and identity exist, and identity has been given access to storage blob's container with 'Storage Blob Data Contributor' role assignment. In case of user-assigned identity, make sure you add it under VM's identity and provide outputBlobUri / errorBlobUri and corresponding clientId in outputBlobManagedIdentity / errorBlobManagedIdentity parameter(s). In case of system-assigned identity, do not use outputBlobManagedIdentity / errorBlobManagedIdentity parameter(s). " + refer}
+	RunAsUserNotFound = Entry{Code: "RunAsUserNotFound", Category: Config, ExitCode: constants.ExitCode_RunAsLookupUserFailed,
+		Remediation: "Make sure the RunAs user is added on the VM and has access to the resources used by the run command (directories, files, network etc.), or change runAsUser. " + refer}
+	OutputSinkCreateFailed = Entry{Code: "OutputSinkCreateFailed", Category: Config, ExitCode: constants.ExitCode_OutputSinkCreateFailed,
+		Remediation: "Check the output sinks of the settings: the local file has to be writable by root, syslog has to be running and the type has to be supported"}
+	LockWaitTimedOut = Entry{Code: "LockWaitTimedOut", Category: Config, ExitCode: constants.ExitCode_WaitForLockTimedOut,
+		Remediation: "Another run command held the exclusive lock longer than waitTimeoutInSeconds. Increase waitTimeoutInSeconds or shorten the run commands sharing the lock"}
+	DependencyWaitTimedOut = Entry{Code: "DependencyWaitTimedOut", Category: Config, ExitCode: constants.ExitCode_WaitForDependencyTimedOut,
+		Remediation: "The run commands in dependsOn did not complete within waitTimeoutInSeconds. Increase waitTimeoutInSeconds, or check that the run commands in dependsOn exist on the VM"}
+	ScriptDecodeFailed = Entry{Code: "ScriptDecodeFailed", Category: Config, ExitCode: constants.ExitCode_DecodeScriptFailed,
+		Remediation: "Check that the script matches its scriptEncoding, e.g. valid base64 for a base64 or gzip script"}
+	InvalidParameters = Entry{Code: "InvalidParameters", Category: Config, ExitCode: constants.ExitCode_InvalidParameters,
+		Remediation: "Fix the parameters so that they match the parameters declared by the settings, then retry. The validate tool of the handler checks the settings on the VM"}
+	TemplateRenderFailed = Entry{Code: "TemplateRenderFailed", Category: Config, ExitCode: constants.ExitCode_RenderTemplateFailed,
+		Remediation: "Fix the template of the script or of the artifact, every parameter it uses has to be provided"}
+	ScriptSyntaxInvalid = Entry{Code: "ScriptSyntaxInvalid", Category: User, ExitCode: constants.ExitCode_SyntaxCheckFailed,
+		Remediation: "Fix the syntax of the script, its interpreter rejected it"}
+	PreflightFailed = Entry{Code: "PreflightFailed", Category: User, ExitCode: constants.ExitCode_PreflightFailed,
+		Remediation: "Fix the environment of the VM as described, the doctor tool of the handler runs the same checks"}
+	OutputRuleFailed = Entry{Code: "OutputRuleFailed", Category: User, ExitCode: constants.ExitCode_OutputRuleFailed,
+		Remediation: "The script exited with 0 but an output rule or failOnStderr marked it as failed, check the output of the script and the matched rule in the instance view"}
+
+	CreateDataDirectoryFailed = platformEntry("CreateDataDirectoryFailed", constants.ExitCode_CreateDataDirectoryFailed)
+	RemoveDataDirectoryFailed = platformEntry("RemoveDataDirectoryFailed", constants.ExitCode_RemoveDataDirectoryFailed)
+	GetSettingsFailed         = Entry{Code: "GetSettingsFailed", Category: Config, ExitCode: constants.ExitCode_GetHandlerSettingsFailed,
+		Remediation: "Fix the settings of the run command as described, the validate tool of the handler checks them on the VM"}
+	SaveScriptFailed         = platformEntry("SaveScriptFailed", constants.ExitCode_SaveScriptFailed)
+	CommandExecutionFailed   = platformEntry("CommandExecutionFailed", constants.ExitCode_CommandExecutionFailed)
+	OpenStdoutFailed         = platformEntry("OpenStdoutFailed", constants.ExitCode_OpenStdOutFileFailed)
+	OpenStderrFailed         = platformEntry("OpenStderrFailed", constants.ExitCode_OpenStdErrFileFailed)
+	IncorrectRunAsScriptPath = platformEntry("IncorrectRunAsScriptPath", constants.ExitCode_IncorrectRunAsScriptPath)
+	RunAsIncorrectScriptPath = platformEntry("RunAsIncorrectScriptPath", constants.ExitCode_RunAsIncorrectScriptPath)
+	RunAsOpenScriptFailed    = platformEntry("RunAsOpenScriptFailed", constants.ExitCode_RunAsOpenSourceScriptFileFailed)
+	RunAsCreateScriptFailed  = platformEntry("RunAsCreateScriptFailed", constants.ExitCode_RunAsCreateRunAsScriptFileFailed)
+	RunAsCopyScriptFailed    = platformEntry("RunAsCopyScriptFailed", constants.ExitCode_RunAsCopySourceScriptToRunAsScriptFileFailed)
+	RunAsLookupUIDFailed     = platformEntry("RunAsLookupUIDFailed", constants.ExitCode_RunAsLookupUserUidFailed)
+	RunAsChownScriptFailed   = platformEntry("RunAsChownScriptFailed", constants.ExitCode_RunAsScriptFileChangeOwnerFailed)
+	RunAsChmodScriptFailed   = platformEntry("RunAsChmodScriptFailed", constants.ExitCode_RunAsScriptFileChangePermissionsFailed)
+	ArtifactDownloadFailed   = Entry{Code: "ArtifactDownloadFailed", Category: Network, ExitCode: constants.ExitCode_DownloadArtifactFailed,
+		Remediation: "Use either a public artifact URI, Azure storage blob SAS URI, or storage blob accessible by a managed identity and retry. " + refer}
+	UpgradeServiceFailed      = platformEntry("UpgradeServiceFailed", constants.ExitCode_UpgradeInstalledServiceFailed)
+	InstallServiceFailed      = platformEntry("InstallServiceFailed", constants.ExitCode_InstallServiceFailed)
+	UninstallServiceFailed    = platformEntry("UninstallServiceFailed", constants.ExitCode_UninstallInstalledServiceFailed)
+	DisableServiceFailed      = platformEntry("DisableServiceFailed", constants.ExitCode_DisableInstalledServiceFailed)
+	CopyStateForUpdateFailed  = platformEntry("CopyStateForUpdateFailed", constants.ExitCode_CopyStateForUpdateFailed)
+	ImmediateGoalStateSkipped = platformEntry("ImmediateGoalStateSkipped", constants.ExitCode_SkippedImmediateGoalState)
+	ImmediateTaskTimedOut     = platformEntry("ImmediateTaskTimedOut", constants.ExitCode_ImmediateTaskTimeout)
+	ImmediateTaskFailed       = platformEntry("ImmediateTaskFailed", constants.ExitCode_ImmediateTaskFailed)
+	RehydrateSequenceFailed   = platformEntry("RehydrateSequenceFailed", constants.ExitCode_CouldNotRehydrateMrSeq)
+	CreateScriptFilesFailed   = platformEntry("CreateScriptFilesFailed", constants.ExitCode_CreateScriptFilesFailed)
+	AcquireLockFailed         = platformEntry("AcquireLockFailed", constants.ExitCode_AcquireLockFailed)
+	DependencyWaitFailed      = Entry{Code: "DependencyWaitFailed", Category: Config, ExitCode: constants.ExitCode_WaitForDependencyFailed,
+		Remediation: "Check dependsOn: a run command cannot depend on itself, and the run commands it depends on have to report a status"}
+)
+
+// Failures of the script, reported with the exit code of the script
+var (
+	ScriptFailed = Entry{Code: "ScriptFailed", Category: User,
+		Remediation: "The script failed, check its output and its error in the instance view"}
+	ScriptTerminated = Entry{Code: "ScriptTerminated", Category: User,
+		Remediation: "The script was killed by a signal, e.g. when it exceeded timeoutInSeconds. Increase timeoutInSeconds or check the output of the script"}
+	Unknown = Entry{Code: "Unknown", Category: Platform, Remediation: retryOrContactSupport}
+)
+
+// Catalog is every failure of the handler, in the order of the exit codes.
+var Catalog = []Entry{
+	ScriptDownloadFailed, OutputBlobCreateFailed, RunAsUserNotFound, OutputSinkCreateFailed, LockWaitTimedOut,
+	DependencyWaitTimedOut, ScriptDecodeFailed, InvalidParameters, TemplateRenderFailed, ScriptSyntaxInvalid,
+	PreflightFailed, OutputRuleFailed,
+	CreateDataDirectoryFailed, RemoveDataDirectoryFailed, GetSettingsFailed, SaveScriptFailed, CommandExecutionFailed,
+	OpenStdoutFailed, OpenStderrFailed, IncorrectRunAsScriptPath, RunAsIncorrectScriptPath, RunAsOpenScriptFailed,
+	RunAsCreateScriptFailed, RunAsCopyScriptFailed, RunAsLookupUIDFailed, RunAsChownScriptFailed,
+	RunAsChmodScriptFailed, ArtifactDownloadFailed, UpgradeServiceFailed, InstallServiceFailed,
+	UninstallServiceFailed, DisableServiceFailed, CopyStateForUpdateFailed, ImmediateGoalStateSkipped,
+	ImmediateTaskTimedOut, ImmediateTaskFailed, RehydrateSequenceFailed, CreateScriptFilesFailed, AcquireLockFailed,
+	DependencyWaitFailed,
+}
+
+func platformEntry(code string, exitCode int) Entry {
+	return Entry{Code: code, Category: Platform, ExitCode: exitCode, Remediation: retryOrContactSupport}
+}
+
+// Lookup returns the failure of the exit code of the handler.
+func Lookup(exitCode int) (Entry, bool) {
+	for _, e := range Catalog {
+		if e.ExitCode == exitCode {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Classify returns the failure of err: the entry of an error of the catalog, else the entry of the exit code of
+// the handler, else Unknown.
+func Classify(err error, exitCode int) Entry {
+	var catalogErr *Error
+	if errors.As(err, &catalogErr) {
+		return catalogErr.Entry
+	}
+	if e, ok := Lookup(exitCode); ok {
+		return e
+	}
+	return Unknown
+}
@@ -0,0 +1,47 @@
+package errorcatalog
+
+import (
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Catalog(t *testing.T) {
+	codes, exitCodes := map[string]bool{}, map[int]bool{}
+	for _, e := range Catalog {
+		require.False(t, codes[e.Code], "duplicate code %s", e.Code)
+		require.False(t, exitCodes[e.ExitCode], "duplicate exit code %d", e.ExitCode)
+		require.NotZero(t, e.ExitCode, e.Code)
+		require.Contains(t, []Category{User, Config, Platform, Network}, e.Category, e.Code)
+		require.NotEmpty(t, e.Remediation, e.Code)
+		require.NotContains(t, e.Remediation, "ICM", e.Code)
+		codes[e.Code], exitCodes[e.ExitCode] = true, true
+	}
+}
+
+func Test_Error(t *testing.T) {
+	cause := errors.New("403 Forbidden")
+	err := ScriptDownloadFailed.Wrapf(cause, "failed to download the script '%s'", "https://storage/script.sh")
+	require.Equal(t, "failed to download the script 'https://storage/script.sh': 403 Forbidden. "+ScriptDownloadFailed.Remediation, err.Error())
+	require.True(t, errors.Is(err, cause))
+
+	require.Equal(t, "the script is invalid. "+ScriptSyntaxInvalid.Remediation, ScriptSyntaxInvalid.New("the script is invalid").Error())
+}
+
+func Test_Lookup(t *testing.T) {
+	e, ok := Lookup(constants.ExitCode_DownloadArtifactFailed)
+	require.True(t, ok)
+	require.Equal(t, ArtifactDownloadFailed, e)
+
+	_, ok = Lookup(1)
+	require.False(t, ok, "exit code of a script")
+}
+
+func Test_Classify(t *testing.T) {
+	wrapped := errors.Wrap(LockWaitTimedOut.New("failed to acquire lock within 1m0s"), "enable")
+	require.Equal(t, LockWaitTimedOut, Classify(wrapped, constants.ExitCode_AcquireLockFailed), "the error comes first")
+	require.Equal(t, SaveScriptFailed, Classify(errors.New("disk full"), constants.ExitCode_SaveScriptFailed))
+	require.Equal(t, Unknown, Classify(errors.New("unexpected"), -300))
+}
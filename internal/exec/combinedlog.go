@@ -0,0 +1,155 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// combinedLogTimeFormat is RFC3339 with a fixed number of microsecond digits so
+// the records of the combined log stay aligned.
+const combinedLogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// CombinedLogPath returns the path of the combined output log for the specified
+// output directory. It does not create the file.
+func CombinedLogPath(dir string) string {
+	return filepath.Join(dir, "output.log")
+}
+
+// combinedLog interleaves the lines of both output streams into a single writer,
+// one record per line in the "<stream> <timestamp> <text>" format.
+type combinedLog struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+func newCombinedLog(w io.Writer) *combinedLog {
+	return &combinedLog{w: w, now: time.Now}
+}
+
+func (l *combinedLog) record(stream string, at time.Time, line []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := fmt.Fprintf(l.w, "%s %s %s\n", stream, at.UTC().Format(combinedLogTimeFormat), line)
+	return err
+}
+
+// streamRecorder records every complete line written to a stream into the combined log. Each line is timestamped
+// with the time its first byte was written.
+type streamRecorder struct {
+	log       *combinedLog
+	stream    string
+	pending   []byte
+	startedAt time.Time
+}
+
+func (l *combinedLog) recorder(stream string) *streamRecorder {
+	return &streamRecorder{log: l, stream: stream}
+}
+
+// Write records the complete lines. The combined log is best effort, failures to write it are ignored.
+func (r *streamRecorder) Write(b []byte) (int, error) {
+	data := b
+	for len(data) > 0 {
+		if len(r.pending) == 0 {
+			r.startedAt = r.log.now()
+		}
+
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			r.pending = append(r.pending, data...)
+			break
+		}
+
+		r.log.record(r.stream, r.startedAt, append(r.pending, data[:i]...))
+		r.pending = r.pending[:0]
+		data = data[i+1:]
+	}
+	return len(b), nil
+}
+
+// Close records the last line if it was not terminated.
+func (r *streamRecorder) Close() error {
+	if len(r.pending) > 0 {
+		r.log.record(r.stream, r.startedAt, r.pending)
+		r.pending = nil
+	}
+	return nil
+}
+
+const (
+	// pipeIdleTimeout is how long a pipe has to stay empty once the script exited for its output to be copied
+	pipeIdleTimeout = 20 * time.Millisecond
+
+	// pipeDrainTimeout is how long the output written before the script exited is waited for, when background
+	// processes keep writing to the pipes
+	pipeDrainTimeout = time.Second
+)
+
+// streamTee copies the pipe the script writes a stream to into the file of the stream, and records its lines into
+// the combined log as they are read. The lines are timestamped with the time they were read, i.e. as soon as the
+// script wrote them, and recorded in that order. Only lines written to both streams within the time it takes to
+// wake up the copies, usually well under a millisecond, can be recorded in either order.
+type streamTee struct {
+	pipe     *os.File
+	raw      io.WriteCloser
+	recorder *streamRecorder
+	drained  chan struct{}
+	once     sync.Once
+}
+
+func (l *combinedLog) tee(stream string, pipe *os.File, raw io.WriteCloser) *streamTee {
+	return &streamTee{pipe: pipe, raw: raw, recorder: l.recorder(stream), drained: make(chan struct{})}
+}
+
+// copy copies the pipe until every process writing to it closed it, background processes started by the script
+// included. The file of the stream is closed then.
+func (t *streamTee) copy() {
+	defer t.raw.Close()
+	defer t.pipe.Close()
+	defer t.recorder.Close()
+	defer t.once.Do(func() { close(t.drained) })
+
+	buf := make([]byte, 32*1024)
+	idle := false
+	for {
+		n, err := t.pipe.Read(buf)
+		if n > 0 {
+			idle = false
+			t.raw.Write(buf[:n])
+			t.recorder.Write(buf[:n])
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// The deadline set once the script exited is checked before reading, so the pipe is only known to
+			// be empty when a read with a new deadline times out
+			if idle {
+				t.once.Do(func() { close(t.drained) })
+				t.pipe.SetReadDeadline(time.Time{})
+				continue
+			}
+			idle = true
+			t.pipe.SetReadDeadline(time.Now().Add(pipeIdleTimeout))
+			continue
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// drain returns once the output written to the pipe before the script exited is copied, or after
+// pipeDrainTimeout. It is called once the script exited.
+func (t *streamTee) drain() {
+	t.pipe.SetReadDeadline(time.Now().Add(pipeIdleTimeout))
+	select {
+	case <-t.drained:
+	case <-time.After(pipeDrainTimeout):
+	}
+}
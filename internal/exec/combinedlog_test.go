@@ -0,0 +1,212 @@
+package exec
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_combinedLogPath(t *testing.T) {
+	require.Equal(t, "/tmp/output.log", CombinedLogPath("/tmp"))
+}
+
+func Test_combinedLog_interleavesLines(t *testing.T) {
+	var b bytes.Buffer
+	l := newCombinedLog(&b)
+	clock := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	l.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	stdout, stderr := l.recorder("stdout"), l.recorder("stderr")
+
+	stdout.Write([]byte("first "))    // line started at 03:04:06
+	stderr.Write([]byte("oops\n"))    // 03:04:07
+	stdout.Write([]byte("line\nsec")) // completes the first line, second line started at 03:04:08
+	stdout.Write([]byte("ond\n"))
+	stderr.Write([]byte("unterminated")) // 03:04:09, recorded on Close
+	require.Nil(t, stdout.Close())
+	require.Nil(t, stderr.Close())
+
+	require.Equal(t, strings.Join([]string{
+		"stderr 2024-01-02T03:04:07.000006Z oops",
+		"stdout 2024-01-02T03:04:06.000006Z first line",
+		"stdout 2024-01-02T03:04:08.000006Z second",
+		"stderr 2024-01-02T03:04:09.000006Z unterminated",
+		""}, "\n"), b.String())
+}
+
+func Test_streamTee(t *testing.T) {
+	var b bytes.Buffer
+	r, w, err := os.Pipe()
+	require.Nil(t, err)
+	raw := &closeRecorder{}
+	tee := newCombinedLog(&b).tee("stdout", r, raw)
+	done := make(chan struct{})
+	go func() {
+		tee.copy()
+		close(done)
+	}()
+
+	w.Write([]byte("first\nunterminated"))
+	w.Close()
+	<-done
+	<-tee.drained
+	require.Equal(t, "first\nunterminated", raw.String())
+	require.True(t, raw.closed, "the file of the stream is closed once the pipe is")
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	require.Len(t, lines, 2, b.String())
+	require.True(t, strings.HasSuffix(lines[0], " first"), lines[0])
+	require.True(t, strings.HasSuffix(lines[1], " unterminated"), "the last line is recorded once the pipe is closed")
+}
+
+func Test_streamTee_drain(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.Nil(t, err)
+	defer w.Close()
+	raw := &closeRecorder{}
+	tee := newCombinedLog(io.Discard).tee("stdout", r, raw)
+	go tee.copy()
+
+	// A background process keeps the pipe open, what was written is copied nonetheless
+	w.Write([]byte("written\n"))
+	start := time.Now()
+	tee.drain()
+	require.True(t, time.Since(start) < pipeDrainTimeout, "the pipe is drained once empty")
+	require.Equal(t, "written\n", raw.String())
+
+	// and what is written later still is
+	w.Write([]byte("later\n"))
+	require.Eventually(t, func() bool { return raw.String() == "written\nlater\n" }, time.Second, 10*time.Millisecond)
+}
+
+// closeRecorder is a file of a stream which can be read while it is written.
+type closeRecorder struct {
+	mu     sync.Mutex
+	b      bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.b.Write(b)
+}
+
+func (c *closeRecorder) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.b.String()
+}
+
+func (c *closeRecorder) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func TestExecCmdInDir_combinedLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	err, _ = ExecCmdInDir(testContext, "/bin/echo '1:out'; /bin/echo '1:err'>&2; /bin/echo '2:out'", dir, &testHandlerSettings, Options{})
+	require.Nil(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "output.log"))
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	require.Equal(t, 3, len(lines), "%s", b)
+
+	// The lines written right after each other to different streams can be read in any order
+	var stdoutLines, stderrLines []string
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 3)
+		require.Equal(t, 3, len(fields), line)
+		_, err := time.Parse(combinedLogTimeFormat, fields[1])
+		require.Nil(t, err, line)
+		if fields[0] == "stdout" {
+			stdoutLines = append(stdoutLines, fields[2])
+		} else {
+			stderrLines = append(stderrLines, fields[2])
+		}
+	}
+	require.Equal(t, []string{"1:out", "2:out"}, stdoutLines)
+	require.Equal(t, []string{"1:err"}, stderrLines)
+
+	fi, err := os.Stat(filepath.Join(dir, "output.log"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600).String(), fi.Mode().String())
+}
+
+func TestExecCmdInDir_combinedLogOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// The lines are written to both streams less than the 50ms the output files were once polled at apart, they
+	// are recorded in the order they were written
+	err, _ = ExecCmdInDir(testContext, "/bin/echo 'a'; sleep 0.03; /bin/echo 'b' >&2; sleep 0.03; /bin/echo 'c'; sleep 0.03; /bin/echo 'd' >&2", dir, &testHandlerSettings, Options{})
+	require.Nil(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "output.log"))
+	require.Nil(t, err)
+	var records []string
+	var previous time.Time
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		require.Equal(t, 3, len(fields), line)
+		at, err := time.Parse(combinedLogTimeFormat, fields[1])
+		require.Nil(t, err, line)
+		require.False(t, at.Before(previous), "%s", b)
+		previous = at
+		records = append(records, fields[0]+" "+fields[2])
+	}
+	require.Equal(t, []string{"stdout a", "stderr b", "stdout c", "stderr d"}, records)
+}
+
+func TestExecCmdInDir_backgroundProcessKeepsPipesOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	err, exitCode := ExecCmdInDir(testContext, "/bin/echo 'started'; sleep 30 &", dir, &testHandlerSettings, Options{})
+	require.Nil(t, err)
+	require.EqualValues(t, 0, exitCode)
+	require.True(t, time.Since(start) < 20*time.Second, "must not wait for background processes")
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+	require.Equal(t, "started\n", string(b))
+}
+
+func TestExecCmdInDir_backgroundProcessKeepsOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	err, _ = ExecCmdInDir(testContext, "/bin/echo 'started'; (sleep 7; /bin/echo 'late'; /bin/echo 'late error' >&2) &", dir, &testHandlerSettings, Options{})
+	require.Nil(t, err)
+	require.True(t, time.Since(start) < 5*time.Second, "must not wait for background processes")
+
+	// The output of the background process is copied after the script exited
+	time.Sleep(10 * time.Second)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+	require.Equal(t, "started\nlate\n", string(b))
+	b, err = ioutil.ReadFile(filepath.Join(dir, "stderr"))
+	require.Nil(t, err)
+	require.Equal(t, "late error\n", string(b))
+}
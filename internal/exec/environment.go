@@ -0,0 +1,125 @@
+package exec
+
+import (
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+)
+
+// Environment variables describing the execution to the script.
+const (
+	RunCommandNameEnvName     = "RC_RUN_COMMAND_NAME"
+	SequenceNumberEnvName     = "RC_SEQUENCE_NUMBER"
+	WorkingDirectoryEnvName   = "RC_WORKING_DIRECTORY"
+	ArtifactsDirectoryEnvName = "RC_ARTIFACTS_DIRECTORY"
+	AttemptEnvName            = "RC_ATTEMPT"
+)
+
+// Values of the baseline environment
+const (
+	baselinePath  = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	baselineLang  = "C.UTF-8"
+	baselineShell = "/bin/bash"
+)
+
+// Variables of the handler kept in the baseline environment, so the script uses the same time zone and proxies.
+var passedThroughVariables = []string{"TZ", "http_proxy", "https_proxy", "no_proxy", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"}
+
+// scriptEnvironment returns the environment of the script executed in workdir: the baseline environment of the
+// current user and the named parameters, or the environment of the handler with inheritEnvironment, followed by
+// the environment of the settings and the RC_* variables.
+func scriptEnvironment(cfg *handlersettings.HandlerSettings, workdir string, opts Options) []string {
+	var env []string
+	if cfg.PublicSettings.InheritEnvironment {
+		// The named parameters are already part of it, see SetEnvironmentVariables
+		env = os.Environ()
+	} else {
+		env = append(baselineEnvironment(""), parameterVariables(cfg)...)
+	}
+	env = append(env, settingsVariables(cfg)...)
+	return append(env, runCommandVariables(workdir, opts)...)
+}
+
+// runAsEnvironment returns the variables set for a script executed as the RunAs user. sudo resets the
+// environment, so the environment of the handler and the parameters are not part of it.
+func runAsEnvironment(cfg *handlersettings.HandlerSettings, workdir string, opts Options) []string {
+	var env []string
+	if !cfg.PublicSettings.InheritEnvironment {
+		env = baselineEnvironment(cfg.PublicSettings.RunAsUser)
+	}
+	env = append(env, settingsVariables(cfg)...)
+	return append(env, runCommandVariables(workdir, opts)...)
+}
+
+// baselineEnvironment returns a minimal environment for the specified user, or the current user if empty.
+func baselineEnvironment(username string) []string {
+	home := "/"
+	u, err := user.Current()
+	if username != "" {
+		u, err = user.Lookup(username)
+	}
+	if err == nil {
+		username = u.Username
+		if u.HomeDir != "" {
+			home = u.HomeDir
+		}
+	}
+
+	env := []string{
+		"PATH=" + baselinePath,
+		"LANG=" + baselineLang,
+		"HOME=" + home,
+		"SHELL=" + baselineShell,
+	}
+	if username != "" {
+		env = append(env, "USER="+username, "LOGNAME="+username)
+	}
+	for _, name := range passedThroughVariables {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// parameterVariables returns the variables of the named parameters, as exported by SetEnvironmentVariables.
+func parameterVariables(cfg *handlersettings.HandlerSettings) []string {
+	var env []string
+	for _, p := range exportedParameters(cfg) {
+		if value := p.EffectiveValue(); p.Name != "" && value != "" {
+			env = append(env, p.Name+"="+value)
+		}
+	}
+	return env
+}
+
+// settingsVariables returns the variables of the environment of the settings, sorted by name.
+func settingsVariables(cfg *handlersettings.HandlerSettings) []string {
+	env := make([]string, 0, len(cfg.PublicSettings.Environment))
+	for name, value := range cfg.PublicSettings.Environment {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// runCommandVariables returns the RC_* variables describing the execution. The artifacts are downloaded
+// to the working directory.
+func runCommandVariables(workdir string, opts Options) []string {
+	env := []string{
+		WorkingDirectoryEnvName + "=" + workdir,
+		ArtifactsDirectoryEnvName + "=" + workdir,
+	}
+	if opts.RunCommandName != "" {
+		env = append(env,
+			RunCommandNameEnvName+"="+opts.RunCommandName,
+			SequenceNumberEnvName+"="+strconv.Itoa(opts.SequenceNumber))
+	}
+	if opts.Attempt > 0 {
+		env = append(env, AttemptEnvName+"="+strconv.Itoa(opts.Attempt))
+	}
+	return env
+}
@@ -0,0 +1,87 @@
+package exec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/stretchr/testify/require"
+)
+
+// execEnvironment executes env with the specified settings and returns the variables of the script.
+func execEnvironment(t *testing.T, cfg *handlersettings.HandlerSettings, opts Options) map[string]string {
+	dir := t.TempDir()
+	err, _ := ExecCmdInDir(testContext, "env", dir, cfg, opts)
+	require.Nil(t, err)
+	b, err := os.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+
+	env := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		name, value, _ := strings.Cut(line, "=")
+		env[name] = value
+	}
+	return env
+}
+
+func TestExecCmdInDir_baselineEnvironment(t *testing.T) {
+	t.Setenv("ConfigSequenceNumber", "3")
+	t.Setenv("AZURE_GUEST_AGENT_EXTENSION_VERSION", "1.3.0")
+	t.Setenv("TZ", "UTC")
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		Parameters:  []handlersettings.ParameterDefinition{{Name: "NAMED_PARAMETER", Value: "value"}},
+		Environment: map[string]string{"MY_VARIABLE": "a b", "PATH": "/opt/tools/bin:/usr/bin:/bin"},
+	}}
+
+	env := execEnvironment(t, &cfg, Options{Attempt: 2, RunCommandName: "myRunCommand", SequenceNumber: 5})
+	require.NotContains(t, env, "ConfigSequenceNumber")
+	require.NotContains(t, env, "AZURE_GUEST_AGENT_EXTENSION_VERSION")
+	require.Equal(t, "UTC", env["TZ"])
+	require.Equal(t, baselineLang, env["LANG"])
+	require.NotEmpty(t, env["HOME"])
+	require.Equal(t, "value", env["NAMED_PARAMETER"])
+	require.Equal(t, "a b", env["MY_VARIABLE"])
+	require.Equal(t, "/opt/tools/bin:/usr/bin:/bin", env["PATH"], "the environment of the settings overrides the baseline")
+
+	require.Equal(t, "myRunCommand", env[RunCommandNameEnvName])
+	require.Equal(t, "5", env[SequenceNumberEnvName])
+	require.Equal(t, "2", env[AttemptEnvName])
+	require.NotEmpty(t, env[WorkingDirectoryEnvName])
+	require.Equal(t, env[WorkingDirectoryEnvName], env[ArtifactsDirectoryEnvName])
+	require.NotEmpty(t, env[ResultFileEnvName])
+}
+
+func TestExecCmdInDir_inheritEnvironment(t *testing.T) {
+	t.Setenv("ConfigSequenceNumber", "3")
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		InheritEnvironment: true,
+		Environment:        map[string]string{"MY_VARIABLE": "value"},
+	}}
+
+	env := execEnvironment(t, &cfg, Options{Attempt: 1, RunCommandName: "myRunCommand"})
+	require.Equal(t, "3", env["ConfigSequenceNumber"])
+	require.Equal(t, os.Getenv("PATH"), env["PATH"])
+	require.Equal(t, "value", env["MY_VARIABLE"])
+	require.Equal(t, "myRunCommand", env[RunCommandNameEnvName])
+	require.Equal(t, "0", env[SequenceNumberEnvName])
+}
+
+func Test_runAsEnvironment(t *testing.T) {
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		RunAsUser:   "root",
+		Parameters:  []handlersettings.ParameterDefinition{{Name: "NAMED_PARAMETER", Value: "value"}},
+		Environment: map[string]string{"B": "2", "A": "1"},
+	}}
+
+	env := runAsEnvironment(&cfg, "/work", Options{Attempt: 1})
+	require.Contains(t, env, "HOME=/root")
+	require.Contains(t, env, "USER=root")
+	require.NotContains(t, env, "NAMED_PARAMETER=value")
+	require.Equal(t, []string{"A=1", "B=2", "RC_WORKING_DIRECTORY=/work", "RC_ARTIFACTS_DIRECTORY=/work", "RC_ATTEMPT=1"}, env[len(env)-5:])
+
+	cfg.PublicSettings.InheritEnvironment = true
+	env = runAsEnvironment(&cfg, "/work", Options{})
+	require.Equal(t, []string{"A=1", "B=2", "RC_WORKING_DIRECTORY=/work", "RC_ARTIFACTS_DIRECTORY=/work"}, env)
+}
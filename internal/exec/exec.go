@@ -10,21 +10,42 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/errorcatalog"
 	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/pkg/shellutil"
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 )
 
+// ScriptExitError is returned when the script ran and terminated with a non-zero exit code,
+// or was killed by a signal (exit code -1), e.g. when it timed out.
+type ScriptExitError struct {
+	ExitCode int
+	Signaled bool
+}
+
+func (e *ScriptExitError) Error() string {
+	return fmt.Sprintf("command terminated with exit status=%d", e.ExitCode)
+}
+
 // Exec runs the given cmd in /bin/sh, saves its stdout/stderr streams to
 // the specified files. It waits until the execution terminates.
 //
 // On error, an exit code may be returned if it is an exit code error.
 // Given stdout and stderr will be closed upon returning.
 func Exec(ctx *log.Context, cmd, workdir string, stdout, stderr io.WriteCloser, cfg *handlersettings.HandlerSettings) (int, error) {
+	return execute(ctx, cmd, workdir, stdout, stderr, cfg, timeoutDeadline(cfg), Options{}, false)
+}
+
+// execute implements Exec. The script is killed at the deadline, unless it is zero. If shareFiles is set,
+// the files the script can write its result to are created and their paths are exported to the script,
+// see prepareScriptFiles. The environment of the script is described by opts, see scriptEnvironment.
+func execute(ctx *log.Context, cmd, workdir string, stdout, stderr io.WriteCloser, cfg *handlersettings.HandlerSettings, deadline time.Time, opts Options, shareFiles bool) (int, error) {
 	defer stdout.Close()
 	defer stderr.Close()
 
@@ -32,18 +53,22 @@ func Exec(ctx *log.Context, cmd, workdir string, stdout, stderr io.WriteCloser,
 
 	commandArgs, err := SetEnvironmentVariables(cfg)
 	// Add command args if any. Unnamed arguments go in 'commandArgs'. Named arguments are set as environment variables so the'd be available within the script.
-	cmd = cmd + commandArgs
+	cmd = shellCommand(ctx, cfg, scriptPath, commandArgs)
 
 	exitCode := constants.ExitCode_Okay
 
+	// Directory of the files shared with the script. It has to be writable by the user running the script.
+	scriptFilesDir, scriptFilesOwner := workdir, -1
+	runAsCmd := ""
+
 	if cfg.PublicSettings.RunAsUser != "" {
 		ctx.Log("message", "RunAsUser is "+cfg.PublicSettings.RunAsUser)
 
 		// Check prefix ("/var/lib/waagent/run-command-handler") exists in script path for ex. /var/lib/waagent/run-command-handler/download/<runcommandName>/0/script.sh
 		if !strings.HasPrefix(scriptPath, constants.DataDir) {
-			errMessage := "Failed to determine RunAs script path. Contact ICM team AzureRT\\Extensions for this service error."
+			errMessage := "Failed to determine RunAs script path"
 			ctx.Log("message", errMessage)
-			return constants.ExitCode_RunAsIncorrectScriptPath, errors.New(errMessage)
+			return constants.ExitCode_RunAsIncorrectScriptPath, errorcatalog.RunAsIncorrectScriptPath.New(errMessage)
 		}
 
 		// Gets suffix "download/<runcommandName>/0/script.sh"
@@ -59,22 +84,24 @@ func Exec(ctx *log.Context, cmd, workdir string, stdout, stderr io.WriteCloser,
 		// Get reference to source script by opening it
 		sourceScriptFile, sourceScriptFileOpenError := os.OpenFile(scriptPath, os.O_RDONLY, 0400)
 		if sourceScriptFileOpenError != nil {
-			errMessage := "Failed to open source script. Contact ICM team AzureRT\\Extensions for this service error."
+			errMessage := "Failed to open source script."
 			ctx.Log("message", errMessage+fmt.Sprintf(" Source script file is '%s'", scriptPath))
-			return constants.ExitCode_RunAsOpenSourceScriptFileFailed, errors.Wrapf(sourceScriptFileOpenError, errMessage)
+			return constants.ExitCode_RunAsOpenSourceScriptFileFailed, errorcatalog.RunAsOpenScriptFailed.Wrap(sourceScriptFileOpenError, "failed to open source script")
 		}
 
-		destScriptFile, destScriptCreateError := os.Create(runAsScriptFilePath)
+		// The script can contain the values of the protected parameters rendered by templating, it is only readable
+		// by the handler until it is given to the RunAs user
+		destScriptFile, destScriptCreateError := os.OpenFile(runAsScriptFilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 		if destScriptCreateError != nil {
-			errMessage := "Failed to create script for Run As in Run As directory. Contact ICM team AzureRT\\Extensions for this service error."
+			errMessage := "Failed to create script for Run As in Run As directory."
 			ctx.Log("message", errMessage+fmt.Sprintf(" Destination runAs script file is '%s'", runAsScriptFilePath))
-			return constants.ExitCode_RunAsCreateRunAsScriptFileFailed, errors.Wrapf(destScriptCreateError, errMessage)
+			return constants.ExitCode_RunAsCreateRunAsScriptFileFailed, errorcatalog.RunAsCreateScriptFailed.Wrap(destScriptCreateError, "failed to create script for Run As in Run As directory")
 		}
 		_, runAsScriptCopyError := io.Copy(destScriptFile, sourceScriptFile)
 		if runAsScriptCopyError != nil {
-			errMessage := fmt.Sprintf("Failed to copy script file '%s' to Run As path '%s'. Contact ICM team AzureRT\\Extensions for this service error.", scriptPath, runAsScriptFilePath)
+			errMessage := fmt.Sprintf("Failed to copy script file '%s' to Run As path '%s'", scriptPath, runAsScriptFilePath)
 			ctx.Log("message", errMessage)
-			return constants.ExitCode_RunAsCopySourceScriptToRunAsScriptFileFailed, errors.Wrapf(runAsScriptCopyError, errMessage)
+			return constants.ExitCode_RunAsCopySourceScriptToRunAsScriptFileFailed, errorcatalog.RunAsCopyScriptFailed.Wrap(runAsScriptCopyError, errMessage)
 		}
 		sourceScriptFile.Close()
 		destScriptFile.Close()
@@ -82,52 +109,110 @@ func Exec(ctx *log.Context, cmd, workdir string, stdout, stderr io.WriteCloser,
 		// Provide read and execute permissions to RunAsUser on .sh file at runAsScriptFilePath
 		lookedUpUser, lookupUserError := user.Lookup(cfg.PublicSettings.RunAsUser)
 		if lookupUserError != nil {
-			errMessage := fmt.Sprintf("Failed to lookup RunAs user '%s'. Looks like user does not exist. For RunAs to work properly, contact admin of VM and make sure RunAs user is added on the VM and user has access to resources accessed by the Run Command (Directories, Files, Network etc.). Refer: https://aka.ms/RunCommandManagedLinux", cfg.PublicSettings.RunAsUser)
+			errMessage := fmt.Sprintf("Failed to lookup RunAs user '%s'", cfg.PublicSettings.RunAsUser)
 			ctx.Log("message", errMessage)
-			return constants.ExitCode_RunAsLookupUserFailed, errors.Wrapf(lookupUserError, errMessage)
+			return constants.ExitCode_RunAsLookupUserFailed, errorcatalog.RunAsUserNotFound.Wrap(lookupUserError, errMessage)
 		}
 
 		lookedUpUserUid, lookedUpUserUidErr := strconv.Atoi(lookedUpUser.Uid)
 		if lookedUpUserUidErr != nil {
-			errMessage := "Failed to determine RunAs user's Uid and Guid . Contact ICM team AzureRT\\Extensions for this service error."
+			errMessage := "Failed to determine RunAs user's Uid and Guid"
 			ctx.Log("message", errMessage)
-			return constants.ExitCode_RunAsLookupUserUidFailed, errors.Wrapf(lookedUpUserUidErr, errMessage)
+			return constants.ExitCode_RunAsLookupUserUidFailed, errorcatalog.RunAsLookupUIDFailed.Wrap(lookedUpUserUidErr, errMessage)
 		}
 
 		runAsScriptChownError := os.Chown(runAsScriptFilePath, lookedUpUserUid, os.Getegid())
 		if runAsScriptChownError != nil {
-			errMessage := fmt.Sprintf("Failed to change owner of file '%s' to RunAs user '%s'. Contact ICM team AzureRT\\Extensions for this service error.", runAsScriptFilePath, cfg.PublicSettings.RunAsUser)
+			errMessage := fmt.Sprintf("Failed to change owner of file '%s' to RunAs user '%s'", runAsScriptFilePath, cfg.PublicSettings.RunAsUser)
 			ctx.Log("message", errMessage)
-			return constants.ExitCode_RunAsScriptFileChangeOwnerFailed, errors.Wrapf(runAsScriptChownError, errMessage)
+			return constants.ExitCode_RunAsScriptFileChangeOwnerFailed, errorcatalog.RunAsChownScriptFailed.Wrap(runAsScriptChownError, errMessage)
 		}
 
 		runAsScriptChmodError := os.Chmod(runAsScriptFilePath, 0550)
 		if runAsScriptChmodError != nil {
-			errMessage := fmt.Sprintf("Failed to change permissions to execute for file '%s' for RunAs user '%s'. Contact ICM team AzureRT\\Extensions for this service error.", runAsScriptFilePath, cfg.PublicSettings.RunAsUser)
+			errMessage := fmt.Sprintf("Failed to change permissions to execute for file '%s' for RunAs user '%s'", runAsScriptFilePath, cfg.PublicSettings.RunAsUser)
 			ctx.Log("message", errMessage)
-			return constants.ExitCode_RunAsScriptFileChangePermissionsFailed, errors.Wrapf(runAsScriptChmodError, errMessage)
+			return constants.ExitCode_RunAsScriptFileChangePermissionsFailed, errorcatalog.RunAsChmodScriptFailed.Wrap(runAsScriptChmodError, errMessage)
+		}
+
+		scriptFilesDir, scriptFilesOwner = runAsScriptDirectoryPath, lookedUpUserUid
+		runAsCmd = runAsShellCommand(cfg, shellCommand(ctx, cfg, runAsScriptFilePath, commandArgs))
+	}
+
+	var scriptEnv []string
+	if shareFiles {
+		scriptEnv, err = prepareScriptFiles(scriptFilesDir, scriptFilesOwner)
+		if err == nil {
+			var parametersEnv string
+			parametersEnv, err = writeParametersFile(scriptFilesDir, scriptFilesOwner, cfg)
+			scriptEnv = append(scriptEnv, parametersEnv)
+		}
+		if err != nil {
+			errMessage := "Failed to create the files shared with the script"
+			ctx.Log("message", errMessage, "error", err)
+			return constants.ExitCode_CreateScriptFilesFailed, errorcatalog.CreateScriptFilesFailed.Wrap(err, errMessage)
+		}
+	}
+
+	if cfg.PublicSettings.ProtectedParametersInFile {
+		secrets, err := writeProtectedParametersFile(cfg, scriptFilesOwner)
+		if err != nil {
+			errMessage := "Failed to create the protected parameters file."
+			ctx.Log("message", errMessage, "error", err)
+			return constants.ExitCode_CreateScriptFilesFailed, errorcatalog.CreateScriptFilesFailed.Wrap(err, "failed to create the protected parameters file")
 		}
+		defer secrets.remove(ctx)
+		scriptEnv = append(scriptEnv, secrets.env())
+	}
+
+	if cfg.PublicSettings.RunAsUser != "" {
+		// sudo resets the environment, the variables of the script are set again for the RunAs user with env.
+		runAsEnv := append(runAsEnvironment(cfg, workdir, opts), scriptEnv...)
+		names := make([]string, len(runAsEnv))
+		for i, v := range runAsEnv {
+			names[i], _, _ = strings.Cut(v, "=")
+			runAsEnv[i] = shellutil.Quote(v)
+		}
+		runAsCmd = "env " + strings.Join(runAsEnv, " ") + " " + runAsCmd
+
+		// sudo -S reads the RunAsPassword from stdin instead of prompting it interactively and blocking, see command.Stdin.
+		// sudo -S -u <cfg.publicSettings.RunAsUser> [env <variables>] <command>
+		cmd = fmt.Sprintf("sudo -S -u %s %s", cfg.PublicSettings.RunAsUser, runAsCmd)
+		ctx.Log("message", "executing the script with sudo", "runAsUser", cfg.PublicSettings.RunAsUser, "variables", strings.Join(names, ","))
+	}
 
-		// echo pipes the RunAsPassword to sudo -S for RunAsUser instead of prompting the password interactively from user and blocking.
-		// echo <cfg.protectedSettings.RunAsPassword> | sudo -S -u <cfg.publicSettings.RunAsUser> <command>
-		cmd = fmt.Sprintf("echo %s | sudo -S -u %s %s", cfg.ProtectedSettings.RunAsPassword, cfg.PublicSettings.RunAsUser, runAsScriptFilePath+commandArgs)
-		ctx.Log("message", "RunAs cmd is "+cmd)
+	// The shell started as the RunAs user applies the shell settings, see runAsShellCommand
+	args := shellArgs(cfg, cmd)
+	if cfg.PublicSettings.RunAsUser != "" {
+		args = []string{"-c", cmd}
 	}
 
 	var command *exec.Cmd
-	if cfg.PublicSettings.TimeoutInSeconds > 0 {
-		commandContext, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.PublicSettings.TimeoutInSeconds)*time.Second)
+	if !deadline.IsZero() {
+		commandContext, cancel := context.WithDeadline(context.Background(), deadline)
 		defer cancel()
-		command = exec.CommandContext(commandContext, "/bin/bash", "-c", cmd)
-		ctx.Log("message", "Execute with TimeoutInSeconds="+strconv.Itoa(cfg.PublicSettings.TimeoutInSeconds))
+		command = exec.CommandContext(commandContext, shellPath, args...)
+		ctx.Log("message", "Execute with TimeoutInSeconds="+strconv.Itoa(cfg.PublicSettings.TimeoutInSeconds), "deadline", deadline.UTC().Format(time.RFC3339))
 	} else {
-		command = exec.Command("/bin/bash", "-c", cmd)
+		command = exec.Command(shellPath, args...)
 	}
 
 	command.Dir = workdir
+	command.Env = append(scriptEnvironment(cfg, workdir, opts), scriptEnv...)
 	command.Stdout = stdout
 	command.Stderr = stderr
+	if cfg.PublicSettings.RunAsUser != "" {
+		// The password is not part of the command line, it would be visible to every user of the VM
+		command.Stdin = strings.NewReader(cfg.ProtectedSettings.RunAsPassword + "\n")
+	}
 	err = command.Run()
+
+	if shareFiles {
+		if collectErr := collectScriptFiles(scriptFilesDir, workdir); collectErr != nil {
+			ctx.Log("message", "failed to collect the files written by the script", "error", collectErr)
+		}
+	}
+
 	if err != nil {
 		exitErr, ok := err.(*exec.ExitError)
 		if ok {
@@ -136,7 +221,7 @@ func Exec(ctx *log.Context, cmd, workdir string, stdout, stderr io.WriteCloser,
 				if status.Signaled() { // Timed out
 					ctx.Log("message", "Timeout:"+err.Error())
 				}
-				return exitCode, fmt.Errorf("command terminated with exit status=%d", exitCode)
+				return exitCode, &ScriptExitError{ExitCode: exitCode, Signaled: status.Signaled()}
 			}
 		}
 	}
@@ -147,17 +232,11 @@ func Exec(ctx *log.Context, cmd, workdir string, stdout, stderr io.WriteCloser,
 func SetEnvironmentVariables(cfg *handlersettings.HandlerSettings) (string, error) {
 	var err error
 	commandArgs := ""
-	parameters := []handlersettings.ParameterDefinition{}
-	if cfg.PublicSettings.Parameters != nil && len(cfg.PublicSettings.Parameters) > 0 {
-		parameters = cfg.PublicSettings.Parameters
-	}
-	if cfg.ProtectedSettings.ProtectedParameters != nil && len(cfg.ProtectedSettings.ProtectedParameters) > 0 {
-		parameters = append(parameters, cfg.ProtectedSettings.ProtectedParameters...)
-	}
+	parameters := exportedParameters(cfg)
 
 	for i := 0; i < len(parameters); i++ {
 		name := parameters[i].Name
-		value := parameters[i].Value
+		value := parameters[i].EffectiveValue()
 		if value != "" {
 			if name != "" { // Named parameters are set as environmental setting
 				err = os.Setenv(name, value)
@@ -170,29 +249,165 @@ func SetEnvironmentVariables(cfg *handlersettings.HandlerSettings) (string, erro
 	return commandArgs, err // Return command args and the last error if any
 }
 
+// exportedParameters returns the parameters passed to the script as environment variables or arguments.
+func exportedParameters(cfg *handlersettings.HandlerSettings) []handlersettings.ParameterDefinition {
+	parameters := []handlersettings.ParameterDefinition{}
+	if cfg.PublicSettings.Parameters != nil && len(cfg.PublicSettings.Parameters) > 0 {
+		parameters = cfg.PublicSettings.Parameters
+	}
+	// Protected parameters delivered in a file are never exported, see writeProtectedParametersFile
+	if cfg.ProtectedSettings.ProtectedParameters != nil && len(cfg.ProtectedSettings.ProtectedParameters) > 0 && !cfg.PublicSettings.ProtectedParametersInFile {
+		parameters = append(parameters, cfg.ProtectedSettings.ProtectedParameters...)
+	}
+	return parameters
+}
+
+// Options of an execution with ExecCmdInDir.
+type Options struct {
+	// Attempt is the number of the execution, starting at 1
+	Attempt int
+
+	// Name and sequence number of the run command, exported to the script with the RC_* variables
+	RunCommandName string
+	SequenceNumber int
+
+	// Append keeps the output of the previous executions in the output directory. The
+	// output of this execution follows Marker, if specified.
+	Append bool
+	Marker string
+
+	// Deadline after which the script is killed. When zero, the script is killed after
+	// the timeout of the settings, if any.
+	Deadline time.Time
+}
+
 // ExecCmdInDir executes the given command in given directory and saves output
 // to ./stdout and ./stderr files (truncates files if exists, creates them if not
-// with 0600/-rw------- permissions).
+// with 0600/-rw------- permissions). Lines of both streams are also recorded in
+// the order they were written, with their timestamp, to the combined ./output.log
+// file. The output of background processes started by the script is still copied
+// once the script exited, until they close their output. The result the
+// script writes to the file at $RC_RESULT_FILE is saved to ./result.json, and the
+// progress it reports is appended to the file at $RC_PROGRESS_FILE.
 //
 // Ideally, we execute commands only once per sequence number in run-command-handler,
 // and save their output under /var/lib/waagent/<dir>/download/<seqnum>/*.
-func ExecCmdInDir(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings) (error, int) {
+func ExecCmdInDir(ctx *log.Context, scriptFilePath, workdir string, cfg *handlersettings.HandlerSettings, opts Options) (error, int) {
 
 	stdoutFileName, stderrFileName := LogPaths(workdir)
 
-	outF, err := os.OpenFile(stdoutFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	flags := os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+	if opts.Append {
+		flags = os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	}
+
+	outF, err := os.OpenFile(stdoutFileName, flags, 0600)
 	if err != nil {
 		return errors.Wrapf(err, "failed to open stdout file"), constants.ExitCode_OpenStdOutFileFailed
 	}
-	errF, err := os.OpenFile(stderrFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	errF, err := os.OpenFile(stderrFileName, flags, 0600)
 	if err != nil {
 		return errors.Wrapf(err, "failed to open stderr file"), constants.ExitCode_OpenStdErrFileFailed
 	}
 
-	exitCode, err := Exec(ctx, scriptFilePath, workdir, outF, errF, cfg)
+	deadline := opts.Deadline
+	if deadline.IsZero() {
+		deadline = timeoutDeadline(cfg)
+	}
+
+	// The combined log is only a convenience for troubleshooting. Run the script even if it cannot be created.
+	combinedF, err := os.OpenFile(CombinedLogPath(workdir), flags, 0600)
+	if err != nil {
+		ctx.Log("message", "failed to open combined output log", "error", err)
+		writeMarker(opts, outF, errF)
+		exitCode, err := execute(ctx, scriptFilePath, workdir, outF, errF, cfg, deadline, opts, true)
+		return err, exitCode
+	}
+
+	// The script writes to pipes, their output is copied to the files of the streams and recorded into the
+	// combined log as it is written
+	stdout, stderr, tees, err := newCombinedLog(combinedF).teeStreams(outF, errF)
+	if err != nil {
+		ctx.Log("message", "failed to create the pipes of the combined output log", "error", err)
+		combinedF.Close()
+		writeMarker(opts, outF, errF)
+		exitCode, err := execute(ctx, scriptFilePath, workdir, outF, errF, cfg, deadline, opts, true)
+		return err, exitCode
+	}
+	go func() {
+		tees.wait()
+		combinedF.Close()
+	}()
+
+	writeMarker(opts, stdout, stderr)
+	exitCode, err := execute(ctx, scriptFilePath, workdir, stdout, stderr, cfg, deadline, opts, true)
+	tees.drain()
 	return err, exitCode
 }
 
+// streamTees are the copies of the pipes of both streams.
+type streamTees struct {
+	tees []*streamTee
+	wg   sync.WaitGroup
+}
+
+// teeStreams returns the pipes the script writes its streams to. They are copied to outF and errF and recorded into
+// the combined log until every process writing to them closed them, background processes started by the script
+// included, so their output is kept once the script exited. outF and errF are closed then.
+func (l *combinedLog) teeStreams(outF, errF *os.File) (stdout, stderr *os.File, tees *streamTees, err error) {
+	stdoutR, stdout, err := os.Pipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stderrR, stderr, err := os.Pipe()
+	if err != nil {
+		stdoutR.Close()
+		stdout.Close()
+		return nil, nil, nil, err
+	}
+
+	tees = &streamTees{tees: []*streamTee{l.tee("stdout", stdoutR, outF), l.tee("stderr", stderrR, errF)}}
+	for _, t := range tees.tees {
+		tees.wg.Add(1)
+		go func(t *streamTee) {
+			defer tees.wg.Done()
+			t.copy()
+		}(t)
+	}
+	return stdout, stderr, tees, nil
+}
+
+// drain returns once the output written before the script exited is copied, see streamTee.drain.
+func (t *streamTees) drain() {
+	for _, tee := range t.tees {
+		tee.drain()
+	}
+}
+
+// wait returns once every process writing to the pipes closed them and their output is copied.
+func (t *streamTees) wait() {
+	t.wg.Wait()
+}
+
+// writeMarker separates the output of the execution from the output of the previous executions.
+func writeMarker(opts Options, streams ...io.Writer) {
+	if !opts.Append || opts.Marker == "" {
+		return
+	}
+	for _, w := range streams {
+		fmt.Fprintln(w, opts.Marker)
+	}
+}
+
+// timeoutDeadline returns when the script has to be killed according to the timeout of the
+// settings, or a zero time if there is no timeout.
+func timeoutDeadline(cfg *handlersettings.HandlerSettings) time.Time {
+	if cfg.PublicSettings.TimeoutInSeconds <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(cfg.PublicSettings.TimeoutInSeconds) * time.Second)
+}
+
 // LogPaths returns stdout and stderr file paths for the specified output
 // directory. It does not create the files.
 func LogPaths(dir string) (stdout string, stderr string) {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Azure/run-command-handler-linux/internal/constants"
 	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
@@ -92,6 +93,23 @@ func TestExec_SetEnvironmentVariables(t *testing.T) {
 	require.Equal(t, "value2", os.Getenv("Variable2"))
 }
 
+func TestExec_SetEnvironmentVariables_defaults(t *testing.T) {
+	cfg := handlersettings.HandlerSettings{
+		PublicSettings: handlersettings.PublicSettings{
+			Parameters: []handlersettings.ParameterDefinition{
+				{Name: "Variable3", Default: "default3"},
+				{Name: "Variable4", Value: "value4", Default: "default4"},
+				{Default: "arg3"},
+			},
+		},
+	}
+	commandArgs, err := SetEnvironmentVariables(&cfg)
+	require.Nil(t, err)
+	require.Equal(t, " arg3", commandArgs)
+	require.Equal(t, "default3", os.Getenv("Variable3"))
+	require.Equal(t, "value4", os.Getenv("Variable4"))
+}
+
 func TestExec_failure_genericError(t *testing.T) {
 	_, err := Exec(testContext, "date", "/non-existing-path", new(mockFile), new(mockFile), &testHandlerSettings)
 	require.NotNil(t, err)
@@ -125,7 +143,7 @@ func TestExecCmdInDir(t *testing.T) {
 	require.Nil(t, err)
 	defer os.RemoveAll(dir)
 
-	err, exitCode := ExecCmdInDir(testContext, "/bin/echo 'Hello world'", dir, &testHandlerSettings)
+	err, exitCode := ExecCmdInDir(testContext, "/bin/echo 'Hello world'", dir, &testHandlerSettings, Options{})
 	require.Nil(t, err)
 	require.True(t, fileExists(t, filepath.Join(dir, "stdout")), "stdout file should be created")
 	require.True(t, fileExists(t, filepath.Join(dir, "stderr")), "stderr file should be created")
@@ -141,7 +159,7 @@ func TestExecCmdInDir(t *testing.T) {
 }
 
 func TestExecCmdInDir_cantOpenError(t *testing.T) {
-	err, exitCode := ExecCmdInDir(testContext, "/bin/echo 'Hello world'", "/non-existing-dir", &testHandlerSettings)
+	err, exitCode := ExecCmdInDir(testContext, "/bin/echo 'Hello world'", "/non-existing-dir", &testHandlerSettings, Options{})
 	require.Contains(t, err.Error(), "failed to open stdout file")
 	require.NotEqual(t, constants.ExitCode_Okay, exitCode)
 }
@@ -151,11 +169,11 @@ func TestExecCmdInDir_truncates(t *testing.T) {
 	require.Nil(t, err)
 	defer os.RemoveAll(dir)
 
-	err, exitCode := ExecCmdInDir(testContext, "/bin/echo '1:out'; /bin/echo '1:err'>&2", dir, &testHandlerSettings)
+	err, exitCode := ExecCmdInDir(testContext, "/bin/echo '1:out'; /bin/echo '1:err'>&2", dir, &testHandlerSettings, Options{})
 	require.Nil(t, err)
 	require.Equal(t, constants.ExitCode_Okay, exitCode)
 
-	err, exitCode = ExecCmdInDir(testContext, "/bin/echo '2:out'; /bin/echo '2:err'>&2", dir, &testHandlerSettings)
+	err, exitCode = ExecCmdInDir(testContext, "/bin/echo '2:out'; /bin/echo '2:err'>&2", dir, &testHandlerSettings, Options{})
 	require.Nil(t, err)
 	require.Equal(t, constants.ExitCode_Okay, exitCode)
 
@@ -204,3 +222,32 @@ func fileExists(t *testing.T, path string) bool {
 	t.Fatalf("failed to check if %s exists: %v", path, err)
 	return false
 }
+
+func TestExecCmdInDir_retryAppendsOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	err, _ = ExecCmdInDir(testContext, "/bin/echo '1:out'; /bin/echo '1:err'>&2; exit 3", dir, &testHandlerSettings, Options{Attempt: 1})
+	require.NotNil(t, err)
+	err, _ = ExecCmdInDir(testContext, "/bin/echo '2:out'; /bin/echo '2:err'>&2", dir, &testHandlerSettings, Options{Attempt: 2, Append: true, Marker: "[... attempt 2 ...]"})
+	require.Nil(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+	require.Equal(t, "1:out\n[... attempt 2 ...]\n2:out\n", string(b))
+
+	b, err = ioutil.ReadFile(filepath.Join(dir, "stderr"))
+	require.Nil(t, err)
+	require.Equal(t, "1:err\n[... attempt 2 ...]\n2:err\n", string(b))
+}
+
+func TestExecCmdInDir_deadline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	err, exitCode := ExecCmdInDir(testContext, "sleep 20", dir, &testHandlerSettings, Options{Deadline: time.Now().Add(time.Second)})
+	require.EqualError(t, err, "command terminated with exit status=-1")
+	require.EqualValues(t, -1, exitCode)
+}
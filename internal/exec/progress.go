@@ -0,0 +1,82 @@
+package exec
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxProgressLen is the maximum length of the progress reported in the execution message
+	maxProgressLen = 256
+
+	// progressReadLen is how much of the end of the progress file is read to find the last line
+	progressReadLen = 4 * 1024
+)
+
+var (
+	progressPercentRegex = regexp.MustCompile(`^(\d{1,3})%?$`)
+	progressStepRegex    = regexp.MustCompile(`^(\d+)/(\d+)$`)
+)
+
+// ReadProgress returns the last progress line the script appended to the progress file in dir,
+// or an empty string if there is none yet. Lines start with either a percentage ("43" or "43%")
+// or a step ("3/7") followed by a message, e.g. "3/7 installing packages". Lines without a
+// percentage or a step are reported as is.
+func ReadProgress(dir string) (string, error) {
+	f, err := openScriptFile(dir, progressFileName)
+	if err != nil || f == nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := fi.Size() - progressReadLen
+	if offset < 0 {
+		offset = 0
+	}
+	b, err := io.ReadAll(io.NewSectionReader(f, offset, progressReadLen))
+	if err != nil {
+		return "", err
+	}
+
+	lines := bytes.Split(bytes.TrimRight(b, "\r\n"), []byte("\n"))
+	return formatProgress(string(lines[len(lines)-1])), nil
+}
+
+// formatProgress normalizes a progress line written by the script. Control characters are
+// dropped and the line is truncated so it cannot take over the execution message.
+func formatProgress(line string) string {
+	line = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, line)
+	line = strings.TrimSpace(line)
+
+	fields := strings.SplitN(line, " ", 2)
+	message := ""
+	if len(fields) == 2 {
+		message = strings.TrimSpace(fields[1])
+	}
+
+	if m := progressPercentRegex.FindStringSubmatch(fields[0]); m != nil {
+		if percent, _ := strconv.Atoi(m[1]); percent <= 100 {
+			line = strings.TrimSpace(m[1] + "% " + message)
+		}
+	} else if m := progressStepRegex.FindStringSubmatch(fields[0]); m != nil {
+		line = strings.TrimSpace(m[0] + " " + message)
+	}
+
+	if r := []rune(line); len(r) > maxProgressLen {
+		line = string(r[:maxProgressLen])
+	}
+	return line
+}
@@ -0,0 +1,72 @@
+package exec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_formatProgress(t *testing.T) {
+	cases := []struct{ in, out string }{
+		{"43 downloading", "43% downloading"},
+		{"43% downloading", "43% downloading"},
+		{"100%", "100%"},
+		{"3/7 installing packages", "3/7 installing packages"},
+		{"  3/7   installing packages  ", "3/7 installing packages"},
+		{"250 downloading", "250 downloading"}, // not a percentage
+		{"installing packages", "installing packages"},
+		{"50% \x1b[1mbold\x1b[0m", "50% [1mbold[0m"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.out, formatProgress(c.in), "in=%q", c.in)
+	}
+
+	require.Equal(t, maxProgressLen, len([]rune(formatProgress(strings.Repeat("é", 1000)))))
+}
+
+func Test_readProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// no progress file
+	progress, err := ReadProgress(dir)
+	require.Nil(t, err)
+	require.Equal(t, "", progress)
+
+	path := filepath.Join(dir, "progress.log")
+	require.Nil(t, ioutil.WriteFile(path, []byte("1/7 downloading\n2/7 extracting\n"), 0600))
+	progress, err = ReadProgress(dir)
+	require.Nil(t, err)
+	require.Equal(t, "2/7 extracting", progress)
+
+	// last line of a large file
+	require.Nil(t, ioutil.WriteFile(path, []byte(strings.Repeat("10 working\n", 1000)+"99 almost done"), 0600))
+	progress, err = ReadProgress(dir)
+	require.Nil(t, err)
+	require.Equal(t, "99% almost done", progress)
+
+	// links are not followed
+	require.Nil(t, os.Remove(path))
+	require.Nil(t, os.Symlink("/etc/hostname", path))
+	_, err = ReadProgress(dir)
+	require.NotNil(t, err)
+}
+
+func TestExecCmdInDir_progressFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	err, _ = ExecCmdInDir(testContext, `echo '1/2 first step' >> "$RC_PROGRESS_FILE"; echo '2/2 second step' >> "$RC_PROGRESS_FILE"`, dir, &testHandlerSettings, Options{})
+	require.Nil(t, err)
+
+	progress, err := ReadProgress(dir)
+	require.Nil(t, err)
+	require.Equal(t, "2/2 second step", progress)
+}
@@ -0,0 +1,171 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/Azure/run-command-handler-linux/internal/constants"
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ResultFileEnvName is the environment variable holding the path of the file where the
+	// script can write its result as a JSON object.
+	ResultFileEnvName = "RC_RESULT_FILE"
+
+	// ProgressFileEnvName is the environment variable holding the path of the file where the
+	// script can append progress lines while it runs, see ReadProgress.
+	ProgressFileEnvName = "RC_PROGRESS_FILE"
+
+	// ParametersFileEnvName is the environment variable holding the path of the JSON object mapping the
+	// names of the parameters to their values, as JSON values of their types. Protected parameters and
+	// unnamed parameters are not included.
+	ParametersFileEnvName = "RC_PARAMETERS_FILE"
+
+	resultFileName     = "result.json"
+	progressFileName   = "progress.log"
+	parametersFileName = "parameters.json"
+
+	// maxCollectedFileSize bounds how much of a file written by the script is copied back to
+	// the output directory. Larger files are rejected when read, so there is no need to copy more.
+	maxCollectedFileSize = 1024 * 1024
+)
+
+// ResultFilePath returns the path of the result written by the script, for the specified
+// output directory. The file does not exist if the script did not write any result.
+func ResultFilePath(dir string) string {
+	return filepath.Join(dir, resultFileName)
+}
+
+// ScriptFilesDir returns the directory of the files shared with the script executed in the specified
+// output directory. The user running the script must be able to write to it, so it is the RunAs
+// directory of the RunAs user if there is one.
+func ScriptFilesDir(outputDir string, cfg *handlersettings.HandlerSettings) string {
+	if cfg.PublicSettings.RunAsUser == "" || !strings.HasPrefix(outputDir, constants.DataDir) {
+		return outputDir
+	}
+	return filepath.Join(fmt.Sprintf(constants.RunAsDir, cfg.PublicSettings.RunAsUser), outputDir[len(constants.DataDir):])
+}
+
+// prepareScriptFiles creates the empty files the script can write to in dir, owned by uid
+// unless it is negative, and returns the environment variables exporting their paths.
+func prepareScriptFiles(dir string, uid int) ([]string, error) {
+	var env []string
+	for _, file := range []struct{ envName, name string }{
+		{ResultFileEnvName, resultFileName},
+		{ProgressFileEnvName, progressFileName},
+	} {
+		// The directory may be writable by the RunAs user. Never follow a link left there by a previous run.
+		path := filepath.Join(dir, file.name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to remove file '%s'", path)
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create file '%s'", path)
+		}
+		f.Close()
+
+		if uid >= 0 {
+			if err := os.Chown(path, uid, os.Getegid()); err != nil {
+				return nil, errors.Wrapf(err, "failed to change owner of file '%s'", path)
+			}
+		}
+		env = append(env, fmt.Sprintf("%s=%s", file.envName, path))
+	}
+	return env, nil
+}
+
+// writeParametersFile writes the parameters of cfg to a file of dir readable by uid, unless it is
+// negative, and returns the environment variable exporting its path.
+func writeParametersFile(dir string, uid int, cfg *handlersettings.HandlerSettings) (string, error) {
+	parameters := map[string]json.RawMessage{}
+	for _, p := range cfg.PublicSettings.Parameters {
+		if p.Name == "" {
+			continue
+		}
+		value, err := p.JSONValue()
+		if err != nil {
+			return "", errors.Wrapf(err, "parameter '%s'", p.Name)
+		}
+		parameters[p.Name] = value
+	}
+	b, err := json.Marshal(parameters)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal parameters")
+	}
+
+	path := filepath.Join(dir, parametersFileName)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "failed to remove file '%s'", path)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, 0400)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create file '%s'", path)
+	}
+	_, err = f.Write(b)
+	f.Close()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to write file '%s'", path)
+	}
+
+	if uid >= 0 {
+		if err := os.Chown(path, uid, os.Getegid()); err != nil {
+			return "", errors.Wrapf(err, "failed to change owner of file '%s'", path)
+		}
+	}
+	return fmt.Sprintf("%s=%s", ParametersFileEnvName, path), nil
+}
+
+// openScriptFile opens a file of dir written by the script. These files are owned by the user running
+// the script, so they are only opened if they are regular files and not symbolic links to somewhere else.
+// A nil file is returned if the file does not exist.
+func openScriptFile(dir string, name string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to open file '%s'", name)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to retrieve info of file '%s'", name)
+	}
+	if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("file '%s' is not a regular file", name)
+	}
+	return f, nil
+}
+
+// collectScriptFiles copies the result written by the script in dir to the output directory,
+// when the script did not run in it.
+func collectScriptFiles(dir string, outputDir string) error {
+	if dir == outputDir {
+		return nil
+	}
+
+	src, err := openScriptFile(dir, resultFileName)
+	if err != nil || src == nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(ResultFilePath(outputDir), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to create result file in output directory")
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, io.LimitReader(src, maxCollectedFileSize))
+	return errors.Wrap(err, "failed to copy result file")
+}
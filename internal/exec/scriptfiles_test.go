@@ -0,0 +1,157 @@
+package exec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resultFilePath(t *testing.T) {
+	require.Equal(t, "/tmp/result.json", ResultFilePath("/tmp"))
+}
+
+func TestExecCmdInDir_resultFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	err, _ = ExecCmdInDir(testContext, `echo '{"answer": 42}' > "$RC_RESULT_FILE"`, dir, &testHandlerSettings, Options{})
+	require.Nil(t, err)
+
+	b, err := ioutil.ReadFile(ResultFilePath(dir))
+	require.Nil(t, err)
+	require.Equal(t, "{\"answer\": 42}\n", string(b))
+}
+
+func TestExecCmdInDir_resultFileIsTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, ioutil.WriteFile(ResultFilePath(dir), []byte(`{"stale": true}`), 0600))
+	err, _ = ExecCmdInDir(testContext, "/bin/echo 'no result'", dir, &testHandlerSettings, Options{})
+	require.Nil(t, err)
+
+	b, err := ioutil.ReadFile(ResultFilePath(dir))
+	require.Nil(t, err)
+	require.Empty(t, b)
+}
+
+func Test_collectScriptFiles(t *testing.T) {
+	scriptDir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(scriptDir)
+	outputDir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputDir)
+
+	// nothing written by the script
+	require.Nil(t, collectScriptFiles(scriptDir, outputDir))
+	require.False(t, fileExists(t, ResultFilePath(outputDir)))
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(scriptDir, "result.json"), []byte(`{"ok": true}`), 0600))
+	require.Nil(t, collectScriptFiles(scriptDir, outputDir))
+	b, err := ioutil.ReadFile(ResultFilePath(outputDir))
+	require.Nil(t, err)
+	require.Equal(t, `{"ok": true}`, string(b))
+}
+
+func Test_collectScriptFiles_rejectsSymlinks(t *testing.T) {
+	scriptDir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(scriptDir)
+	outputDir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(outputDir)
+
+	secret := filepath.Join(outputDir, "secret")
+	require.Nil(t, ioutil.WriteFile(secret, []byte("password"), 0600))
+	require.Nil(t, os.Symlink(secret, filepath.Join(scriptDir, "result.json")))
+
+	require.NotNil(t, collectScriptFiles(scriptDir, outputDir))
+	require.False(t, fileExists(t, ResultFilePath(outputDir)))
+}
+
+func Test_scriptFilesDir(t *testing.T) {
+	cfg := handlersettings.HandlerSettings{}
+	require.Equal(t, "/var/lib/waagent/run-command-handler/download/RC0001/2", ScriptFilesDir("/var/lib/waagent/run-command-handler/download/RC0001/2", &cfg))
+
+	cfg.PublicSettings.RunAsUser = "alice"
+	require.Equal(t, "/home/alice/waagent/run-command-handler-runas/download/RC0001/2", ScriptFilesDir("/var/lib/waagent/run-command-handler/download/RC0001/2", &cfg))
+}
+
+func Test_prepareScriptFiles_replacesLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "target")
+	require.Nil(t, ioutil.WriteFile(target, []byte("keep me"), 0600))
+	require.Nil(t, os.Symlink(target, filepath.Join(dir, "result.json")))
+
+	env, err := prepareScriptFiles(dir, -1)
+	require.Nil(t, err)
+	require.Equal(t, []string{"RC_RESULT_FILE=" + filepath.Join(dir, "result.json"), "RC_PROGRESS_FILE=" + filepath.Join(dir, "progress.log")}, env)
+
+	b, err := ioutil.ReadFile(target)
+	require.Nil(t, err)
+	require.Equal(t, "keep me", string(b), "link target must not be truncated")
+
+	fi, err := os.Lstat(filepath.Join(dir, "result.json"))
+	require.Nil(t, err)
+	require.True(t, fi.Mode().IsRegular())
+}
+
+func TestExecCmdInDir_parametersFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := handlersettings.HandlerSettings{
+		PublicSettings: handlersettings.PublicSettings{Parameters: []handlersettings.ParameterDefinition{
+			{Name: "name", Value: "it's \"quoted\""},
+			{Name: "count", Value: "3", Type: handlersettings.ParameterTypeInt},
+			{Name: "force", Default: "true", Type: handlersettings.ParameterTypeBool},
+			{Name: "config", Value: `{"a": [1, 2]}`, Type: handlersettings.ParameterTypeJSON},
+			{Value: "unnamed"},
+		}},
+		ProtectedSettings: handlersettings.ProtectedSettings{ProtectedParameters: []handlersettings.ParameterDefinition{
+			{Name: "secret", Value: "hunter2"},
+		}},
+	}
+	err, _ = ExecCmdInDir(testContext, `cat "$RC_PARAMETERS_FILE" # unnamed parameters are appended as arguments`, dir, &cfg, Options{})
+	require.Nil(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+	require.JSONEq(t, `{"name": "it's \"quoted\"", "count": 3, "force": true, "config": {"a": [1, 2]}}`, string(b))
+
+	fi, err := os.Stat(filepath.Join(dir, "parameters.json"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0400).String(), fi.Mode().String())
+}
+
+func Test_writeParametersFile_replacesLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "target")
+	require.Nil(t, ioutil.WriteFile(target, []byte("keep"), 0600))
+	require.Nil(t, os.Symlink(target, filepath.Join(dir, "parameters.json")))
+
+	env, err := writeParametersFile(dir, -1, &handlersettings.HandlerSettings{})
+	require.Nil(t, err)
+	require.Equal(t, "RC_PARAMETERS_FILE="+filepath.Join(dir, "parameters.json"), env)
+
+	b, err := ioutil.ReadFile(target)
+	require.Nil(t, err)
+	require.Equal(t, "keep", string(b), "link target must not be overwritten")
+	b, err = ioutil.ReadFile(filepath.Join(dir, "parameters.json"))
+	require.Nil(t, err)
+	require.Equal(t, "{}", string(b))
+}
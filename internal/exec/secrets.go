@@ -0,0 +1,147 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ProtectedParametersFileEnvName is the environment variable holding the path of the JSON object mapping
+	// the names of the protected parameters to their values, when they are delivered in a file.
+	ProtectedParametersFileEnvName = "RC_PROTECTED_PARAMETERS_FILE"
+
+	protectedParametersFileName = "protected-parameters.json"
+
+	// tmpfsMagic is the file system type of tmpfs, see statfs(2)
+	tmpfsMagic = 0x01021994
+)
+
+// secretsDirs are the in-memory file systems where the protected parameters file can be created,
+// in order of preference. Replaced in tests.
+var secretsDirs = []string{"/dev/shm", "/run"}
+
+// secretsFile is a file holding the protected parameters, which only exists while the script runs.
+type secretsFile struct {
+	dir  string
+	path string
+}
+
+// writeProtectedParametersFile writes the protected parameters of cfg to a file readable only by uid, or
+// by the current user if it is negative. The file is created in a private directory of an in-memory file
+// system, so the secrets are never written to disk.
+func writeProtectedParametersFile(cfg *handlersettings.HandlerSettings, uid int) (*secretsFile, error) {
+	parameters := map[string]json.RawMessage{}
+	for _, p := range cfg.ProtectedSettings.ProtectedParameters {
+		value, err := p.JSONValue()
+		if err != nil {
+			return nil, errors.Wrapf(err, "protected parameter '%s'", p.Name)
+		}
+		parameters[p.Name] = value
+	}
+	b, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal protected parameters")
+	}
+	defer zero(b)
+
+	root, err := inMemoryDir()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(root, "run-command-handler-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create directory for protected parameters")
+	}
+	secrets := &secretsFile{dir: dir, path: filepath.Join(dir, protectedParametersFileName)}
+
+	if err := secrets.write(b, uid); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (s *secretsFile) write(b []byte, uid int) error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, 0400)
+	if err != nil {
+		return errors.Wrap(err, "failed to create protected parameters file")
+	}
+	_, err = f.Write(b)
+	f.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to write protected parameters file")
+	}
+
+	if uid >= 0 {
+		for _, path := range []string{s.dir, s.path} {
+			if err := os.Chown(path, uid, os.Getegid()); err != nil {
+				return errors.Wrapf(err, "failed to change owner of '%s'", path)
+			}
+		}
+	}
+	return nil
+}
+
+// env returns the environment variable exporting the path of the file.
+func (s *secretsFile) env() string {
+	return fmt.Sprintf("%s=%s", ProtectedParametersFileEnvName, s.path)
+}
+
+// remove overwrites the content of the file before removing it with its directory.
+func (s *secretsFile) remove(ctx *log.Context) {
+	if s == nil {
+		return
+	}
+	if err := overwrite(s.path); err != nil {
+		ctx.Log("message", "failed to overwrite protected parameters file", "error", err)
+	}
+	if err := os.RemoveAll(s.dir); err != nil {
+		ctx.Log("message", "failed to remove protected parameters file", "error", err)
+	}
+}
+
+// overwrite replaces the content of the file with zeros. The script may have replaced the file,
+// links are not followed.
+func overwrite(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return err
+	}
+	if _, err := f.Write(make([]byte, fi.Size())); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// inMemoryDir returns the first of secretsDirs which is an in-memory file system.
+func inMemoryDir() (string, error) {
+	for _, dir := range secretsDirs {
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(dir, &fs); err == nil && fs.Type == tmpfsMagic {
+			return dir, nil
+		}
+	}
+	return "", errors.New("no in-memory file system is available for the protected parameters file")
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,99 @@
+package exec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/stretchr/testify/require"
+)
+
+var protectedParametersSettings = handlersettings.HandlerSettings{
+	PublicSettings: handlersettings.PublicSettings{
+		ProtectedParametersInFile: true,
+		Parameters:                []handlersettings.ParameterDefinition{{Name: "PUBLIC_VALUE", Value: "public"}},
+	},
+	ProtectedSettings: handlersettings.ProtectedSettings{ProtectedParameters: []handlersettings.ParameterDefinition{
+		{Name: "SECRET_VALUE", Value: "hunter2"},
+		{Name: "SECRET_PORT", Value: "8443", Type: handlersettings.ParameterTypeInt},
+	}},
+}
+
+// requireInMemoryDir skips the test if none of the secretsDirs is an in-memory file system.
+func requireInMemoryDir(t *testing.T) {
+	if _, err := inMemoryDir(); err != nil {
+		t.Skip("no in-memory file system available")
+	}
+}
+
+func Test_inMemoryDir_rejectsDisks(t *testing.T) {
+	original := secretsDirs
+	defer func() { secretsDirs = original }()
+	secretsDirs = []string{"/non-existing-path"}
+
+	_, err := inMemoryDir()
+	require.EqualError(t, err, "no in-memory file system is available for the protected parameters file")
+}
+
+func Test_writeProtectedParametersFile(t *testing.T) {
+	requireInMemoryDir(t)
+
+	secrets, err := writeProtectedParametersFile(&protectedParametersSettings, -1)
+	require.Nil(t, err)
+
+	fi, err := os.Stat(secrets.dir)
+	require.Nil(t, err)
+	require.Equal(t, os.ModeDir|0700, fi.Mode())
+	fi, err = os.Stat(secrets.path)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0400), fi.Mode())
+
+	b, err := ioutil.ReadFile(secrets.path)
+	require.Nil(t, err)
+	require.JSONEq(t, `{"SECRET_VALUE": "hunter2", "SECRET_PORT": 8443}`, string(b))
+	require.Equal(t, "RC_PROTECTED_PARAMETERS_FILE="+secrets.path, secrets.env())
+
+	secrets.remove(testContext)
+	_, err = os.Stat(secrets.dir)
+	require.True(t, os.IsNotExist(err), "directory must be removed")
+}
+
+func Test_overwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+	require.Nil(t, ioutil.WriteFile(path, []byte("hunter2"), 0400))
+
+	require.Nil(t, overwrite(path))
+	b, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, make([]byte, 7), b)
+
+	require.Nil(t, overwrite(filepath.Join(t.TempDir(), "missing")))
+}
+
+func TestExecCmdInDir_protectedParametersFile(t *testing.T) {
+	requireInMemoryDir(t)
+	dir := t.TempDir()
+
+	script := `echo "env=${SECRET_VALUE:-unset}"; cat "$RC_PROTECTED_PARAMETERS_FILE"; echo; echo "$RC_PROTECTED_PARAMETERS_FILE" >&2`
+	err, _ := ExecCmdInDir(testContext, script, dir, &protectedParametersSettings, Options{})
+	require.Nil(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Equal(t, 2, len(lines), "%s", b)
+	require.Equal(t, "env=unset", lines[0], "protected parameters must not be exported")
+	require.JSONEq(t, `{"SECRET_VALUE": "hunter2", "SECRET_PORT": 8443}`, lines[1])
+	require.Equal(t, "public", os.Getenv("PUBLIC_VALUE"), "public parameters are still exported")
+
+	// the file only exists while the script runs
+	b, err = ioutil.ReadFile(filepath.Join(dir, "stderr"))
+	require.Nil(t, err)
+	path := strings.TrimSpace(string(b))
+	require.NotEmpty(t, path)
+	_, err = os.Stat(filepath.Dir(path))
+	require.True(t, os.IsNotExist(err), "protected parameters directory must be removed")
+}
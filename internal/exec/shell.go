@@ -0,0 +1,106 @@
+package exec
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/pkg/shellutil"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	shellPath = "/bin/bash"
+
+	// Options of bash for the strict mode, equivalent to 'set -euo pipefail'
+	strictModeOptions = "-euo pipefail"
+)
+
+// shellArgs returns the arguments of the shell executing the command according to the settings.
+func shellArgs(cfg *handlersettings.HandlerSettings, cmd string) []string {
+	if cfg.PublicSettings.LoginShell {
+		return []string{"-l", "-c", cmd}
+	}
+	return []string{"-c", cmd}
+}
+
+// shellCommand returns the command executing the script at scriptPath, with the specified arguments,
+// with the strict mode and the umask of the settings.
+func shellCommand(ctx *log.Context, cfg *handlersettings.HandlerSettings, scriptPath, commandArgs string) string {
+	cmd := scriptPath + commandArgs
+	if cfg.PublicSettings.StrictMode {
+		if isBashScript(scriptPath) {
+			cmd = shellPath + " " + strictModeOptions + " " + cmd
+		} else {
+			ctx.Log("message", "strict mode only applies to bash scripts, it is ignored")
+		}
+	}
+	if cfg.PublicSettings.Umask != "" {
+		cmd = "umask " + cfg.PublicSettings.Umask + " && " + cmd
+	}
+	return cmd
+}
+
+// runAsShellCommand returns the command executed with sudo for the RunAs user. A shell is started as the RunAs
+// user when the settings require it, so the profile of the user is sourced and the umask is not the one of sudo.
+func runAsShellCommand(cfg *handlersettings.HandlerSettings, cmd string) string {
+	if !cfg.PublicSettings.LoginShell && cfg.PublicSettings.Umask == "" {
+		return cmd
+	}
+	args := shellArgs(cfg, cmd)
+	args[len(args)-1] = shellutil.Quote(cmd)
+	return shellPath + " " + strings.Join(args, " ")
+}
+
+// isBashScript returns whether the file at path is a script executed by bash. It returns false if the file
+// cannot be read.
+func isBashScript(path string) bool {
+	interpreter, err := Interpreter(path)
+	return err == nil && filepath.Base(interpreter) == "bash"
+}
+
+// Interpreter returns the interpreter of the script at path according to its first line, e.g. /bin/sh
+// for #!/bin/sh or python3 for #!/usr/bin/env python3. Scripts without an interpreter are executed by bash.
+// It returns an empty string if the first line has no interpreter.
+func Interpreter(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return shellPath, nil
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return "", nil
+	}
+	if filepath.Base(fields[0]) == "env" {
+		// e.g. #!/usr/bin/env bash or #!/usr/bin/env -S bash -x
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				return field, nil
+			}
+		}
+	}
+	return fields[0], nil
+}
+
+// CheckSyntax checks the syntax of the bash script at path without executing it.
+func CheckSyntax(path string) error {
+	out, err := exec.Command(shellPath, "-n", path).CombinedOutput()
+	if err != nil {
+		return errors.Errorf("syntax error: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package exec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/stretchr/testify/require"
+)
+
+func writeScript(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "script.sh")
+	require.Nil(t, os.WriteFile(path, []byte(content), 0700))
+	return path
+}
+
+func Test_isBashScript(t *testing.T) {
+	dir := t.TempDir()
+	for content, expected := range map[string]bool{
+		"":                                true,
+		"echo no interpreter\n":           true,
+		"#!/bin/bash\nset -x\n":           true,
+		"#!/usr/bin/bash -x":              true,
+		"#!/usr/bin/env bash\n":           true,
+		"#!/usr/bin/env -S bash -e\n":     true,
+		"#!/bin/sh\n":                     false,
+		"#!/usr/bin/env python3\nprint()": false,
+		"#!\n":                            false,
+	} {
+		require.Equal(t, expected, isBashScript(writeScript(t, dir, content)), content)
+	}
+	require.False(t, isBashScript(filepath.Join(dir, "missing.sh")))
+}
+
+func Test_Interpreter(t *testing.T) {
+	dir := t.TempDir()
+	for content, expected := range map[string]string{
+		"echo no interpreter\n":           "/bin/bash",
+		"#!/bin/sh -e\n":                  "/bin/sh",
+		"#!/usr/bin/env python3\nprint()": "python3",
+		"#!\n":                            "",
+	} {
+		interpreter, err := Interpreter(writeScript(t, dir, content))
+		require.Nil(t, err)
+		require.Equal(t, expected, interpreter, content)
+	}
+	_, err := Interpreter(filepath.Join(dir, "missing.sh"))
+	require.NotNil(t, err)
+}
+
+func Test_CheckSyntax(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, CheckSyntax(writeScript(t, dir, "if true; then echo ok; fi\n")))
+
+	err := CheckSyntax(writeScript(t, dir, "if true; then echo missing fi\n"))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "syntax error")
+}
+
+func TestExecCmdInDir_strictMode(t *testing.T) {
+	dir := t.TempDir()
+	script := writeScript(t, dir, "#!/bin/bash\nfalse | true\necho $UNSET_VARIABLE\nfalse\necho done\n")
+
+	cfg := handlersettings.HandlerSettings{}
+	err, exitCode := ExecCmdInDir(testContext, script, dir, &cfg, Options{})
+	require.Nil(t, err)
+	require.Equal(t, 0, exitCode)
+
+	cfg.PublicSettings.StrictMode = true
+	err, exitCode = ExecCmdInDir(testContext, script, dir, &cfg, Options{})
+	require.NotNil(t, err, "pipefail fails the first line")
+	require.Equal(t, 1, exitCode)
+	b, err := os.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+	require.Empty(t, string(b))
+
+	// other interpreters are not changed
+	script = writeScript(t, dir, "#!/bin/sh\nfalse\necho done\n")
+	err, _ = ExecCmdInDir(testContext, script, dir, &cfg, Options{})
+	require.Nil(t, err)
+}
+
+func TestExecCmdInDir_umask(t *testing.T) {
+	dir := t.TempDir()
+	script := writeScript(t, dir, "umask\ntouch created\n")
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{Umask: "027"}}
+
+	err, _ := ExecCmdInDir(testContext, script, dir, &cfg, Options{})
+	require.Nil(t, err)
+	b, err := os.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+	require.Equal(t, "0027\n", string(b))
+	fi, err := os.Stat(filepath.Join(dir, "created"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+}
+
+func TestExecCmdInDir_loginShell(t *testing.T) {
+	dir, home := t.TempDir(), t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(home, ".profile"), []byte("export FROM_PROFILE=sourced\n"), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(home, ".bash_profile"), []byte(". ~/.profile\n"), 0600))
+	script := writeScript(t, dir, "echo \"profile ${FROM_PROFILE:-not sourced}\"\n")
+	cfg := handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		Environment: map[string]string{"HOME": home},
+	}}
+
+	err, _ := ExecCmdInDir(testContext, script, dir, &cfg, Options{})
+	require.Nil(t, err)
+	b, err := os.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+	require.Equal(t, "profile not sourced\n", string(b))
+
+	cfg.PublicSettings.LoginShell = true
+	err, _ = ExecCmdInDir(testContext, script, dir, &cfg, Options{})
+	require.Nil(t, err)
+	b, err = os.ReadFile(filepath.Join(dir, "stdout"))
+	require.Nil(t, err)
+	require.Equal(t, "profile sourced\n", string(b))
+}
+
+func Test_runAsShellCommand(t *testing.T) {
+	cfg := handlersettings.HandlerSettings{}
+	require.Equal(t, "/home/user/script.sh arg", runAsShellCommand(&cfg, "/home/user/script.sh arg"))
+
+	cfg.PublicSettings.LoginShell, cfg.PublicSettings.Umask = true, "022"
+	cmd := shellCommand(testContext, &cfg, "/home/user/script.sh", " arg")
+	require.Equal(t, "umask 022 && /home/user/script.sh arg", cmd)
+	require.Equal(t, "/bin/bash -l -c 'umask 022 && /home/user/script.sh arg'", runAsShellCommand(&cfg, cmd))
+}
@@ -1,8 +1,11 @@
 package files
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 )
@@ -38,6 +41,109 @@ func TailFile(path string, max int64) ([]byte, error) {
 	return b, errors.Wrap(err, "error reading from file")
 }
 
+// HeadAndTailFile returns the first head bytes and the last tail bytes of the file at
+// path, separated by a truncation marker. See HeadAndTail for how the cuts are made.
+// If the file does not exist, it returns a nil slice and no error.
+func HeadAndTailFile(path string, head, tail int64) ([]byte, error) {
+	return HeadAndTailFileFrom(path, 0, head, tail)
+}
+
+// HeadAndTailFileFrom is HeadAndTailFile for the part of the file after the specified
+// position, e.g. the output appended to a file since the position was taken.
+func HeadAndTailFileFrom(path string, position, head, tail int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error opening file")
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "error retrieving file info")
+	}
+	size := fi.Size() - position
+	if size <= 0 {
+		return []byte{}, nil
+	}
+	if size <= head+tail {
+		b, err := io.ReadAll(io.NewSectionReader(f, position, size))
+		return b, errors.Wrap(err, "error reading from file")
+	}
+
+	// Read one more byte on each side, so the cuts can tell whether they split a rune
+	headBytes := make([]byte, head+1)
+	n, err := f.ReadAt(headBytes, position)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "error reading from file")
+	}
+	headBytes = headBytes[:n]
+
+	tailOffset := position + size - tail - 1
+	tailBytes := make([]byte, tail+1)
+	n, err = f.ReadAt(tailBytes, tailOffset)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "error reading file: offset=%d", tailOffset)
+	}
+	tailBytes = tailBytes[:n]
+
+	return joinHeadAndTail(cutHead(headBytes, int(head)), cutTail(tailBytes, int(tail)), size), nil
+}
+
+// HeadAndTail returns b unchanged if it is not longer than head+tail bytes. Otherwise it
+// returns the first head and the last tail bytes of b, separated by a marker line which
+// tells how many bytes were dropped. Each cut is moved to a line break if one is found in
+// the half of the kept bytes closest to the cut, otherwise to a rune boundary, so the
+// result never contains a partial UTF-8 character.
+func HeadAndTail(b []byte, head, tail int) []byte {
+	if len(b) <= head+tail {
+		return b
+	}
+	return joinHeadAndTail(cutHead(b, head), cutTail(b, tail), int64(len(b)))
+}
+
+// cutHead returns at most the first max bytes of b, cut as described in HeadAndTail.
+func cutHead(b []byte, max int) []byte {
+	if len(b) <= max {
+		return b
+	}
+	end := max
+	for i := 0; i < utf8.UTFMax-1 && end > 0 && !utf8.RuneStart(b[end]); i++ {
+		end--
+	}
+	if i := bytes.LastIndexByte(b[:end], '\n'); i >= 0 && i+1 >= end/2 {
+		end = i + 1
+	}
+	return b[:end]
+}
+
+// cutTail returns at most the last max bytes of b, cut as described in HeadAndTail.
+func cutTail(b []byte, max int) []byte {
+	if len(b) <= max {
+		return b
+	}
+	start := len(b) - max
+	for i := 0; i < utf8.UTFMax-1 && start < len(b) && !utf8.RuneStart(b[start]); i++ {
+		start++
+	}
+	if i := bytes.IndexByte(b[start:], '\n'); i >= 0 && i+1 <= (len(b)-start)/2 {
+		start += i + 1
+	}
+	return b[start:]
+}
+
+func joinHeadAndTail(head, tail []byte, size int64) []byte {
+	var buf bytes.Buffer
+	buf.Write(head)
+	if len(head) > 0 && head[len(head)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	fmt.Fprintf(&buf, "[... %d bytes truncated ...]\n", size-int64(len(head))-int64(len(tail)))
+	buf.Write(tail)
+	return buf.Bytes()
+}
+
 func GetFileFromPosition(path string, position int64) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil && os.IsNotExist(err) {
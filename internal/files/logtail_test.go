@@ -3,7 +3,9 @@ package files
 import (
 	"bytes"
 	"os"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/require"
 )
@@ -82,3 +84,70 @@ func tempFile(t *testing.T) string {
 	defer f.Close()
 	return f.Name()
 }
+
+func Test_headAndTail(t *testing.T) {
+	// shorter than the limits
+	require.Equal(t, "hello\nworld\n", string(HeadAndTail([]byte("hello\nworld\n"), 6, 6)))
+
+	// cut on line breaks
+	in := []byte("line1\nline2\nline3\nline4\nline5\n")
+	require.Equal(t, "line1\nline2\n[... 6 bytes truncated ...]\nline4\nline5\n", string(HeadAndTail(in, 14, 14)))
+
+	// no line break close to the cut, cut on bytes
+	in = []byte("0123456789abcdefghij")
+	require.Equal(t, "0123\n[... 13 bytes truncated ...]\nhij", string(HeadAndTail(in, 4, 3)))
+
+	// only the tail
+	require.Equal(t, "[... 17 bytes truncated ...]\nhij", string(HeadAndTail(in, 0, 3)))
+}
+
+func Test_headAndTail_runeBoundaries(t *testing.T) {
+	in := []byte("ααααα€€€€€") // 2 byte runes, then 3 byte runes
+	out := HeadAndTail(in, 5, 7)
+	require.True(t, utf8.Valid(out), "%q", out)
+	require.Equal(t, "αα\n[... 15 bytes truncated ...]\n€€", string(out))
+}
+
+func Test_headAndTailFile(t *testing.T) {
+	b, err := HeadAndTailFile("/non/existing/path", 10, 10)
+	require.Nil(t, err)
+	require.Len(t, b, 0)
+
+	tf := tempFile(t)
+	defer os.RemoveAll(tf)
+
+	in := []byte(strings.Repeat("é", 10) + "\n" + strings.Repeat("0123456789\n", 100) + strings.Repeat("€", 10))
+	require.Nil(t, os.WriteFile(tf, in, 0666))
+
+	// same result as the in-memory variant
+	for _, limits := range [][2]int{{0, 0}, {5, 5}, {21, 31}, {100, 0}, {0, 100}, {len(in), 0}} {
+		b, err := HeadAndTailFile(tf, int64(limits[0]), int64(limits[1]))
+		require.Nil(t, err)
+		require.Equal(t, string(HeadAndTail(in, limits[0], limits[1])), string(b), "limits=%v", limits)
+		require.True(t, utf8.Valid(b))
+	}
+
+	b, err = HeadAndTailFile(tf, 21, 31)
+	require.Nil(t, err)
+	require.Equal(t, "éééééééééé\n[... 1100 bytes truncated ...]\n€€€€€€€€€€", string(b))
+}
+
+func Test_headAndTailFileFrom(t *testing.T) {
+	tf := tempFile(t)
+	defer os.RemoveAll(tf)
+
+	require.Nil(t, os.WriteFile(tf, []byte("first step\n"+strings.Repeat("second step\n", 10)), 0666))
+
+	b, err := HeadAndTailFileFrom(tf, 11, 12, 12)
+	require.Nil(t, err)
+	require.Equal(t, "second step\n[... 96 bytes truncated ...]\nsecond step\n", string(b))
+
+	b, err = HeadAndTailFileFrom(tf, 11, 1024, 1024)
+	require.Nil(t, err)
+	require.Equal(t, strings.Repeat("second step\n", 10), string(b))
+
+	// nothing after the position
+	b, err = HeadAndTailFileFrom(tf, 131, 1024, 1024)
+	require.Nil(t, err)
+	require.Len(t, b, 0)
+}
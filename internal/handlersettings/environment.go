@@ -0,0 +1,46 @@
+package handlersettings
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ReservedEnvironmentPrefix is the prefix of the environment variables set by the handler for the script.
+const ReservedEnvironmentPrefix = "RC_"
+
+var (
+	environmentNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	umaskPattern           = regexp.MustCompile(`^0?[0-7]{3}$`)
+)
+
+// validateEnvironment checks the names and the values of the environment variables of the settings. A variable
+// cannot have the name of a parameter, which is exported too.
+func validateEnvironment(environment map[string]string, parameters ...[]ParameterDefinition) error {
+	names := make([]string, 0, len(environment))
+	for name := range environment {
+		names = append(names, name)
+	}
+	sort.Strings(names) // report the same error for the same settings
+
+	for _, name := range names {
+		if !environmentNamePattern.MatchString(name) {
+			return fmt.Errorf("'environment' variable '%s' must match %s", name, environmentNamePattern)
+		}
+		if strings.HasPrefix(strings.ToUpper(name), ReservedEnvironmentPrefix) {
+			return fmt.Errorf("'environment' variable '%s' cannot start with %s, it is reserved for the handler", name, ReservedEnvironmentPrefix)
+		}
+		if strings.ContainsRune(environment[name], 0) {
+			return fmt.Errorf("'environment' variable '%s' cannot contain a NUL character", name)
+		}
+		for _, definitions := range parameters {
+			for _, p := range definitions {
+				if p.Name == name {
+					return fmt.Errorf("'environment' variable '%s' conflicts with the parameter of the same name", name)
+				}
+			}
+		}
+	}
+	return nil
+}
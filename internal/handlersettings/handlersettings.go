@@ -19,20 +19,40 @@ func ParseAndValidateSettings(ctx *log.Context, configFilePath string) (h Handle
 	}
 	ctx.Log("event", "read configuration")
 
-	ctx.Log("event", "parsing configuration json")
-	if err := UnmarshalHandlerSettings(pubJSON, protJSON, &h.PublicSettings, &h.ProtectedSettings); err != nil {
-		return h, errors.Wrap(err, "json parsing error")
+	ctx.Log("event", "validating configuration")
+	h, warnings, err := ValidateSettings(pubJSON, protJSON)
+	for _, warning := range warnings {
+		ctx.Log("warning", warning.String())
 	}
-	ctx.Log("event", "parsed configuration json")
-
-	ctx.Log("event", "validating configuration logically")
-	if err := h.validate(); err != nil {
-		return h, errors.Wrap(err, "invalid configuration")
+	if err != nil {
+		return h, err
 	}
 	ctx.Log("event", "validated configuration")
 	return h, nil
 }
 
+// ValidateSettings validates the public and protected settings JSON objects against their schema, parses them
+// and validates them logically. Unknown fields are returned as warnings. The errors of the schema validation
+// are returned in a *SchemaError.
+func ValidateSettings(public, protected map[string]interface{}) (h HandlerSettings, warnings []SchemaIssue, _ error) {
+	warnings, errs, err := ValidateSchema(public, protected)
+	if err != nil {
+		return h, warnings, err
+	}
+	if len(errs) > 0 {
+		return h, warnings, errors.Wrap(&SchemaError{Issues: errs}, "json validation error")
+	}
+
+	if err := UnmarshalHandlerSettings(public, protected, &h.PublicSettings, &h.ProtectedSettings); err != nil {
+		return h, warnings, errors.Wrap(err, "json parsing error")
+	}
+
+	if err := h.validate(); err != nil {
+		return h, warnings, errors.Wrap(err, "invalid configuration")
+	}
+	return h, warnings, nil
+}
+
 // readSettings uses specified configFilePath (comes from HandlerEnvironment) to
 // decrypt and parse the public/protected settings of the extension handler into
 // JSON objects.
@@ -1,8 +1,11 @@
 package handlersettings
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/Azure/run-command-handler-linux/internal/types"
 	"github.com/stretchr/testify/require"
 )
 
@@ -182,3 +185,313 @@ func Test_scriptUri(t *testing.T) {
 // 	h = handlerSettings{publicSettings{}, *protSettings}
 // 	require.Error(t, h.validate(), "settings should be invalid")
 // }
+
+func Test_outputSinkValidate(t *testing.T) {
+	source := &ScriptSource{Script: "date"}
+
+	// blob sink without the blob uri
+	err := HandlerSettings{PublicSettings{Source: source, OutputSink: &OutputSinkSettings{Type: OutputSinkAppendBlob}}, ProtectedSettings{}}.validate()
+	require.ErrorContains(t, err, "'outputSink' of type 'appendBlob' requires the corresponding blob uri")
+
+	// http sink without uri
+	err = HandlerSettings{PublicSettings{Source: source, ErrorSink: &OutputSinkSettings{Type: OutputSinkHTTP}}, ProtectedSettings{}}.validate()
+	require.ErrorContains(t, err, "'errorSink.uri' has to be specified")
+
+	// http sink with unsupported method
+	err = HandlerSettings{PublicSettings{Source: source, ErrorSink: &OutputSinkSettings{Type: OutputSinkHTTP, URI: "https://logs", Method: "GET"}}, ProtectedSettings{}}.validate()
+	require.ErrorContains(t, err, "'errorSink.method' must be POST")
+
+	// http sink with PUT, which would replace the previous chunks
+	err = HandlerSettings{PublicSettings{Source: source, ErrorSink: &OutputSinkSettings{Type: OutputSinkHTTP, URI: "https://logs", Method: "PUT"}}, ProtectedSettings{}}.validate()
+	require.ErrorContains(t, err, "'errorSink.method' must be POST")
+
+	// unknown type
+	err = HandlerSettings{PublicSettings{Source: source, OutputSink: &OutputSinkSettings{Type: "pigeon"}}, ProtectedSettings{}}.validate()
+	require.ErrorContains(t, err, "'outputSink.type' must be one of")
+
+	// valid sinks
+	require.Nil(t, HandlerSettings{PublicSettings{Source: source, OutputSink: &OutputSinkSettings{Type: OutputSinkSyslog}, ErrorSink: &OutputSinkSettings{Type: OutputSinkLocalFile}}, ProtectedSettings{}}.validate())
+}
+
+func Test_streamSinkDefaultsToBlob(t *testing.T) {
+	s := HandlerSettings{PublicSettings{OutputBlobURI: "https://acct.blob.core.windows.net/c/out"}, ProtectedSettings{}}
+	require.Equal(t, &OutputSinkSettings{Type: OutputSinkAppendBlob}, s.OutputStreamSink())
+	require.Nil(t, s.ErrorStreamSink())
+
+	s.PublicSettings.OutputSink = &OutputSinkSettings{Type: OutputSinkSyslog}
+	require.Equal(t, OutputSinkSyslog, s.OutputStreamSink().Type)
+}
+
+func Test_outputCaptureLimits(t *testing.T) {
+	source := &ScriptSource{Script: "date"}
+	intPtr := func(i int) *int { return &i }
+
+	s := HandlerSettings{PublicSettings{Source: source}, ProtectedSettings{}}
+	head, tail := s.OutputCaptureLimits()
+	require.Equal(t, DefaultOutputHeadBytes, head)
+	require.Equal(t, DefaultOutputTailBytes, tail)
+
+	// tail only
+	s.PublicSettings.OutputHeadBytes = intPtr(0)
+	head, tail = s.OutputCaptureLimits()
+	require.Equal(t, 0, head)
+	require.Equal(t, DefaultOutputTailBytes, tail)
+	require.Nil(t, s.validate())
+
+	s.PublicSettings.OutputTailBytes = intPtr(-1)
+	require.ErrorContains(t, s.validate(), "cannot be negative")
+
+	s.PublicSettings.OutputHeadBytes, s.PublicSettings.OutputTailBytes = intPtr(8*1024), intPtr(8*1024+1)
+	require.ErrorContains(t, s.validate(), "cannot add up to more than 16384 bytes")
+}
+
+func Test_outputRulesValidate(t *testing.T) {
+	source := &ScriptSource{Script: "date"}
+	validate := func(rule OutputRule) error {
+		return HandlerSettings{PublicSettings{Source: source, OutputRules: []OutputRule{rule}}, ProtectedSettings{}}.validate()
+	}
+
+	require.ErrorContains(t, validate(OutputRule{Pattern: "x", Result: "Failed"}), "'outputRules[0].name' has to be specified")
+	require.ErrorContains(t, validate(OutputRule{Name: "r", Stream: "both", Pattern: "x", Result: "Failed"}), "'outputRules[0].stream' must be either stdout or stderr")
+	require.ErrorContains(t, validate(OutputRule{Name: "r", Pattern: "x", Result: "Skipped"}), "'outputRules[0].result' must be either Failed or Succeeded")
+	require.ErrorContains(t, validate(OutputRule{Name: "r", Pattern: "(", Result: "Failed"}), "'outputRules[0].pattern' is not a valid regular expression")
+	require.Nil(t, validate(OutputRule{Name: "r", Stream: "stderr", Pattern: "^error", Result: "Succeeded"}))
+}
+
+func Test_retryPolicy(t *testing.T) {
+	source := &ScriptSource{Script: "date"}
+	validate := func(p RetryPolicy) error {
+		return HandlerSettings{PublicSettings{Source: source, Retry: &p}, ProtectedSettings{}}.validate()
+	}
+	require.ErrorContains(t, validate(RetryPolicy{}), "'retry.maxAttempts' must be between 1 and 10")
+	require.ErrorContains(t, validate(RetryPolicy{MaxAttempts: 2, DelaySeconds: -1}), "'retry.delaySeconds' must be between 0 and 3600")
+	require.ErrorContains(t, validate(RetryPolicy{MaxAttempts: 2, Backoff: "linear"}), "'retry.backoff' must be either constant or exponential")
+	require.Nil(t, validate(RetryPolicy{MaxAttempts: 3, DelaySeconds: 10, Backoff: RetryBackoffExponential}))
+
+	constant := RetryPolicy{MaxAttempts: 5, DelaySeconds: 10}
+	require.Equal(t, 10*time.Second, constant.Delay(1))
+	require.Equal(t, 10*time.Second, constant.Delay(4))
+
+	exponential := RetryPolicy{MaxAttempts: 10, DelaySeconds: 10, Backoff: RetryBackoffExponential}
+	require.Equal(t, 10*time.Second, exponential.Delay(1))
+	require.Equal(t, 40*time.Second, exponential.Delay(3))
+	require.Equal(t, time.Hour, exponential.Delay(10), "delay is capped")
+
+	require.True(t, constant.ShouldRetry(1))
+	require.False(t, constant.ShouldRetry(0))
+	onlyMirrorErrors := RetryPolicy{RetryOnExitCodes: []int{100}}
+	require.True(t, onlyMirrorErrors.ShouldRetry(100))
+	require.False(t, onlyMirrorErrors.ShouldRetry(1))
+}
+
+func Test_stepsValidate(t *testing.T) {
+	step := func(name string) StepSettings {
+		return StepSettings{Name: name, Source: &ScriptSource{Script: "date"}}
+	}
+	validate := func(s PublicSettings) error {
+		return HandlerSettings{s, ProtectedSettings{}}.validate()
+	}
+
+	require.Nil(t, validate(PublicSettings{Steps: []StepSettings{step("first"), step("second")}}))
+	require.ErrorContains(t, validate(PublicSettings{Source: &ScriptSource{Script: "date"}, Steps: []StepSettings{step("first")}}), "'source' and 'steps' cannot be specified together")
+	require.ErrorContains(t, validate(PublicSettings{InstallAsService: true, Steps: []StepSettings{step("first")}}), "'steps' cannot be installed as a service")
+	require.ErrorContains(t, validate(PublicSettings{Steps: []StepSettings{step("first"), step("first")}}), "'steps[1].name' must be unique")
+	require.ErrorContains(t, validate(PublicSettings{Steps: []StepSettings{step("")}}), "'steps[0].name' has to be specified")
+	require.ErrorContains(t, validate(PublicSettings{Steps: []StepSettings{{Name: "first"}}}), "either 'steps[0].source.script' or 'steps[0].source.scriptUri' has to be specified")
+	require.ErrorContains(t, validate(PublicSettings{Steps: []StepSettings{{Name: "first", Source: &ScriptSource{Script: "date", ScriptURI: "https://a/b.sh"}}}}), "either 'steps[0].source.script'")
+
+	negativeTimeout := step("first")
+	negativeTimeout.TimeoutInSeconds = -1
+	require.ErrorContains(t, validate(PublicSettings{Steps: []StepSettings{negativeTimeout}}), "'steps[0].timeoutInSeconds' cannot be negative")
+
+	tooMany := make([]StepSettings, MaxSteps+1)
+	for i := range tooMany {
+		tooMany[i] = step(fmt.Sprintf("step%d", i))
+	}
+	require.ErrorContains(t, validate(PublicSettings{Steps: tooMany}), "at most 20 'steps' can be specified")
+}
+
+func Test_coordinationValidate(t *testing.T) {
+	validate := func(s PublicSettings) error {
+		s.Source = &ScriptSource{Script: "date"}
+		return HandlerSettings{s, ProtectedSettings{}}.validate()
+	}
+
+	require.Nil(t, validate(PublicSettings{ExclusiveLockName: "apt", DependsOn: []string{"RC0001", "install.packages"}, WaitTimeoutInSeconds: 600}))
+	require.ErrorContains(t, validate(PublicSettings{ExclusiveLockName: "../apt"}), "'exclusiveLockName' must match")
+	require.ErrorContains(t, validate(PublicSettings{DependsOn: []string{"first", ""}}), "'dependsOn[1]' must match")
+	require.ErrorContains(t, validate(PublicSettings{WaitTimeoutInSeconds: -1}), "'waitTimeoutInSeconds' must be between 0 and 86400")
+
+	require.Equal(t, 30*time.Minute, HandlerSettings{}.WaitTimeout())
+	require.Equal(t, 10*time.Minute, HandlerSettings{PublicSettings: PublicSettings{WaitTimeoutInSeconds: 600}}.WaitTimeout())
+}
+
+func Test_scriptEncodingValidate(t *testing.T) {
+	validate := func(s PublicSettings) error {
+		return HandlerSettings{s, ProtectedSettings{}}.validate()
+	}
+
+	require.Nil(t, validate(PublicSettings{Source: &ScriptSource{Script: "bHMK", ScriptEncoding: ScriptEncodingBase64}}))
+	require.Nil(t, validate(PublicSettings{Source: &ScriptSource{Script: "ls", ScriptEncoding: ScriptEncodingNone}}))
+	require.Nil(t, validate(PublicSettings{Source: &ScriptSource{ScriptURI: "https://a/b.sh", ScriptEncoding: ScriptEncodingNone}}))
+	require.ErrorContains(t, validate(PublicSettings{Source: &ScriptSource{Script: "ls", ScriptEncoding: "zip"}}), "'source.scriptEncoding' must be one of none, base64 or gzip+base64")
+	require.ErrorContains(t, validate(PublicSettings{Source: &ScriptSource{ScriptURI: "https://a/b.sh", ScriptEncoding: ScriptEncodingGzipBase64}}), "'source.scriptEncoding' can only be specified with 'source.script'")
+
+	step := StepSettings{Name: "first", Source: &ScriptSource{Script: "ls", ScriptEncoding: "zip"}}
+	require.ErrorContains(t, validate(PublicSettings{Steps: []StepSettings{step}}), "'steps[0].source.scriptEncoding' must be one of")
+}
+
+func Test_parameterResolvedValue(t *testing.T) {
+	resolve := func(p ParameterDefinition) string {
+		value, err := p.ResolvedValue()
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return value
+	}
+
+	require.Equal(t, "value", resolve(ParameterDefinition{Name: "a", Value: "value", Default: "default"}))
+	require.Equal(t, "default", resolve(ParameterDefinition{Name: "a", Default: "default"}))
+	require.Equal(t, "", resolve(ParameterDefinition{Name: "a"}))
+	require.Equal(t, "error: is required", resolve(ParameterDefinition{Name: "a", Required: true}))
+	require.Equal(t, "default", resolve(ParameterDefinition{Name: "a", Required: true, Default: "default"}))
+
+	require.Equal(t, "-42", resolve(ParameterDefinition{Value: "-42", Type: ParameterTypeInt}))
+	require.Equal(t, "error: is not an integer", resolve(ParameterDefinition{Value: "4.2", Type: ParameterTypeInt}))
+	require.Equal(t, "false", resolve(ParameterDefinition{Value: "false", Type: ParameterTypeBool}))
+	require.Equal(t, "error: is not a boolean", resolve(ParameterDefinition{Value: "yes", Type: ParameterTypeBool}))
+	require.Equal(t, `{"a":1}`, resolve(ParameterDefinition{Value: `{"a":1}`, Type: ParameterTypeJSON}))
+	require.Equal(t, "error: is not valid JSON", resolve(ParameterDefinition{Value: `{"a":`, Type: ParameterTypeJSON}))
+
+	// the pattern has to match the whole value
+	require.Equal(t, "eastus", resolve(ParameterDefinition{Value: "eastus", AllowedPattern: "eastus|westus"}))
+	require.Equal(t, "error: does not match the allowed pattern", resolve(ParameterDefinition{Value: "eastus2", AllowedPattern: "eastus|westus"}))
+}
+
+func Test_parameterJSONValue(t *testing.T) {
+	jsonValue := func(p ParameterDefinition) string {
+		b, err := p.JSONValue()
+		require.Nil(t, err)
+		return string(b)
+	}
+	require.Equal(t, `"42"`, jsonValue(ParameterDefinition{Value: "42"}))
+	require.Equal(t, `42`, jsonValue(ParameterDefinition{Value: "42", Type: ParameterTypeInt}))
+	require.Equal(t, `true`, jsonValue(ParameterDefinition{Value: "1", Type: ParameterTypeBool}))
+	require.Equal(t, `[1,2]`, jsonValue(ParameterDefinition{Value: "[1,2]", Type: ParameterTypeJSON}))
+	require.Equal(t, `null`, jsonValue(ParameterDefinition{Type: ParameterTypeJSON}))
+
+	_, err := ParameterDefinition{Required: true}.JSONValue()
+	require.NotNil(t, err)
+}
+
+func Test_parametersValidate(t *testing.T) {
+	validate := func(s HandlerSettings) error {
+		s.PublicSettings.Source = &ScriptSource{Script: "date"}
+		return s.validate()
+	}
+
+	require.Nil(t, validate(HandlerSettings{PublicSettings: PublicSettings{Parameters: []ParameterDefinition{{Name: "a", Type: ParameterTypeJSON, AllowedPattern: "[a-z]+"}}}}))
+	require.ErrorContains(t, validate(HandlerSettings{PublicSettings: PublicSettings{Parameters: []ParameterDefinition{{Name: "a", Type: "float"}}}}), "'parameters[0].type' must be one of string, int, bool or json")
+	require.ErrorContains(t, validate(HandlerSettings{ProtectedSettings: ProtectedSettings{ProtectedParameters: []ParameterDefinition{{Name: "a", AllowedPattern: "("}}}}), "'protectedParameters[0].allowedPattern' is not a valid regular expression")
+
+	// values are only checked before the execution
+	cfg := HandlerSettings{PublicSettings: PublicSettings{Parameters: []ParameterDefinition{{Name: "a", Required: true}}}}
+	require.Nil(t, validate(cfg))
+	require.EqualError(t, cfg.ValidateParameters(), "parameter 'a': is required")
+
+	cfg = HandlerSettings{ProtectedSettings: ProtectedSettings{ProtectedParameters: []ParameterDefinition{{Value: "hunter2", Type: ParameterTypeInt}}}}
+	require.EqualError(t, cfg.ValidateParameters(), "protectedParameters[0]: is not an integer")
+}
+
+func Test_protectedParametersInFileValidate(t *testing.T) {
+	s := HandlerSettings{
+		PublicSettings:    PublicSettings{Source: &ScriptSource{Script: "date"}, ProtectedParametersInFile: true},
+		ProtectedSettings: ProtectedSettings{ProtectedParameters: []ParameterDefinition{{Name: "a", Value: "b"}}},
+	}
+	require.Nil(t, s.validate())
+
+	s.ProtectedSettings.ProtectedParameters = append(s.ProtectedSettings.ProtectedParameters, ParameterDefinition{Value: "unnamed"})
+	require.EqualError(t, s.validate(), "'protectedParameters[1].name' has to be specified when 'protectedParametersInFile' is true")
+
+	s.PublicSettings.ProtectedParametersInFile = false
+	require.Nil(t, s.validate())
+}
+
+func Test_templatedArtifactsValidate(t *testing.T) {
+	s := HandlerSettings{
+		PublicSettings:    PublicSettings{Source: &ScriptSource{Script: "date"}, Artifacts: []PublicArtifactSource{{ArtifactId: 1, ArtifactUri: "https://a/config.yaml", Templated: true}}},
+		ProtectedSettings: ProtectedSettings{Artifacts: []ProtectedArtifactSource{{ArtifactId: 1}}},
+	}
+	require.EqualError(t, s.validate(), "'artifacts[0].templated' requires 'templating' to be true")
+
+	s.PublicSettings.Templating = true
+	require.Nil(t, s.validate())
+
+	artifacts, err := s.ReadArtifacts()
+	require.Nil(t, err)
+	require.True(t, artifacts[0].Templated)
+}
+
+func Test_environmentValidate(t *testing.T) {
+	s := HandlerSettings{PublicSettings: PublicSettings{
+		Source:      &ScriptSource{Script: "date"},
+		Environment: map[string]string{"PATH": "/opt/tools/bin:/usr/bin:/bin", "_my_var1": ""},
+	}}
+	require.Nil(t, s.validate())
+
+	s.PublicSettings.Environment = map[string]string{"1VAR": "a"}
+	require.EqualError(t, s.validate(), "'environment' variable '1VAR' must match ^[A-Za-z_][A-Za-z0-9_]*$")
+
+	s.PublicSettings.Environment = map[string]string{"MY-VAR": "a"}
+	require.EqualError(t, s.validate(), "'environment' variable 'MY-VAR' must match ^[A-Za-z_][A-Za-z0-9_]*$")
+
+	s.PublicSettings.Environment = map[string]string{"rc_attempt": "a"}
+	require.EqualError(t, s.validate(), "'environment' variable 'rc_attempt' cannot start with RC_, it is reserved for the handler")
+
+	s.PublicSettings.Environment = map[string]string{"VAR": "a\x00b"}
+	require.EqualError(t, s.validate(), "'environment' variable 'VAR' cannot contain a NUL character")
+
+	s.PublicSettings.Environment = map[string]string{"VAR": "a"}
+	s.ProtectedSettings.ProtectedParameters = []ParameterDefinition{{Name: "VAR", Value: "b"}}
+	require.EqualError(t, s.validate(), "'environment' variable 'VAR' conflicts with the parameter of the same name")
+}
+
+func Test_umaskValidate(t *testing.T) {
+	s := HandlerSettings{PublicSettings: PublicSettings{Source: &ScriptSource{Script: "date"}}}
+	for _, umask := range []string{"", "022", "0027", "0777"} {
+		s.PublicSettings.Umask = umask
+		require.Nil(t, s.validate(), umask)
+	}
+	for _, umask := range []string{"22", "0o22", "0080", "10022", "u=rwx"} {
+		s.PublicSettings.Umask = umask
+		require.EqualError(t, s.validate(), "'umask' must be an octal value matching ^0?[0-7]{3}$", umask)
+	}
+}
+
+func Test_syntaxCheckValidate(t *testing.T) {
+	s := HandlerSettings{PublicSettings: PublicSettings{Source: &ScriptSource{Script: "date"}, SyntaxCheck: true}}
+	require.EqualError(t, s.validate(), "'syntaxCheck' requires 'dryRun' to be true")
+
+	s.PublicSettings.DryRun = true
+	require.Nil(t, s.validate())
+}
+
+func Test_historyValidate(t *testing.T) {
+	s := HandlerSettings{PublicSettings: PublicSettings{Source: &ScriptSource{Script: "date"}, History: &HistoryRetention{MaxRecords: 10, MaxAgeInDays: 0, MaxSizeInKB: 64}}}
+	require.Nil(t, s.validate())
+
+	s.PublicSettings.History.MaxAgeInDays = -1
+	require.EqualError(t, s.validate(), "the limits of 'history' cannot be negative")
+}
+
+func Test_cleanupValidate(t *testing.T) {
+	s := HandlerSettings{PublicSettings: PublicSettings{Source: &ScriptSource{Script: "date"}, Cleanup: &CleanupRetention{KeepLastSequences: 3, MaxSizeInMB: 100}}}
+	require.Nil(t, s.validate())
+	require.Equal(t, &types.CleanupRetention{KeepLastSequences: 3, MaxSize: 100 * 1024 * 1024}, s.PublicSettings.Cleanup.Retention())
+
+	s.PublicSettings.Cleanup.KeepFailuresForDays = -1
+	require.EqualError(t, s.validate(), "the limits of 'cleanup' cannot be negative")
+
+	s.PublicSettings.Cleanup = nil
+	require.Nil(t, s.PublicSettings.Cleanup.Retention(), "the cleanup keeps its default")
+}
@@ -29,7 +29,7 @@ func ReadSettings(configFilePath string) (public, protected map[string]interface
 	// if err != nil {
 	// 	return nil, nil, fmt.Errorf("canot locate settings file: %v", err)
 	// }
-	hs, err := parseHandlerSettingsFile(configFilePath)
+	hs, err := ParseHandlerSettingsFile(configFilePath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error parsing settings file: %v", err)
 	}
@@ -68,9 +68,9 @@ func unmarshalSettings(in interface{}, v interface{}) error {
 	return nil
 }
 
-// parseHandlerSettings parses a handler settings file (e.g. 0.settings) and
+// ParseHandlerSettingsFile parses a handler settings file (e.g. 0.settings) and
 // returns it as a structured object.
-func parseHandlerSettingsFile(path string) (h settings.SettingsCommon, _ error) {
+func ParseHandlerSettingsFile(path string) (h settings.SettingsCommon, _ error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return h, fmt.Errorf("error reading %s: %v", path, err)
@@ -89,6 +89,15 @@ func parseHandlerSettingsFile(path string) (h settings.SettingsCommon, _ error)
 	return f.RuntimeSettings[0].HandlerSettings, nil
 }
 
+// CertificatePaths returns the certificate and the private key decrypting the
+// protected settings of the config folder.
+func CertificatePaths(configFolder, thumbprint string) (crt, prv string) {
+	// go two levels up where certs are placed (/var/lib/waagent)
+	crt = filepath.Join(configFolder, "..", "..", fmt.Sprintf("%s.crt", thumbprint))
+	prv = filepath.Join(configFolder, "..", "..", fmt.Sprintf("%s.prv", thumbprint))
+	return crt, prv
+}
+
 // unmarshalProtectedSettings decodes the protected settings from handler
 // runtime settings JSON file, decrypts it using the certificates and unmarshals
 // into the given struct v.
@@ -105,9 +114,7 @@ func unmarshalProtectedSettings(configFolder string, hs settings.SettingsCommon,
 		return fmt.Errorf("failed to decode base64: %v", err)
 	}
 
-	// go two levels up where certs are placed (/var/lib/waagent)
-	crt := filepath.Join(configFolder, "..", "..", fmt.Sprintf("%s.crt", hs.SettingsCertThumbprint))
-	prv := filepath.Join(configFolder, "..", "..", fmt.Sprintf("%s.prv", hs.SettingsCertThumbprint))
+	crt, prv := CertificatePaths(configFolder, hs.SettingsCertThumbprint)
 
 	// we use os/exec instead of azure-docker-extension/pkg/executil here as
 	// other extension handlers depend on this package for parsing handler
@@ -0,0 +1,131 @@
+package handlersettings
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Types of the parameters
+const (
+	ParameterTypeString = "string"
+	ParameterTypeInt    = "int"
+	ParameterTypeBool   = "bool"
+	ParameterTypeJSON   = "json"
+)
+
+// validateParameterDefinitions checks the metadata of the parameters. Their values are only checked
+// before the execution by ValidateParameters, so that invalid values are reported as user errors.
+func validateParameterDefinitions(name string, parameters []ParameterDefinition) error {
+	for i, p := range parameters {
+		switch p.Type {
+		case "", ParameterTypeString, ParameterTypeInt, ParameterTypeBool, ParameterTypeJSON:
+		default:
+			return fmt.Errorf("'%s[%d].type' must be one of %s, %s, %s or %s", name, i, ParameterTypeString, ParameterTypeInt, ParameterTypeBool, ParameterTypeJSON)
+		}
+		if _, err := p.allowedPattern(); err != nil {
+			return errors.Wrapf(err, "'%s[%d].allowedPattern' is not a valid regular expression", name, i)
+		}
+	}
+	return nil
+}
+
+// EffectiveValue returns the value of the parameter, or its default if the value is empty.
+func (p ParameterDefinition) EffectiveValue() string {
+	if p.Value == "" {
+		return p.Default
+	}
+	return p.Value
+}
+
+// ResolvedValue returns the effective value of the parameter once it is checked against the metadata
+// of the parameter. The errors never include the value, which may be a secret.
+func (p ParameterDefinition) ResolvedValue() (string, error) {
+	value := p.EffectiveValue()
+	if value == "" {
+		if p.Required {
+			return "", errors.New("is required")
+		}
+		return "", nil
+	}
+
+	switch p.Type {
+	case ParameterTypeInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "", errors.New("is not an integer")
+		}
+	case ParameterTypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "", errors.New("is not a boolean")
+		}
+	case ParameterTypeJSON:
+		if !json.Valid([]byte(value)) {
+			return "", errors.New("is not valid JSON")
+		}
+	}
+
+	pattern, err := p.allowedPattern()
+	if err != nil {
+		return "", errors.Wrap(err, "has an invalid allowed pattern")
+	}
+	if pattern != nil && !pattern.MatchString(value) {
+		return "", errors.New("does not match the allowed pattern")
+	}
+	return value, nil
+}
+
+// JSONValue returns the resolved value of the parameter as a JSON value of its type.
+func (p ParameterDefinition) JSONValue() (json.RawMessage, error) {
+	value, err := p.ResolvedValue()
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.Type {
+	case ParameterTypeInt:
+		i, _ := strconv.ParseInt(value, 10, 64)
+		return json.Marshal(i)
+	case ParameterTypeBool:
+		b, _ := strconv.ParseBool(value)
+		return json.Marshal(b)
+	case ParameterTypeJSON:
+		if value == "" {
+			return json.RawMessage("null"), nil
+		}
+		return json.RawMessage(value), nil
+	default:
+		return json.Marshal(value)
+	}
+}
+
+// allowedPattern returns the compiled allowed pattern, which has to match the whole value, or nil if there is none.
+func (p ParameterDefinition) allowedPattern() (*regexp.Regexp, error) {
+	if p.AllowedPattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + p.AllowedPattern + ")$")
+}
+
+// ValidateParameters checks the values of the parameters and the protected parameters against their metadata.
+func (s HandlerSettings) ValidateParameters() error {
+	for _, list := range []struct {
+		name       string
+		parameters []ParameterDefinition
+	}{
+		{"parameters", s.PublicSettings.Parameters},
+		{"protectedParameters", s.ProtectedSettings.ProtectedParameters},
+	} {
+		for i, p := range list.parameters {
+			if _, err := p.ResolvedValue(); err != nil {
+				if p.Name == "" {
+					return errors.Wrapf(err, "%s[%d]", list.name, i)
+				}
+				return errors.Wrapf(err, "parameter '%s'", p.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package handlersettings
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Prefixes of the paths of the fields in the schema issues
+const (
+	publicSettingsField    = "publicSettings"
+	protectedSettingsField = "protectedSettings"
+)
+
+// publicSettingsSchema is the JSON schema of PublicSettings. Fields which are not in the schema are
+// reported as warnings, see ValidateSchema.
+const publicSettingsSchema = `{
+  "$schema": "http://json-schema.org/draft-04/schema#",
+  "title": "Run Command public settings",
+  "type": "object",
+  "additionalProperties": false,
+  "definitions": {
+    "source": {
+      "type": ["object", "null"],
+      "additionalProperties": false,
+      "properties": {
+        "script": {"type": "string"},
+        "scriptUri": {"type": "string"},
+        "scriptEncoding": {"type": "string", "enum": ["", "none", "base64", "gzip+base64"]}
+      }
+    },
+    "parameters": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string"},
+          "value": {"type": "string"},
+          "type": {"type": "string", "enum": ["", "string", "int", "bool", "json"]},
+          "required": {"type": "boolean"},
+          "default": {"type": "string"},
+          "allowedPattern": {"type": "string"}
+        }
+      }
+    },
+    "outputSink": {
+      "type": ["object", "null"],
+      "additionalProperties": false,
+      "properties": {
+        "type": {"type": "string"},
+        "path": {"type": "string"},
+        "address": {"type": "string"},
+        "tag": {"type": "string"},
+        "uri": {"type": "string"},
+        "method": {"type": "string"}
+      }
+    }
+  },
+  "properties": {
+    "source": {"$ref": "#/definitions/source"},
+    "parameters": {"$ref": "#/definitions/parameters"},
+    "runAsUser": {"type": "string"},
+    "outputBlobUri": {"type": "string"},
+    "errorBlobUri": {"type": "string"},
+    "timeoutInSeconds": {"type": "integer", "minimum": 0},
+    "asyncExecution": {"type": "boolean"},
+    "treatFailureAsDeploymentFailure": {"type": "boolean"},
+    "artifacts": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "id": {"type": "integer"},
+          "uri": {"type": "string"},
+          "fileName": {"type": "string"},
+          "templated": {"type": "boolean"}
+        }
+      }
+    },
+    "outputSink": {"$ref": "#/definitions/outputSink"},
+    "errorSink": {"$ref": "#/definitions/outputSink"},
+    "outputHeadBytes": {"type": ["integer", "null"]},
+    "outputTailBytes": {"type": ["integer", "null"]},
+    "successExitCodes": {"type": ["array", "null"], "items": {"type": "integer"}},
+    "failOnStderr": {"type": "boolean"},
+    "outputRules": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string"},
+          "stream": {"type": "string"},
+          "pattern": {"type": "string"},
+          "result": {"type": "string"}
+        }
+      }
+    },
+    "templating": {"type": "boolean"},
+    "protectedParametersInFile": {"type": "boolean"},
+    "environment": {"type": ["object", "null"], "additionalProperties": {"type": "string"}},
+    "inheritEnvironment": {"type": "boolean"},
+    "strictMode": {"type": "boolean"},
+    "loginShell": {"type": "boolean"},
+    "umask": {"type": "string"},
+    "dryRun": {"type": "boolean"},
+    "syntaxCheck": {"type": "boolean"},
+    "retry": {
+      "type": ["object", "null"],
+      "additionalProperties": false,
+      "properties": {
+        "maxAttempts": {"type": "integer"},
+        "delaySeconds": {"type": "integer"},
+        "backoff": {"type": "string"},
+        "retryOnExitCodes": {"type": ["array", "null"], "items": {"type": "integer"}}
+      }
+    },
+    "history": {
+      "type": ["object", "null"],
+      "additionalProperties": false,
+      "properties": {
+        "maxRecords": {"type": "integer", "minimum": 0},
+        "maxAgeInDays": {"type": "integer", "minimum": 0},
+        "maxSizeInKB": {"type": "integer", "minimum": 0}
+      }
+    },
+    "cleanup": {
+      "type": ["object", "null"],
+      "additionalProperties": false,
+      "properties": {
+        "keepLastSequences": {"type": "integer", "minimum": 0},
+        "keepFailuresForDays": {"type": "integer", "minimum": 0},
+        "maxSizeInMB": {"type": "integer", "minimum": 0}
+      }
+    },
+    "steps": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string"},
+          "source": {"$ref": "#/definitions/source"},
+          "parameters": {"$ref": "#/definitions/parameters"},
+          "timeoutInSeconds": {"type": "integer", "minimum": 0},
+          "continueOnError": {"type": "boolean"}
+        }
+      }
+    },
+    "exclusiveLockName": {"type": "string"},
+    "dependsOn": {"type": ["array", "null"], "items": {"type": "string"}},
+    "waitTimeoutInSeconds": {"type": "integer", "minimum": 0},
+    "installAsService": {"type": "boolean"}
+  }
+}`
+
+// protectedSettingsSchema is the JSON schema of ProtectedSettings.
+const protectedSettingsSchema = `{
+  "$schema": "http://json-schema.org/draft-04/schema#",
+  "title": "Run Command protected settings",
+  "type": "object",
+  "additionalProperties": false,
+  "definitions": {
+    "managedIdentity": {
+      "type": ["object", "null"],
+      "additionalProperties": false,
+      "properties": {
+        "objectId": {"type": "string"},
+        "clientId": {"type": "string"}
+      }
+    },
+    "headers": {"type": ["object", "null"], "additionalProperties": {"type": "string"}}
+  },
+  "properties": {
+    "runAsPassword": {"type": "string"},
+    "sourceSASToken": {"type": "string"},
+    "outputBlobSASToken": {"type": "string"},
+    "errorBlobSASToken": {"type": "string"},
+    "protectedParameters": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string"},
+          "value": {"type": "string"},
+          "type": {"type": "string", "enum": ["", "string", "int", "bool", "json"]},
+          "required": {"type": "boolean"},
+          "default": {"type": "string"},
+          "allowedPattern": {"type": "string"}
+        }
+      }
+    },
+    "artifacts": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "id": {"type": "integer"},
+          "sasToken": {"type": "string"},
+          "artifactManagedIdentity": {"$ref": "#/definitions/managedIdentity"}
+        }
+      }
+    },
+    "sourceManagedIdentity": {"$ref": "#/definitions/managedIdentity"},
+    "outputBlobManagedIdentity": {"$ref": "#/definitions/managedIdentity"},
+    "errorBlobManagedIdentity": {"$ref": "#/definitions/managedIdentity"},
+    "outputSinkHeaders": {"$ref": "#/definitions/headers"},
+    "errorSinkHeaders": {"$ref": "#/definitions/headers"}
+  }
+}`
+
+// SchemaIssue is a violation of the JSON schema of the settings by a field.
+type SchemaIssue struct {
+	// Path of the field, e.g. publicSettings.steps[0].timeoutInSeconds
+	Field string
+
+	// Description of the violation. It never includes the value of the field.
+	Description string
+
+	// Unknown is true when the field is not part of the schema. Unknown fields are ignored.
+	Unknown bool
+}
+
+func (i SchemaIssue) String() string {
+	return i.Field + ": " + i.Description
+}
+
+// SchemaError is returned for settings which do not match their JSON schema.
+type SchemaError struct {
+	Issues []SchemaIssue
+}
+
+func (e *SchemaError) Error() string {
+	issues := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		issues[i] = issue.String()
+	}
+	return strings.Join(issues, "; ")
+}
+
+// ValidateSchema validates the public and protected settings against their JSON schema. The issues of unknown
+// fields are warnings, the other issues are errors which make the settings invalid. The issues are sorted by field.
+func ValidateSchema(public, protected map[string]interface{}) (warnings []SchemaIssue, errs []SchemaIssue, _ error) {
+	for _, s := range []struct {
+		field    string
+		schema   string
+		settings map[string]interface{}
+	}{
+		{publicSettingsField, publicSettingsSchema, public},
+		{protectedSettingsField, protectedSettingsSchema, protected},
+	} {
+		if s.settings == nil {
+			continue // no settings are valid settings
+		}
+		issues, err := validateAgainstSchema(s.field, s.schema, s.settings)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, issue := range issues {
+			if issue.Unknown {
+				warnings = append(warnings, issue)
+			} else {
+				errs = append(errs, issue)
+			}
+		}
+	}
+	return warnings, errs, nil
+}
+
+// validateAgainstSchema returns the issues of the settings, whose fields start with prefix.
+func validateAgainstSchema(prefix, schema string, settings map[string]interface{}) ([]SchemaIssue, error) {
+	result, err := gojsonschema.Validate(gojsonschema.NewStringLoader(schema), gojsonschema.NewGoLoader(settings))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to validate %s against the schema", prefix)
+	}
+
+	var issues []SchemaIssue
+	for _, e := range result.Errors() {
+		// The schema validator reports which alternative of an "oneOf" failed, the errors of the fields are enough
+		if e.Type() == "number_one_of" || e.Type() == "number_any_of" {
+			continue
+		}
+		field := fieldPath(prefix, e.Field())
+		if e.Type() != "additional_property_not_allowed" {
+			issues = append(issues, SchemaIssue{Field: field, Description: e.Description()})
+			continue
+		}
+
+		property := fmt.Sprint(e.Details()["property"])
+		issue := SchemaIssue{Field: field + "." + property, Description: "unknown field, it is ignored", Unknown: true}
+		if suggestion := suggestField(schema, e.Field(), property); suggestion != "" {
+			issue.Description = fmt.Sprintf("unknown field, it is ignored. Did you mean '%s'?", suggestion)
+		}
+		issues = append(issues, issue)
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Field < issues[j].Field })
+	return issues, nil
+}
+
+// fieldPath returns the path of the field reported by the schema validator, e.g. steps.0.name, with the prefix
+// and the array indexes in brackets, e.g. publicSettings.steps[0].name.
+func fieldPath(prefix, field string) string {
+	if field == "" || field == gojsonschema.STRING_ROOT_SCHEMA_PROPERTY {
+		return prefix
+	}
+	path := prefix
+	for _, segment := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(segment); err == nil {
+			path += "[" + segment + "]"
+		} else {
+			path += "." + segment
+		}
+	}
+	return path
+}
+
+// suggestField returns the field of the object at the specified path of the schema closest to the unknown field,
+// or an empty string if none is close enough, e.g. timeoutInSeconds for timeoutInSecond.
+func suggestField(schema, path, unknown string) string {
+	var root map[string]interface{}
+	if err := json.Unmarshal([]byte(schema), &root); err != nil {
+		return ""
+	}
+
+	node := root
+	if path != "" && path != gojsonschema.STRING_ROOT_SCHEMA_PROPERTY {
+		for _, segment := range strings.Split(path, ".") {
+			if _, err := strconv.Atoi(segment); err == nil {
+				node = resolveSchemaRef(root, node["items"])
+			} else {
+				properties, _ := node["properties"].(map[string]interface{})
+				node = resolveSchemaRef(root, properties[segment])
+			}
+			if node == nil {
+				return ""
+			}
+		}
+	}
+
+	properties, _ := node["properties"].(map[string]interface{})
+	best, bestDistance := "", 3 // at most 2 edits
+	for name := range properties {
+		d := editDistance(strings.ToLower(name), strings.ToLower(unknown))
+		if d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+// resolveSchemaRef returns the schema of the node, following its $ref to the definitions of the root, if any.
+func resolveSchemaRef(root map[string]interface{}, node interface{}) map[string]interface{} {
+	m, _ := node.(map[string]interface{})
+	if ref, ok := m["$ref"].(string); ok {
+		definitions, _ := root["definitions"].(map[string]interface{})
+		m, _ = definitions[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+	}
+	return m
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,129 @@
+package handlersettings
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func parseJSON(t *testing.T, s string) map[string]interface{} {
+	var m map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(s), &m))
+	return m
+}
+
+func Test_ValidateSchema_validSettings(t *testing.T) {
+	public := parseJSON(t, `{
+		"source": {"script": "ZWNobyBoaQ==", "scriptEncoding": "base64"},
+		"parameters": [{"name": "count", "value": "3", "type": "int", "required": true}],
+		"timeoutInSeconds": 60,
+		"outputHeadBytes": null,
+		"environment": {"PATH": "/usr/bin"},
+		"retry": {"maxAttempts": 3, "retryOnExitCodes": [1, 2]},
+		"steps": [{"name": "first", "source": {"scriptUri": "https://a/b.sh"}, "continueOnError": true}],
+		"artifacts": null
+	}`)
+	protected := parseJSON(t, `{
+		"protectedParameters": [{"name": "secret", "value": "s3cr3t"}],
+		"sourceManagedIdentity": {"clientId": "id"},
+		"outputSinkHeaders": {"Authorization": "Bearer token"}
+	}`)
+
+	warnings, errs, err := ValidateSchema(public, protected)
+	require.Nil(t, err)
+	require.Empty(t, warnings)
+	require.Empty(t, errs)
+
+	warnings, errs, err = ValidateSchema(public, nil)
+	require.Nil(t, err)
+	require.Empty(t, warnings)
+	require.Empty(t, errs)
+}
+
+func Test_ValidateSchema_unknownFields(t *testing.T) {
+	public := parseJSON(t, `{
+		"source": {"script": "date", "scirpt": "date"},
+		"timeoutInSecond": 60,
+		"steps": [{"name": "first"}, {"name": "second", "contineOnError": true}],
+		"somethingElse": true
+	}`)
+
+	warnings, errs, err := ValidateSchema(public, parseJSON(t, `{"runAsPasword": "p"}`))
+	require.Nil(t, err)
+	require.Empty(t, errs)
+	require.Equal(t, []SchemaIssue{
+		{Field: "publicSettings.somethingElse", Description: "unknown field, it is ignored", Unknown: true},
+		{Field: "publicSettings.source.scirpt", Description: "unknown field, it is ignored. Did you mean 'script'?", Unknown: true},
+		{Field: "publicSettings.steps[1].contineOnError", Description: "unknown field, it is ignored. Did you mean 'continueOnError'?", Unknown: true},
+		{Field: "publicSettings.timeoutInSecond", Description: "unknown field, it is ignored. Did you mean 'timeoutInSeconds'?", Unknown: true},
+		{Field: "protectedSettings.runAsPasword", Description: "unknown field, it is ignored. Did you mean 'runAsPassword'?", Unknown: true},
+	}, warnings)
+}
+
+func Test_ValidateSchema_errors(t *testing.T) {
+	public := parseJSON(t, `{
+		"source": {"script": 42},
+		"timeoutInSeconds": "60",
+		"parameters": [{"name": "a", "type": "float"}],
+		"steps": [{"name": "first", "timeoutInSeconds": -1}]
+	}`)
+	protected := parseJSON(t, `{"protectedParameters": [{"name": "secret", "value": 12345}]}`)
+
+	warnings, errs, err := ValidateSchema(public, protected)
+	require.Nil(t, err)
+	require.Empty(t, warnings)
+	fields := make([]string, len(errs))
+	for i, e := range errs {
+		fields[i] = e.Field
+		require.NotContains(t, e.Description, "12345", "values are never reported")
+	}
+	require.Equal(t, []string{
+		"publicSettings.parameters[0].type",
+		"publicSettings.source.script",
+		"publicSettings.steps[0].timeoutInSeconds",
+		"publicSettings.timeoutInSeconds",
+		"protectedSettings.protectedParameters[0].value",
+	}, fields)
+	require.Equal(t, "publicSettings.timeoutInSeconds: Invalid type. Expected: integer, given: string", errs[3].String())
+}
+
+func Test_ValidateSettings(t *testing.T) {
+	h, warnings, err := ValidateSettings(parseJSON(t, `{"source": {"script": "date"}, "timeoutInSecond": 5}`), nil)
+	require.Nil(t, err)
+	require.Equal(t, "date", h.Script())
+	require.Equal(t, 1, len(warnings))
+
+	_, _, err = ValidateSettings(parseJSON(t, `{"source": {"script": "date"}, "timeoutInSeconds": "5"}`), nil)
+	var schemaErr *SchemaError
+	require.True(t, errors.As(err, &schemaErr))
+	require.EqualError(t, err, "json validation error: publicSettings.timeoutInSeconds: Invalid type. Expected: integer, given: string")
+
+	_, _, err = ValidateSettings(parseJSON(t, `{"source": {}}`), nil)
+	require.EqualError(t, err, "invalid configuration: Either 'source.script' or 'source.scriptUri' has to be specified")
+}
+
+func Test_schemaCoversSettings(t *testing.T) {
+	// Every field of the settings is in the schema, a new field without schema would be reported as unknown
+	b, err := json.Marshal(PublicSettings{Source: &ScriptSource{}, Retry: &RetryPolicy{}, OutputSink: &OutputSinkSettings{}, History: &HistoryRetention{}, Cleanup: &CleanupRetention{}})
+	require.Nil(t, err)
+	warnings, errs, err := ValidateSchema(parseJSON(t, string(b)), nil)
+	require.Nil(t, err)
+	require.Empty(t, warnings)
+	require.Empty(t, errs)
+
+	b, err = json.Marshal(ProtectedSettings{SourceManagedIdentity: &RunCommandManagedIdentity{}})
+	require.Nil(t, err)
+	warnings, errs, err = ValidateSchema(nil, parseJSON(t, string(b)))
+	require.Nil(t, err)
+	require.Empty(t, warnings)
+	require.Empty(t, errs)
+}
+
+func Test_editDistance(t *testing.T) {
+	require.Equal(t, 0, editDistance("abc", "abc"))
+	require.Equal(t, 1, editDistance("timeoutInSecond", "timeoutInSeconds"))
+	require.Equal(t, 2, editDistance("scirpt", "script"))
+	require.Equal(t, 3, editDistance("", "abc"))
+}
@@ -1,6 +1,12 @@
 package handlersettings
 
 import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/types"
 	"github.com/pkg/errors"
 )
 
@@ -16,13 +22,26 @@ func (s HandlerSettings) InstallAsService() bool {
 }
 
 func (s HandlerSettings) Script() string {
+	if s.PublicSettings.Source == nil {
+		return ""
+	}
 	return s.PublicSettings.Source.Script
 }
 
 func (s HandlerSettings) ScriptURI() string {
+	if s.PublicSettings.Source == nil {
+		return ""
+	}
 	return s.PublicSettings.Source.ScriptURI
 }
 
+func (s HandlerSettings) ScriptEncoding() string {
+	if s.PublicSettings.Source == nil {
+		return ""
+	}
+	return s.PublicSettings.Source.ScriptEncoding
+}
+
 func (s HandlerSettings) ScriptSAS() string {
 	return s.ProtectedSettings.SourceSASToken
 }
@@ -52,6 +71,7 @@ func (s HandlerSettings) ReadArtifacts() ([]UnifiedArtifact, error) {
 					ArtifactSasToken:        protectedArtifact.ArtifactSasToken,
 					FileName:                publicArtifact.FileName,
 					ArtifactManagedIdentity: protectedArtifact.ArtifactManagedIdentity,
+					Templated:               publicArtifact.Templated,
 				}
 			}
 		}
@@ -64,15 +84,227 @@ func (s HandlerSettings) ReadArtifacts() ([]UnifiedArtifact, error) {
 	return artifacts, nil
 }
 
+// OutputStreamSink returns where the stdout stream should be forwarded to, or nil if
+// it is kept locally only. outputBlobUri is used when no sink is explicitly configured.
+func (s HandlerSettings) OutputStreamSink() *OutputSinkSettings {
+	return streamSink(s.PublicSettings.OutputSink, s.PublicSettings.OutputBlobURI)
+}
+
+// ErrorStreamSink returns where the stderr stream should be forwarded to, or nil if
+// it is kept locally only. errorBlobUri is used when no sink is explicitly configured.
+func (s HandlerSettings) ErrorStreamSink() *OutputSinkSettings {
+	return streamSink(s.PublicSettings.ErrorSink, s.PublicSettings.ErrorBlobURI)
+}
+
+func streamSink(sink *OutputSinkSettings, blobURI string) *OutputSinkSettings {
+	if sink != nil {
+		return sink
+	}
+	if blobURI != "" {
+		return &OutputSinkSettings{Type: OutputSinkAppendBlob}
+	}
+	return nil
+}
+
+// OutputCaptureLimits returns how many bytes from the beginning and from the end of each
+// output stream are reported in the status.
+func (s HandlerSettings) OutputCaptureLimits() (head int, tail int) {
+	head, tail = DefaultOutputHeadBytes, DefaultOutputTailBytes
+	if s.PublicSettings.OutputHeadBytes != nil {
+		head = *s.PublicSettings.OutputHeadBytes
+	}
+	if s.PublicSettings.OutputTailBytes != nil {
+		tail = *s.PublicSettings.OutputTailBytes
+	}
+	return head, tail
+}
+
 // validate makes logical validation on the handlerSettings which already passed
 // the schema validation.
 func (s HandlerSettings) validate() error {
-	// If installAsService is false, then the source has to be specified
-	if !s.PublicSettings.InstallAsService {
+	// If installAsService is false, then either the source or the steps have to be specified
+	if len(s.PublicSettings.Steps) > 0 {
+		if s.PublicSettings.Source != nil {
+			return errors.New("'source' and 'steps' cannot be specified together")
+		}
+		if s.PublicSettings.InstallAsService {
+			return errors.New("'steps' cannot be installed as a service")
+		}
+		if len(s.PublicSettings.Steps) > MaxSteps {
+			return fmt.Errorf("at most %d 'steps' can be specified", MaxSteps)
+		}
+		names := map[string]bool{}
+		for i, step := range s.PublicSettings.Steps {
+			if err := step.validate(i); err != nil {
+				return err
+			}
+			if names[step.Name] {
+				return fmt.Errorf("'steps[%d].name' must be unique", i)
+			}
+			names[step.Name] = true
+		}
+	} else if !s.PublicSettings.InstallAsService {
 		if s.PublicSettings.Source == nil || (s.PublicSettings.Source.Script == "") == (s.PublicSettings.Source.ScriptURI == "") {
 			return errSourceNotSpecified
 		}
 	}
+	if err := s.PublicSettings.Source.validate("source"); err != nil {
+		return err
+	}
+
+	head, tail := s.OutputCaptureLimits()
+	if head < 0 || tail < 0 {
+		return errors.New("'outputHeadBytes' and 'outputTailBytes' cannot be negative")
+	}
+	if head+tail > MaxOutputCaptureBytes {
+		return fmt.Errorf("'outputHeadBytes' and 'outputTailBytes' cannot add up to more than %d bytes", MaxOutputCaptureBytes)
+	}
+
+	for i, rule := range s.PublicSettings.OutputRules {
+		if err := rule.validate(i); err != nil {
+			return err
+		}
+	}
+
+	if err := validateParameterDefinitions("parameters", s.PublicSettings.Parameters); err != nil {
+		return err
+	}
+	if err := validateParameterDefinitions("protectedParameters", s.ProtectedSettings.ProtectedParameters); err != nil {
+		return err
+	}
+	if !s.PublicSettings.Templating {
+		for i, artifact := range s.PublicSettings.Artifacts {
+			if artifact.Templated {
+				return fmt.Errorf("'artifacts[%d].templated' requires 'templating' to be true", i)
+			}
+		}
+	}
+	if s.PublicSettings.ProtectedParametersInFile {
+		for i, p := range s.ProtectedSettings.ProtectedParameters {
+			if p.Name == "" {
+				return fmt.Errorf("'protectedParameters[%d].name' has to be specified when 'protectedParametersInFile' is true", i)
+			}
+		}
+	}
+
+	if err := validateEnvironment(s.PublicSettings.Environment, s.PublicSettings.Parameters, s.ProtectedSettings.ProtectedParameters); err != nil {
+		return err
+	}
+
+	if s.PublicSettings.Umask != "" && !umaskPattern.MatchString(s.PublicSettings.Umask) {
+		return fmt.Errorf("'umask' must be an octal value matching %s", umaskPattern)
+	}
+
+	if s.PublicSettings.SyntaxCheck && !s.PublicSettings.DryRun {
+		return errors.New("'syntaxCheck' requires 'dryRun' to be true")
+	}
+
+	if err := s.PublicSettings.Retry.validate(); err != nil {
+		return err
+	}
+	if err := s.PublicSettings.History.validate(); err != nil {
+		return err
+	}
+	if err := s.PublicSettings.Cleanup.validate(); err != nil {
+		return err
+	}
+
+	if s.PublicSettings.ExclusiveLockName != "" && !runCommandNamePattern.MatchString(s.PublicSettings.ExclusiveLockName) {
+		return fmt.Errorf("'exclusiveLockName' must match %s", runCommandNamePattern)
+	}
+	for i, name := range s.PublicSettings.DependsOn {
+		if !runCommandNamePattern.MatchString(name) {
+			return fmt.Errorf("'dependsOn[%d]' must match %s", i, runCommandNamePattern)
+		}
+	}
+	if s.PublicSettings.WaitTimeoutInSeconds < 0 || s.PublicSettings.WaitTimeoutInSeconds > MaxWaitTimeoutInSeconds {
+		return fmt.Errorf("'waitTimeoutInSeconds' must be between 0 and %d", MaxWaitTimeoutInSeconds)
+	}
+
+	if err := s.PublicSettings.OutputSink.validate("outputSink", s.PublicSettings.OutputBlobURI); err != nil {
+		return err
+	}
+	if err := s.PublicSettings.ErrorSink.validate("errorSink", s.PublicSettings.ErrorBlobURI); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validate checks that the fields required by the sink type are specified. blobURI is
+// the outputBlobUri / errorBlobUri matching the stream of the sink.
+func (sink *OutputSinkSettings) validate(name string, blobURI string) error {
+	if sink == nil {
+		return nil
+	}
+
+	switch sink.Type {
+	case OutputSinkAppendBlob:
+		if blobURI == "" {
+			return fmt.Errorf("'%s' of type '%s' requires the corresponding blob uri to be specified", name, sink.Type)
+		}
+	case OutputSinkLocalFile, OutputSinkSyslog:
+	case OutputSinkHTTP:
+		if sink.URI == "" {
+			return fmt.Errorf("'%s.uri' has to be specified for sink type '%s'", name, sink.Type)
+		}
+		// Every chunk of the output is sent in its own request, a PUT would replace the previous chunks
+		if sink.Method != "" && sink.Method != http.MethodPost {
+			return fmt.Errorf("'%s.method' must be POST, every chunk of the output is appended with its own request", name)
+		}
+	default:
+		return fmt.Errorf("'%s.type' must be one of %s, %s, %s or %s", name, OutputSinkAppendBlob, OutputSinkLocalFile, OutputSinkSyslog, OutputSinkHTTP)
+	}
+	return nil
+}
+
+func (rule OutputRule) validate(i int) error {
+	if rule.Name == "" {
+		return fmt.Errorf("'outputRules[%d].name' has to be specified", i)
+	}
+	if rule.Stream != "" && rule.Stream != OutputRuleStreamStdout && rule.Stream != OutputRuleStreamStderr {
+		return fmt.Errorf("'outputRules[%d].stream' must be either %s or %s", i, OutputRuleStreamStdout, OutputRuleStreamStderr)
+	}
+	if rule.Result != OutputRuleResultFailed && rule.Result != OutputRuleResultSucceeded {
+		return fmt.Errorf("'outputRules[%d].result' must be either %s or %s", i, OutputRuleResultFailed, OutputRuleResultSucceeded)
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return errors.Wrapf(err, "'outputRules[%d].pattern' is not a valid regular expression", i)
+	}
+	return nil
+}
+
+func (step StepSettings) validate(i int) error {
+	if step.Name == "" {
+		return fmt.Errorf("'steps[%d].name' has to be specified", i)
+	}
+	if step.Source == nil || (step.Source.Script == "") == (step.Source.ScriptURI == "") {
+		return fmt.Errorf("either 'steps[%d].source.script' or 'steps[%d].source.scriptUri' has to be specified", i, i)
+	}
+	if err := step.Source.validate(fmt.Sprintf("steps[%d].source", i)); err != nil {
+		return err
+	}
+	if err := validateParameterDefinitions(fmt.Sprintf("steps[%d].parameters", i), step.Parameters); err != nil {
+		return err
+	}
+	if step.TimeoutInSeconds < 0 {
+		return fmt.Errorf("'steps[%d].timeoutInSeconds' cannot be negative", i)
+	}
+	return nil
+}
+
+func (p *RetryPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxAttempts < 1 || p.MaxAttempts > MaxRetryAttempts {
+		return fmt.Errorf("'retry.maxAttempts' must be between 1 and %d", MaxRetryAttempts)
+	}
+	if p.DelaySeconds < 0 || p.DelaySeconds > MaxRetryDelaySeconds {
+		return fmt.Errorf("'retry.delaySeconds' must be between 0 and %d", MaxRetryDelaySeconds)
+	}
+	if p.Backoff != "" && p.Backoff != RetryBackoffConstant && p.Backoff != RetryBackoffExponential {
+		return fmt.Errorf("'retry.backoff' must be either %s or %s", RetryBackoffConstant, RetryBackoffExponential)
+	}
 	return nil
 }
 
@@ -91,8 +323,86 @@ type PublicSettings struct {
 	// List of artifacts to download before running the script
 	Artifacts []PublicArtifactSource `json:"artifacts"`
 
+	// Destinations for the stdout and stderr streams. When not specified, the streams are
+	// forwarded to outputBlobUri / errorBlobUri if those are provided.
+	OutputSink *OutputSinkSettings `json:"outputSink"`
+	ErrorSink  *OutputSinkSettings `json:"errorSink"`
+
+	// Number of bytes from the beginning and from the end of stdout and stderr reported in the status.
+	// DefaultOutputHeadBytes / DefaultOutputTailBytes are used when not specified.
+	OutputHeadBytes *int `json:"outputHeadBytes"`
+	OutputTailBytes *int `json:"outputTailBytes"`
+
+	// Exit codes other than 0 which are considered a successful execution, e.g. 3010 for "reboot required"
+	SuccessExitCodes []int `json:"successExitCodes"`
+
+	// When true, the execution is considered failed if the script wrote anything to stderr
+	FailOnStderr bool `json:"failOnStderr,bool"`
+
+	// Rules forcing the result of the execution when a line of output matches. The first matching rule applies
+	// and takes precedence over the exit code and failOnStderr.
+	OutputRules []OutputRule `json:"outputRules"`
+
+	// When true, the script and the artifacts marked as templated are rendered with text/template before the
+	// execution. {{ .Parameters.name }} is replaced by the value of the parameter, quoted for the shell for
+	// protected parameters. The rendered files are stored on disk with the values of the protected parameters,
+	// which are not rendered when protectedParametersInFile is true.
+	Templating bool `json:"templating,bool"`
+
+	// When true, the protected parameters are not exported as environment variables. They are written to a file
+	// on an in-memory file system, readable only by the user running the script, and removed after the execution.
+	ProtectedParametersInFile bool `json:"protectedParametersInFile,bool"`
+
+	// Environment variables set for the script, in addition to the baseline environment and the RC_* variables.
+	Environment map[string]string `json:"environment"`
+
+	// When true, the script inherits the environment of the handler instead of the baseline environment, as
+	// in previous versions. The RC_* variables and environment are still set.
+	InheritEnvironment bool `json:"inheritEnvironment,bool"`
+
+	// When true, bash scripts are executed with 'set -euo pipefail': they fail on the first failed command,
+	// on an unset variable and on a failed command of a pipeline.
+	StrictMode bool `json:"strictMode,bool"`
+
+	// When true, the script is started by a login shell, which sources /etc/profile and the ~/.profile of the
+	// user running the script.
+	LoginShell bool `json:"loginShell,bool"`
+
+	// Octal umask of the script, e.g. "0022". The umask of the handler is used when not specified.
+	Umask string `json:"umask"`
+
+	// When true, enable prepares the execution without executing the script: the script and the artifacts are
+	// downloaded, the parameters and the RunAs user are checked, and the plan is reported in the instance view.
+	DryRun bool `json:"dryRun,bool"`
+
+	// When true, a dry run checks the syntax of the bash scripts with 'bash -n'.
+	SyntaxCheck bool `json:"syntaxCheck,bool"`
+
+	// Policy to execute the script again when it fails. The script is executed once when not specified.
+	Retry *RetryPolicy `json:"retry"`
+
+	// Scripts executed in order instead of the source, in the same working directory.
+	Steps []StepSettings `json:"steps"`
+
+	// Name of a lock held while the run command executes. Run commands with the same lock name never execute at the same time.
+	ExclusiveLockName string `json:"exclusiveLockName"`
+
+	// Names of the run commands whose latest execution has to be completed before this run command executes.
+	DependsOn []string `json:"dependsOn"`
+
+	// How long to wait for the exclusive lock and the run commands in dependsOn. DefaultWaitTimeoutInSeconds is used when not specified.
+	WaitTimeoutInSeconds int `json:"waitTimeoutInSeconds,int"`
+
 	// When the RunCommand extension sees the installAsService == true, it will apply the operations on the service as well.
 	InstallAsService bool `json:"installAsService,bool"`
+
+	// Limits of the history of the executions kept on the VM, applied when this run command is recorded.
+	// The default limits are used when not specified.
+	History *HistoryRetention `json:"history"`
+
+	// Retention of the download directories of the executions, applied by the cleanup after this run command
+	// executes. Only the directory of the most recent execution is kept when not specified.
+	Cleanup *CleanupRetention `json:"cleanup"`
 }
 
 // ProtectedSettings is the type decoded and deserialized from protected
@@ -115,6 +425,10 @@ type ProtectedSettings struct {
 
 	// Managed identity to use for writing the error blob if the VM doesn't have a system managed identity
 	ErrorBlobManagedIdentity *RunCommandManagedIdentity `json:"errorBlobManagedIdentity"`
+
+	// Headers sent with every request of an http output sink. Typically used for authorization.
+	OutputSinkHeaders map[string]string `json:"outputSinkHeaders"`
+	ErrorSinkHeaders  map[string]string `json:"errorSinkHeaders"`
 }
 
 // Contains the public and protected information for the artifact to download
@@ -125,6 +439,7 @@ type UnifiedArtifact struct {
 	FileName                string
 	ArtifactSasToken        string
 	ArtifactManagedIdentity *RunCommandManagedIdentity
+	Templated               bool
 }
 
 // Contains all public information for the artifact. Any sas token will be removed from the uri and added to the ArtifactSource
@@ -133,6 +448,7 @@ type PublicArtifactSource struct {
 	ArtifactId  int    `json:"id"`
 	ArtifactUri string `json:"uri"`
 	FileName    string `json:"fileName"`
+	Templated   bool   `json:"templated,bool"` // text file rendered like the script when templating is true
 }
 
 // Contains secret information about an artifact to download to the VM. This includes the sas token for the uri (located in public settings)
@@ -148,12 +464,206 @@ type RunCommandManagedIdentity struct {
 	ClientId string `json:"clientId"`
 }
 
+// Limits of the output reported in the status, for each of stdout and stderr. The status file
+// of all the run commands is uploaded by the agent, which enforces an overall size limit.
+const (
+	DefaultOutputHeadBytes = 1024
+	DefaultOutputTailBytes = 3 * 1024
+	MaxOutputCaptureBytes  = 16 * 1024
+)
+
+// Supported output sink types
+const (
+	OutputSinkAppendBlob = "appendBlob"
+	OutputSinkLocalFile  = "localFile"
+	OutputSinkSyslog     = "syslog"
+	OutputSinkHTTP       = "http"
+)
+
+// OutputSinkSettings selects where one output stream of the script is forwarded to.
+type OutputSinkSettings struct {
+	Type    string `json:"type"`    // appendBlob, localFile, syslog or http
+	Path    string `json:"path"`    // localFile: directory where the log files are written
+	Address string `json:"address"` // syslog: path of the local socket. The system logger is used when empty
+	Tag     string `json:"tag"`     // syslog: tag of the messages. The run command name is used when empty
+	URI     string `json:"uri"`     // http: endpoint receiving the output
+	Method  string `json:"method"`  // http: only POST, which is also used when empty
+}
+
+// Streams and results of the output rules
+const (
+	OutputRuleStreamStdout = "stdout"
+	OutputRuleStreamStderr = "stderr"
+
+	OutputRuleResultFailed    = "Failed"
+	OutputRuleResultSucceeded = "Succeeded"
+)
+
+// OutputRule forces the result of the execution when a line of output matches its pattern.
+type OutputRule struct {
+	Name    string `json:"name"`    // reported in the instance view when the rule applies
+	Stream  string `json:"stream"`  // stdout or stderr. Both streams are matched when empty
+	Pattern string `json:"pattern"` // regular expression matched against every line of the stream
+	Result  string `json:"result"`  // Failed or Succeeded
+}
+
+// MaxSteps is the maximum number of steps of a run command
+const MaxSteps = 20
+
+// StepSettings is one of the scripts of a multi-step run command. The other settings (e.g. runAsUser, retry or
+// protectedParameters) apply to every step. The sourceSASToken is not used for the steps, their scriptUri have
+// to be public, include a SAS token or be accessible with the sourceManagedIdentity.
+type StepSettings struct {
+	Name             string                `json:"name"`
+	Source           *ScriptSource         `json:"source"`
+	Parameters       []ParameterDefinition `json:"parameters"`
+	TimeoutInSeconds int                   `json:"timeoutInSeconds,int"` // bounded by the timeout of the run command
+	ContinueOnError  bool                  `json:"continueOnError,bool"` // run the next steps even if this one fails
+}
+
+// Limits of the time spent waiting for the exclusive lock and the run commands in dependsOn
+const (
+	DefaultWaitTimeoutInSeconds = 30 * 60
+	MaxWaitTimeoutInSeconds     = 24 * 60 * 60
+)
+
+// runCommandNamePattern matches the names of exclusive locks and run commands. Names are used in file names.
+var runCommandNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// WaitTimeout returns how long to wait for the exclusive lock and the run commands in dependsOn.
+func (s HandlerSettings) WaitTimeout() time.Duration {
+	if s.PublicSettings.WaitTimeoutInSeconds == 0 {
+		return DefaultWaitTimeoutInSeconds * time.Second
+	}
+	return time.Duration(s.PublicSettings.WaitTimeoutInSeconds) * time.Second
+}
+
+// Limits and backoff strategies of the retry policy
+const (
+	MaxRetryAttempts     = 10
+	MaxRetryDelaySeconds = 3600
+
+	RetryBackoffConstant    = "constant"
+	RetryBackoffExponential = "exponential"
+)
+
+// RetryPolicy describes when and how often a failed script is executed again.
+type RetryPolicy struct {
+	MaxAttempts      int    `json:"maxAttempts"`      // number of executions, including the first one
+	DelaySeconds     int    `json:"delaySeconds"`     // delay before the first retry
+	Backoff          string `json:"backoff"`          // constant or exponential. constant is used when empty
+	RetryOnExitCodes []int  `json:"retryOnExitCodes"` // exit codes to retry on. Any non-zero exit code is retried when empty
+}
+
+// Delay returns how long to wait after the specified failed attempt (starting at 1) before the next one.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := time.Duration(p.DelaySeconds) * time.Second
+	if p.Backoff == RetryBackoffExponential {
+		for i := 1; i < attempt && delay < MaxRetryDelaySeconds*time.Second; i++ {
+			delay *= 2
+		}
+	}
+	if delay > MaxRetryDelaySeconds*time.Second {
+		delay = MaxRetryDelaySeconds * time.Second
+	}
+	return delay
+}
+
+// ShouldRetry returns true if a script which terminated with the specified exit code has to be executed again.
+func (p RetryPolicy) ShouldRetry(exitCode int) bool {
+	if len(p.RetryOnExitCodes) == 0 {
+		return exitCode != 0
+	}
+	for _, code := range p.RetryOnExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryRetention limits the records kept in the history of the executions. A limit of 0 uses the default.
+type HistoryRetention struct {
+	MaxRecords   int `json:"maxRecords"`
+	MaxAgeInDays int `json:"maxAgeInDays"`
+	MaxSizeInKB  int `json:"maxSizeInKB"`
+}
+
+func (r *HistoryRetention) validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.MaxRecords < 0 || r.MaxAgeInDays < 0 || r.MaxSizeInKB < 0 {
+		return errors.New("the limits of 'history' cannot be negative")
+	}
+	return nil
+}
+
+// CleanupRetention selects the download directories kept by the cleanup. A limit of 0 uses the default.
+type CleanupRetention struct {
+	KeepLastSequences   int `json:"keepLastSequences"`   // most recent executions kept for every run command
+	KeepFailuresForDays int `json:"keepFailuresForDays"` // failed executions kept regardless of keepLastSequences
+	MaxSizeInMB         int `json:"maxSizeInMB"`         // across the data directory and the copies of the RunAs users
+}
+
+func (r *CleanupRetention) validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.KeepLastSequences < 0 || r.KeepFailuresForDays < 0 || r.MaxSizeInMB < 0 {
+		return errors.New("the limits of 'cleanup' cannot be negative")
+	}
+	return nil
+}
+
+// Retention returns the retention of the cleanup, nil when it is not specified.
+func (r *CleanupRetention) Retention() *types.CleanupRetention {
+	if r == nil {
+		return nil
+	}
+	retention := types.NewCleanupRetention(r.KeepLastSequences, r.KeepFailuresForDays, r.MaxSizeInMB)
+	return &retention
+}
+
+// Encodings of an inline script
+const (
+	ScriptEncodingNone       = "none"
+	ScriptEncodingBase64     = "base64"
+	ScriptEncodingGzipBase64 = "gzip+base64"
+)
+
 type ScriptSource struct {
 	Script    string `json:"script"`
 	ScriptURI string `json:"scriptUri"`
+
+	// Encoding of the script, ScriptEncodingNone when not specified
+	ScriptEncoding string `json:"scriptEncoding"`
+}
+
+// validate checks the encoding of the script. name is the name of the source in the settings.
+func (source *ScriptSource) validate(name string) error {
+	if source == nil {
+		return nil
+	}
+	switch source.ScriptEncoding {
+	case "", ScriptEncodingNone:
+	case ScriptEncodingBase64, ScriptEncodingGzipBase64:
+		if source.Script == "" {
+			return fmt.Errorf("'%s.scriptEncoding' can only be specified with '%s.script'", name, name)
+		}
+	default:
+		return fmt.Errorf("'%s.scriptEncoding' must be one of %s, %s or %s", name, ScriptEncodingNone, ScriptEncodingBase64, ScriptEncodingGzipBase64)
+	}
+	return nil
 }
 
 type ParameterDefinition struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
+
+	// Optional metadata validated before the script is executed, see ParameterDefinition.ResolvedValue
+	Type           string `json:"type"`           // string, int, bool or json. string is used when empty
+	Required       bool   `json:"required,bool"`  // the value or the default has to be specified
+	Default        string `json:"default"`        // value used when the value is empty
+	AllowedPattern string `json:"allowedPattern"` // regular expression the whole value has to match
 }
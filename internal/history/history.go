@@ -0,0 +1,217 @@
+// Package history keeps a record of every execution of the handler on the VM. Unlike the status files and the
+// download directories, which are replaced by newer executions and removed by the cleanup, the records are kept
+// until the retention limits are reached.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FileName is the file of the records under the data directory, one JSON object per line, oldest first
+	FileName = "history.jsonl"
+
+	lockFileExtension = ".lock"
+)
+
+// Default limits of the retention, used for the limits which are not specified
+const (
+	DefaultMaxRecords   = 1000
+	DefaultMaxAgeInDays = 90
+	DefaultMaxSizeInKB  = 1024
+)
+
+// Record describes an execution. It never contains the parameters or the output, only their digests.
+type Record struct {
+	Name      string               `json:"name"`
+	SeqNum    int                  `json:"seqNum"`
+	User      string               `json:"user,omitempty"`
+	StartTime string               `json:"startTime"`
+	EndTime   string               `json:"endTime"`
+	State     types.ExecutionState `json:"state"`
+	ExitCode  int                  `json:"exitCode"`
+
+	// SHA-256 of the executed script, or of the script of every step of a multi-step run command
+	ScriptSHA256 []string `json:"scriptSha256,omitempty"`
+
+	// SHA-256 of the standard output and of the standard error of the execution
+	OutputSHA256 string `json:"outputSha256,omitempty"`
+	ErrorSHA256  string `json:"errorSha256,omitempty"`
+}
+
+// Retention limits the records kept in the history. The oldest records are removed first.
+type Retention struct {
+	MaxRecords int
+	MaxAge     time.Duration
+	MaxSize    int64 // in bytes
+}
+
+// NewRetention returns the retention with the specified limits, or the default ones when they are 0.
+func NewRetention(maxRecords, maxAgeInDays, maxSizeInKB int) Retention {
+	if maxRecords == 0 {
+		maxRecords = DefaultMaxRecords
+	}
+	if maxAgeInDays == 0 {
+		maxAgeInDays = DefaultMaxAgeInDays
+	}
+	if maxSizeInKB == 0 {
+		maxSizeInKB = DefaultMaxSizeInKB
+	}
+	return Retention{
+		MaxRecords: maxRecords,
+		MaxAge:     time.Duration(maxAgeInDays) * 24 * time.Hour,
+		MaxSize:    int64(maxSizeInKB) * 1024,
+	}
+}
+
+// Path returns the path of the history under the data directory.
+func Path(dataDir string) string {
+	return filepath.Join(dataDir, FileName)
+}
+
+// Append appends the record to the history and removes the records exceeding the retention.
+func Append(dataDir string, record Record, retention Retention) error {
+	unlock, err := lock(dataDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the record")
+	}
+	f, err := os.OpenFile(Path(dataDir), os.O_CREATE|os.O_WRONLY|os.O_APPEND|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open the history")
+	}
+	_, err = f.Write(append(b, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to append to the history")
+	}
+
+	// The file is only rewritten when records have to be removed
+	records, err := read(Path(dataDir))
+	if err != nil {
+		return err
+	}
+	if kept := retention.apply(records, time.Now()); len(kept) != len(records) {
+		return write(Path(dataDir), kept)
+	}
+	return nil
+}
+
+// Read returns the records of the history, oldest first. The history is empty if it does not exist.
+func Read(dataDir string) ([]Record, error) {
+	unlock, err := lock(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return read(Path(dataDir))
+}
+
+// apply returns the records within the limits of the retention at the specified time.
+func (r Retention) apply(records []Record, now time.Time) []Record {
+	var kept []Record
+	for _, record := range records {
+		if end, err := time.Parse(time.RFC3339, record.EndTime); err == nil && r.MaxAge > 0 && now.Sub(end) > r.MaxAge {
+			continue
+		}
+		kept = append(kept, record)
+	}
+	if r.MaxRecords > 0 && len(kept) > r.MaxRecords {
+		kept = kept[len(kept)-r.MaxRecords:]
+	}
+	if r.MaxSize > 0 {
+		size := int64(0)
+		for i := len(kept) - 1; i >= 0; i-- {
+			b, _ := json.Marshal(kept[i])
+			if size += int64(len(b)) + 1; size > r.MaxSize {
+				kept = kept[i+1:]
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// lock takes the lock of the history, shared by the processes of the handler, and returns the function releasing it.
+func lock(dataDir string) (func(), error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create the data directory")
+	}
+	path := Path(dataDir) + lockFileExtension
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open lock file '%s'", path)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to lock file '%s'", path)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// read parses the records of the file. Lines which are not records, e.g. a line truncated by a crash, are skipped.
+func read(path string) ([]Record, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read the history")
+	}
+
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, len(b)+1)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// write replaces the file with the records, atomically.
+func write(path string, records []Record) error {
+	var buf bytes.Buffer
+	for _, record := range records {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal the record")
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), FileName)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the history")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write the history")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write the history")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "failed to replace the history")
+}
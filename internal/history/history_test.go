@@ -0,0 +1,79 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/run-command-handler-linux/internal/types"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewRetention(t *testing.T) {
+	require.Equal(t, Retention{MaxRecords: 1000, MaxAge: 90 * 24 * time.Hour, MaxSize: 1024 * 1024}, NewRetention(0, 0, 0))
+	require.Equal(t, Retention{MaxRecords: 5, MaxAge: 24 * time.Hour, MaxSize: 2048}, NewRetention(5, 1, 2))
+}
+
+func Test_Append(t *testing.T) {
+	dataDir := t.TempDir()
+	records, err := Read(dataDir)
+	require.Nil(t, err)
+	require.Empty(t, records, "no history yet")
+
+	first := Record{Name: "a", SeqNum: 0, State: types.Succeeded, ScriptSHA256: []string{"123"}, OutputSHA256: "456", User: "root"}
+	second := Record{Name: "b", SeqNum: 3, State: types.Failed, ExitCode: 2}
+	require.Nil(t, Append(dataDir, first, NewRetention(0, 0, 0)))
+	require.Nil(t, Append(dataDir, second, NewRetention(0, 0, 0)))
+
+	records, err = Read(dataDir)
+	require.Nil(t, err)
+	require.Equal(t, []Record{first, second}, records)
+
+	fi, err := os.Stat(Path(dataDir))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600).String(), fi.Mode().String(), "readable by root only")
+}
+
+func Test_Append_retention(t *testing.T) {
+	dataDir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		require.Nil(t, Append(dataDir, Record{Name: "a", SeqNum: i}, Retention{MaxRecords: 3}))
+	}
+	records, err := Read(dataDir)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(records))
+	require.Equal(t, 2, records[0].SeqNum, "the oldest records are removed")
+}
+
+func Test_Read_skipsInvalidLines(t *testing.T) {
+	dataDir := t.TempDir()
+	require.Nil(t, os.WriteFile(Path(dataDir), []byte("{\"name\":\"a\",\"seqNum\":1}\n{\"name\":\"b\",\"se"), 0600))
+	records, err := Read(dataDir)
+	require.Nil(t, err)
+	require.Equal(t, []Record{{Name: "a", SeqNum: 1}}, records)
+}
+
+func Test_Retention_apply(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	record := func(seqNum int, age time.Duration) Record {
+		return Record{Name: "a", SeqNum: seqNum, EndTime: now.Add(-age).Format(time.RFC3339)}
+	}
+	records := []Record{record(0, 72*time.Hour), record(1, 36*time.Hour), record(2, time.Hour), {Name: "a", SeqNum: 3}}
+
+	seqNums := func(records []Record) (s []int) {
+		for _, r := range records {
+			s = append(s, r.SeqNum)
+		}
+		return s
+	}
+	require.Equal(t, []int{0, 1, 2, 3}, seqNums(Retention{}.apply(records, now)), "no limit")
+	require.Equal(t, []int{2, 3}, seqNums(Retention{MaxAge: 24 * time.Hour}.apply(records, now)), "a record without end time is kept")
+	require.Equal(t, []int{1, 2, 3}, seqNums(Retention{MaxRecords: 3}.apply(records, now)))
+
+	b, err := json.Marshal(records[2])
+	require.Nil(t, err)
+	size := int64(len(b)+1) * 2
+	require.Equal(t, []int{2, 3}, seqNums(Retention{MaxSize: size}.apply(records, now)), fmt.Sprintf("%d bytes", size))
+}
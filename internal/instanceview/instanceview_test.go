@@ -35,6 +35,16 @@ func Test_serializeInstanceView(t *testing.T) {
 	require.Equal(t, instanceView, iv)
 }
 
+func Test_serializeInstanceView_result(t *testing.T) {
+	instanceView := types.RunCommandInstanceView{
+		ExecutionState: types.Succeeded,
+		Result:         json.RawMessage(`{"rebootRequired":true}`),
+	}
+	msg, err := SerializeInstanceView(&instanceView)
+	require.Nil(t, err)
+	require.Equal(t, "{\"executionState\":\"Succeeded\",\"executionMessage\":\"\",\"output\":\"\",\"error\":\"\",\"exitCode\":0,\"startTime\":\"\",\"endTime\":\"\",\"result\":{\"rebootRequired\":true}}", msg)
+}
+
 func Test_reportInstanceView(t *testing.T) {
 	instanceView := types.RunCommandInstanceView{
 		ExecutionState:   types.Running,
@@ -34,6 +34,9 @@ StandardError=append:%run_command_output_directory%
 WantedBy=multi-user.target`
 )
 
+// UnitName is the name of the systemd unit of the immediate run command service.
+const UnitName = systemdUnitName
+
 func Register(ctx *log.Context, extensionEvents *extensionevents.ExtensionEventManager) error {
 	if !isSystemdSupported(ctx) {
 		extensionEvents.LogErrorEvent("register", "Systemd not supported. Failed to register service")
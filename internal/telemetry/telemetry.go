@@ -80,6 +80,15 @@ func SendTelemetry(sender *telemetryEventSender, name, version string) func(oper
 	}
 }
 
+// SendErrorTelemetry returns a function sending the failed operations, with the code and the category of their error
+// in the error catalog.
+func SendErrorTelemetry(sender *telemetryEventSender, name, version string) func(operation, message, errorCode, errorCategory string, duration time.Duration) error {
+	return func(operation, message, errorCode, errorCategory string, duration time.Duration) error {
+		e := newErrorTelemetryEvent(name, version, operation, message, errorCode, errorCategory, duration)
+		return sender.send(e)
+	}
+}
+
 func newTelemetryEventSenderWithWriteCloser(writer io.WriteCloser) *telemetryEventSender {
 	return &telemetryEventSender{writer: writer}
 }
@@ -137,3 +146,20 @@ func newTelemetryEvent(name, version, operation, message string, isSuccess bool,
 		},
 	}
 }
+
+// newErrorTelemetryEvent returns the event of a failed operation, with the code and the category of the error as
+// separate parameters.
+func newErrorTelemetryEvent(name, version, operation, message, errorCode, errorCategory string, duration time.Duration) telemetryEvent {
+	e := newTelemetryEvent(name, version, operation, message, false, duration)
+	e.Parameters = append(e.Parameters,
+		telemetryParameterString{
+			Name:  "ErrorCode",
+			Value: errorCode,
+		},
+		telemetryParameterString{
+			Name:  "ErrorCategory",
+			Value: errorCategory,
+		},
+	)
+	return e
+}
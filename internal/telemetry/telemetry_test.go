@@ -134,3 +134,12 @@ func Test_getTelemetryFileName(t *testing.T) {
 	testSubject := getTelemetryFileName()
 	require.True(t, regexp.MustCompile("^/var/lib/waagent/events/\\d{19}\\.tld$").Match([]byte(testSubject)), testSubject)
 }
+
+func Test_newErrorTelemetryEvent(t *testing.T) {
+	testSubject := newErrorTelemetryEvent("--Name--", "--Version--", "--Operation--", "--Message--", "ScriptDownloadFailed", "network", time.Second)
+
+	require.Len(t, testSubject.Parameters, 8)
+	require.Equal(t, telemetryParameterBool{Name: "OperationSuccess", Value: false}, testSubject.Parameters[3])
+	require.Equal(t, telemetryParameterString{Name: "ErrorCode", Value: "ScriptDownloadFailed"}, testSubject.Parameters[6])
+	require.Equal(t, telemetryParameterString{Name: "ErrorCategory", Value: "network"}, testSubject.Parameters[7])
+}
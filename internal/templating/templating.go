@@ -0,0 +1,108 @@
+package templating
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"text/template"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/Azure/run-command-handler-linux/pkg/shellutil"
+	"github.com/pkg/errors"
+)
+
+// Data is what the templates are rendered with. The rendered content may contain secrets,
+// it must never be logged.
+type Data struct {
+	// Values of the named parameters and protected parameters. The values of the protected
+	// parameters are quoted for the shell, see shellutil.Quote.
+	Parameters map[string]string
+
+	// Names of the protected parameters which cannot be rendered
+	withheld []string
+}
+
+// NewData returns the data to render the templates with for the parameters of cfg,
+// which are expected to be valid, see HandlerSettings.ValidateParameters. The rendered files
+// are stored on disk, so the protected parameters are not part of the data when the settings
+// keep them off the disk with protectedParametersInFile.
+func NewData(cfg *handlersettings.HandlerSettings) (Data, error) {
+	data := Data{Parameters: map[string]string{}}
+	for _, p := range cfg.PublicSettings.Parameters {
+		if p.Name == "" {
+			continue
+		}
+		value, err := p.ResolvedValue()
+		if err != nil {
+			return Data{}, errors.Wrapf(err, "parameter '%s'", p.Name)
+		}
+		data.Parameters[p.Name] = value
+	}
+	for _, p := range cfg.ProtectedSettings.ProtectedParameters {
+		if p.Name == "" {
+			continue
+		}
+		if cfg.PublicSettings.ProtectedParametersInFile {
+			data.withheld = append(data.withheld, p.Name)
+			continue
+		}
+		value, err := p.ResolvedValue()
+		if err != nil {
+			return Data{}, errors.Wrapf(err, "protected parameter '%s'", p.Name)
+		}
+		data.Parameters[p.Name] = shellutil.Quote(value)
+	}
+	return data, nil
+}
+
+// Render renders the template with the data. Referencing a parameter which does not exist is an error.
+// The template can quote the values of parameters for the shell with the quote function.
+func Render(name string, text string, data Data) ([]byte, error) {
+	t, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{"quote": shellutil.Quote}).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse template")
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		for _, name := range data.withheld {
+			if strings.Contains(err.Error(), fmt.Sprintf("map has no entry for key %q", name)) {
+				return nil, fmt.Errorf("protected parameter '%s' is not rendered when 'protectedParametersInFile' is true, the script reads it from the file at $RC_PROTECTED_PARAMETERS_FILE", name)
+			}
+		}
+		return nil, errors.Wrap(err, "failed to render template")
+	}
+	return b.Bytes(), nil
+}
+
+// RenderFile replaces the content of the file at path, which cannot be larger than maxSize, by its
+// rendering with the data. The file is left unchanged if it cannot be rendered.
+func RenderFile(path string, name string, data Data, maxSize int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return errors.Wrap(err, "failed to read file")
+	}
+	if int64(len(b)) > maxSize {
+		return fmt.Errorf("file exceeds the maximum size of %d bytes for templates", maxSize)
+	}
+
+	rendered, err := Render(name, string(b), data)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return errors.Wrap(err, "failed to truncate file")
+	}
+	if _, err := f.WriteAt(rendered, 0); err != nil {
+		return errors.Wrap(err, "failed to write file")
+	}
+	return nil
+}
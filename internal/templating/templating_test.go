@@ -0,0 +1,97 @@
+package templating
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/run-command-handler-linux/internal/handlersettings"
+	"github.com/stretchr/testify/require"
+)
+
+var testSettings = handlersettings.HandlerSettings{
+	PublicSettings: handlersettings.PublicSettings{Parameters: []handlersettings.ParameterDefinition{
+		{Name: "region", Value: "eastus"},
+		{Name: "replicas", Default: "3", Type: handlersettings.ParameterTypeInt},
+		{Value: "unnamed"},
+	}},
+	ProtectedSettings: handlersettings.ProtectedSettings{ProtectedParameters: []handlersettings.ParameterDefinition{
+		{Name: "password", Value: "it's $ecret"},
+	}},
+}
+
+func Test_newData(t *testing.T) {
+	data, err := NewData(&testSettings)
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{
+		"region":   "eastus",
+		"replicas": "3",
+		"password": `'it'\''s $ecret'`,
+	}, data.Parameters)
+
+	_, err = NewData(&handlersettings.HandlerSettings{PublicSettings: handlersettings.PublicSettings{
+		Parameters: []handlersettings.ParameterDefinition{{Name: "region", Required: true}},
+	}})
+	require.EqualError(t, err, "parameter 'region': is required")
+}
+
+func Test_newData_protectedParametersInFile(t *testing.T) {
+	cfg := testSettings
+	cfg.PublicSettings.ProtectedParametersInFile = true
+	data, err := NewData(&cfg)
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{"region": "eastus", "replicas": "3"}, data.Parameters, "the protected parameters are kept off the disk")
+
+	_, err = Render("script.sh", `deploy --password {{ .Parameters.password }}`, data)
+	require.EqualError(t, err, "protected parameter 'password' is not rendered when 'protectedParametersInFile' is true, the script reads it from the file at $RC_PROTECTED_PARAMETERS_FILE")
+
+	_, err = Render("script.sh", `echo {{ .Parameters.missing }}`, data)
+	require.ErrorContains(t, err, `map has no entry for key "missing"`)
+}
+
+func Test_render(t *testing.T) {
+	data, err := NewData(&testSettings)
+	require.Nil(t, err)
+
+	b, err := Render("script.sh", `deploy --region {{ .Parameters.region }} --replicas {{ .Parameters.replicas }} --password {{ .Parameters.password }}`, data)
+	require.Nil(t, err)
+	require.Equal(t, `deploy --region eastus --replicas 3 --password 'it'\''s $ecret'`, string(b))
+
+	b, err = Render("script.sh", `echo {{ quote "a b" }} {{ .Parameters.region | quote }}`, data)
+	require.Nil(t, err)
+	require.Equal(t, `echo 'a b' 'eastus'`, string(b))
+
+	_, err = Render("script.sh", `echo {{ .Parameters.missing }}`, data)
+	require.ErrorContains(t, err, `failed to render template: template: script.sh:1:19: executing "script.sh" at <.Parameters.missing>: map has no entry for key "missing"`)
+
+	_, err = Render("script.sh", `echo {{ .Parameters.region `, data)
+	require.ErrorContains(t, err, "failed to parse template")
+}
+
+func Test_renderFile(t *testing.T) {
+	data, err := NewData(&testSettings)
+	require.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "script.sh")
+
+	require.Nil(t, os.WriteFile(path, []byte("echo {{ .Parameters.region }}\n"), 0500))
+	require.Nil(t, RenderFile(path, "script.sh", data, 1024))
+	b, err := os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "echo eastus\n", string(b))
+	fi, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0500), fi.Mode(), "mode is kept")
+
+	// left unchanged when it cannot be rendered
+	require.Nil(t, os.WriteFile(path, []byte("echo {{ .Parameters.missing }}"), 0500))
+	require.NotNil(t, RenderFile(path, "script.sh", data, 1024))
+	b, err = os.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "echo {{ .Parameters.missing }}", string(b))
+
+	require.EqualError(t, RenderFile(path, "script.sh", data, 10), "file exceeds the maximum size of 10 bytes for templates")
+
+	link := filepath.Join(t.TempDir(), "link.sh")
+	require.Nil(t, os.Symlink(path, link))
+	require.ErrorContains(t, RenderFile(link, "link.sh", data, 1024), "failed to open file")
+}
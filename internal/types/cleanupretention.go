@@ -0,0 +1,29 @@
+package types
+
+import "time"
+
+// Default limits of the retention of the cleanup, used for the limits which are not specified
+const (
+	DefaultKeepLastSequences = 1
+)
+
+// CleanupRetention selects the sequence directories kept in the download folders by the cleanup. The most recent
+// sequence of every run command is always kept.
+type CleanupRetention struct {
+	KeepLastSequences int           // most recent sequences kept for every run command
+	KeepFailuresFor   time.Duration // failed executions kept regardless of KeepLastSequences, none when 0
+	MaxSize           int64         // in bytes, across the data directory and the RunAs copies; no limit when 0
+}
+
+// NewCleanupRetention returns the retention with the specified limits. The default number of sequences is kept
+// when keepLastSequences is 0.
+func NewCleanupRetention(keepLastSequences, keepFailuresForDays, maxSizeInMB int) CleanupRetention {
+	if keepLastSequences == 0 {
+		keepLastSequences = DefaultKeepLastSequences
+	}
+	return CleanupRetention{
+		KeepLastSequences: keepLastSequences,
+		KeepFailuresFor:   time.Duration(keepFailuresForDays) * 24 * time.Hour,
+		MaxSize:           int64(maxSizeInMB) * 1024 * 1024,
+	}
+}
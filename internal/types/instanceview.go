@@ -26,6 +26,12 @@ const (
 
 	// Canceled state when customer canceled the script execution
 	Canceled = "Canceled"
+
+	// Skipped state of a step which did not run because a previous step failed
+	Skipped = "Skipped"
+
+	// Validated state of a dry run which prepared the execution without executing the script
+	Validated = "Validated"
 )
 
 // RunCommandInstanceView reports script execution status
@@ -37,6 +43,68 @@ type RunCommandInstanceView struct {
 	ExitCode         int            `json:"exitCode"`
 	StartTime        string         `json:"startTime"`
 	EndTime          string         `json:"endTime"`
+
+	// Code and category of the failure in the error catalog, when the execution failed
+	ErrorCode     string `json:"errorCode,omitempty"`
+	ErrorCategory string `json:"errorCategory,omitempty"`
+
+	// Number of executions of the script, when the settings have a retry policy
+	Attempts int `json:"attempts,omitempty"`
+
+	// Name of the rule of the settings which decided the result of the execution, if any
+	MatchedRule string `json:"matchedRule,omitempty"`
+
+	// Status of every step of a multi-step run command
+	Steps []StepInstanceView `json:"steps,omitempty"`
+
+	// JSON object written by the script to its result file, and why it was rejected if it was invalid
+	Result      json.RawMessage `json:"result,omitempty"`
+	ResultError string          `json:"resultError,omitempty"`
+
+	// What would be executed, reported by a dry run
+	Plan *ExecutionPlan `json:"plan,omitempty"`
+
+	// What was executed, kept in the history of the VM. It is never reported.
+	Details *ExecutionDetails `json:"-"`
+}
+
+// ExecutionDetails describes what was executed, with digests instead of the content of the files
+type ExecutionDetails struct {
+	User         string
+	ScriptSHA256 []string // one per step for a multi-step run command
+	OutputSHA256 string   // of stdout
+	ErrorSHA256  string   // of stderr
+}
+
+// ExecutionPlan describes the execution prepared by a dry run
+type ExecutionPlan struct {
+	User  string        `json:"user"`
+	Files []PlannedFile `json:"files"`
+}
+
+// PlannedFile is a file prepared by a dry run: the script, a script of a step or an artifact
+type PlannedFile struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"` // name of the step
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+
+	// Interpreter of a script, and whether its syntax was checked
+	Interpreter   string `json:"interpreter,omitempty"`
+	SyntaxChecked bool   `json:"syntaxChecked,omitempty"`
+}
+
+// StepInstanceView reports the execution status of one step of a multi-step run command
+type StepInstanceView struct {
+	Name           string         `json:"name"`
+	ExecutionState ExecutionState `json:"executionState"`
+	ExitCode       int            `json:"exitCode"`
+	Output         string         `json:"output"`
+	Error          string         `json:"error"`
+	StartTime      string         `json:"startTime,omitempty"`
+	EndTime        string         `json:"endTime,omitempty"`
+	Attempts       int            `json:"attempts,omitempty"`
 }
 
 func (instanceView RunCommandInstanceView) Marshal() ([]byte, error) {
@@ -0,0 +1,10 @@
+package shellutil
+
+import "strings"
+
+// Quote returns s quoted for a POSIX shell, so it is interpreted as a single word
+// without any expansion. Every single quote in s closes the quoted string, is
+// escaped with a backslash, and opens a new quoted string.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,23 @@
+package shellutil
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuote(t *testing.T) {
+	require.Equal(t, "''", Quote(""))
+	require.Equal(t, "'value'", Quote("value"))
+	require.Equal(t, `'it'\''s'`, Quote("it's"))
+	require.Equal(t, `'$(rm -rf /) "$HOME" `+"`id`"+`'`, Quote(`$(rm -rf /) "$HOME" `+"`id`"))
+}
+
+func TestQuote_isSingleShellWord(t *testing.T) {
+	for _, s := range []string{"", "it's", `a b	c`, "$(id)", "'; echo injected; '", "line1\nline2", `\'`} {
+		out, err := exec.Command("/bin/bash", "-c", "printf '%s' "+Quote(s)).Output()
+		require.Nil(t, err)
+		require.Equal(t, s, string(out))
+	}
+}